@@ -0,0 +1,586 @@
+package bursary
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInsufficientBalance = errors.New("bursary: insufficient balance")
+	ErrInvalidPayoutAmount = errors.New("bursary: invalid payout amount")
+	ErrBelowMinimumPayout  = errors.New("bursary: payout below minimum")
+	ErrPayoutNotFound      = errors.New("bursary: payout not found")
+	ErrInvalidPayoutState  = errors.New("bursary: invalid payout state")
+)
+
+// PayoutChannel is the channel of ledger entries recording payouts, which
+// must not be used by tickets.
+const PayoutChannel = "payout"
+
+type PayoutState string
+
+const (
+	PayoutRequested PayoutState = "requested"
+	PayoutApproved  PayoutState = "approved"
+	PayoutPaid      PayoutState = "paid"
+	PayoutRejected  PayoutState = "rejected"
+)
+
+// pending reports whether the payout still holds its amount.
+func (s PayoutState) pending() bool {
+	return s == PayoutRequested || s == PayoutApproved
+}
+
+type Payout struct {
+	ID        string      `json:"id"`
+	MemberID  string      `json:"member_id"`
+	Currency  string      `json:"currency"`
+	Amount    int64       `json:"amount"`
+	State     PayoutState `json:"state"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+type Balance struct {
+	MemberID string `json:"member_id"`
+	Currency string `json:"currency"`
+	Total    int64  `json:"total"` // earnings - paid payouts
+	Held     int64  `json:"held"`  // amount of pending payouts
+}
+
+// Available returns the amount which can be requested for payout.
+func (b *Balance) Available() int64 {
+	return b.Total - b.Held
+}
+
+type balanceKey struct {
+	memberID string
+	currency string
+}
+
+// LockingLedger is implemented by ledgers which can lock their storage
+// against every other writer, including other processes. LockContext runs fn
+// with a ledger bound to the lock, so that entries read through it aren't
+// changed by others until entries written through it are committed, which
+// happens only if fn succeeds.
+type LockingLedger interface {
+	LockContext(ctx context.Context, fn func(ctx context.Context, l Ledger) error) error
+}
+
+// balanceBook holds balances and payouts built from ledger entries.
+type balanceBook struct {
+	balances map[balanceKey]*Balance
+	payouts  map[string]*Payout
+}
+
+func newBalanceBook() *balanceBook {
+	return &balanceBook{
+		balances: make(map[balanceKey]*Balance),
+		payouts:  make(map[string]*Payout),
+	}
+}
+
+// BalanceLedger wraps a ledger to keep running balances of members per
+// currency, and to pay them out. Every state of a payout is written to the
+// wrapped ledger as an entry in PayoutChannel, so balances and pending
+// payouts are rebuilt from the ledger when it's used for the first time.
+//
+// If the wrapped ledger is a LockingLedger, payouts are checked against
+// balances read from storage under its lock, so they can't overdraw even if
+// other processes write to the same storage. Otherwise they're checked
+// against balances cached in memory, which only holds in a single process.
+type BalanceLedger struct {
+	Ledger
+	*balanceBook
+
+	mutex      sync.Mutex
+	loaded     bool
+	minPayouts map[string]int64
+}
+
+type BalanceLedgerOpt func(*BalanceLedger)
+
+func NewBalanceLedger(l Ledger, opts ...BalanceLedgerOpt) *BalanceLedger {
+
+	bl := &BalanceLedger{
+		Ledger:      l,
+		balanceBook: newBalanceBook(),
+		minPayouts:  make(map[string]int64),
+	}
+
+	for _, o := range opts {
+		o(bl)
+	}
+
+	return bl
+}
+
+// WithMinimumPayout rejects payout requests in currency below amount.
+func WithMinimumPayout(currency string, amount int64) BalanceLedgerOpt {
+	return func(bl *BalanceLedger) {
+		bl.minPayouts[currency] = amount
+	}
+}
+
+// load rebuilds balances and payouts when used for the first time.
+func (bl *BalanceLedger) load(ctx context.Context) error {
+
+	if bl.loaded {
+		return nil
+	}
+
+	err := bl.Ledger.ScanRecordsContext(ctx, nil, func(le *LedgerEntry) error {
+		bl.apply(le)
+		return nil
+	})
+	if err != nil {
+		bl.balanceBook = newBalanceBook()
+		return err
+	}
+
+	bl.loaded = true
+
+	return nil
+}
+
+// guard runs fn with balances and payouts of member, and the ledger to write
+// to. They're read from storage under the lock of a LockingLedger and then
+// replace those cached, or the cached ones are used otherwise.
+func (bl *BalanceLedger) guard(ctx context.Context, memberID string, fn func(ctx context.Context, bb *balanceBook, l Ledger) error) error {
+
+	ll, ok := bl.Ledger.(LockingLedger)
+	if !ok {
+		return fn(ctx, bl.balanceBook, bl.Ledger)
+	}
+
+	bb := newBalanceBook()
+	err := ll.LockContext(ctx, func(ctx context.Context, l Ledger) error {
+
+		err := l.ScanRecordsContext(ctx, &RecordFilter{MemberID: memberID}, func(le *LedgerEntry) error {
+			bb.apply(le)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return fn(ctx, bb, l)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Others may have written entries of member meanwhile
+	for key := range bl.balances {
+		if key.memberID == memberID {
+			delete(bl.balances, key)
+		}
+	}
+
+	for key, b := range bb.balances {
+		bl.balances[key] = b
+	}
+
+	for id, p := range bb.payouts {
+		bl.payouts[id] = p
+	}
+
+	return nil
+}
+
+// payoutMember returns member of payout id, which may have been requested by
+// others if the ledger is a LockingLedger.
+func (bl *BalanceLedger) payoutMember(ctx context.Context, id string) (string, error) {
+
+	if p, ok := bl.payouts[id]; ok {
+		return p.MemberID, nil
+	}
+
+	if _, ok := bl.Ledger.(LockingLedger); !ok {
+		return "", ErrPayoutNotFound
+	}
+
+	memberID := ""
+	err := bl.Ledger.ScanRecordsContext(ctx, &RecordFilter{Channel: PayoutChannel}, func(le *LedgerEntry) error {
+
+		if le.PrimaryID != id {
+			return nil
+		}
+
+		memberID = le.MemberID
+
+		return ErrStopScan
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(memberID) == 0 {
+		return "", ErrPayoutNotFound
+	}
+
+	return memberID, nil
+}
+
+func (bb *balanceBook) balance(memberID string, currency string) *Balance {
+
+	key := balanceKey{memberID, currency}
+
+	b, ok := bb.balances[key]
+	if !ok {
+		b = &Balance{
+			MemberID: memberID,
+			Currency: currency,
+		}
+		bb.balances[key] = b
+	}
+
+	return b
+}
+
+func (bb *balanceBook) apply(le *LedgerEntry) {
+
+	b := bb.balance(le.MemberID, le.Currency)
+	b.Total += le.Earnings()
+
+	if le.Channel != PayoutChannel {
+		return
+	}
+
+	// State of payout is kept in Desc
+	state := PayoutState(le.Desc)
+
+	p, ok := bb.payouts[le.PrimaryID]
+	if !ok {
+		p = &Payout{
+			ID:        le.PrimaryID,
+			MemberID:  le.MemberID,
+			Currency:  le.Currency,
+			Amount:    le.Amount,
+			CreatedAt: le.CreatedAt,
+		}
+		bb.payouts[p.ID] = p
+	}
+
+	if p.State.pending() {
+		b.Held -= p.Amount
+	}
+
+	if state.pending() {
+		b.Held += p.Amount
+	}
+
+	p.State = state
+	p.UpdatedAt = le.CreatedAt
+}
+
+func (bl *BalanceLedger) WriteRecords(entries []*LedgerEntry) error {
+	return bl.WriteRecordsContext(context.Background(), entries)
+}
+
+func (bl *BalanceLedger) WriteRecordsContext(ctx context.Context, entries []*LedgerEntry) error {
+
+	bl.mutex.Lock()
+	defer bl.mutex.Unlock()
+
+	if err := bl.load(ctx); err != nil {
+		return err
+	}
+
+	err := bl.Ledger.WriteRecordsContext(ctx, entries)
+	if err != nil {
+		return err
+	}
+
+	for _, le := range entries {
+		bl.apply(le)
+	}
+
+	return nil
+}
+
+func (bl *BalanceLedger) GetBalance(memberID string, currency string) (*Balance, error) {
+	return bl.GetBalanceContext(context.Background(), memberID, currency)
+}
+
+func (bl *BalanceLedger) GetBalanceContext(ctx context.Context, memberID string, currency string) (*Balance, error) {
+
+	bl.mutex.Lock()
+	defer bl.mutex.Unlock()
+
+	if err := bl.load(ctx); err != nil {
+		return nil, err
+	}
+
+	if b, ok := bl.balances[balanceKey{memberID, currency}]; ok {
+		c := *b
+		return &c, nil
+	}
+
+	return &Balance{
+		MemberID: memberID,
+		Currency: currency,
+	}, nil
+}
+
+func (bl *BalanceLedger) ListBalances(memberID string) ([]*Balance, error) {
+	return bl.ListBalancesContext(context.Background(), memberID)
+}
+
+// ListBalancesContext returns balances of member in every currency, sorted
+// by currency.
+func (bl *BalanceLedger) ListBalancesContext(ctx context.Context, memberID string) ([]*Balance, error) {
+
+	bl.mutex.Lock()
+	defer bl.mutex.Unlock()
+
+	if err := bl.load(ctx); err != nil {
+		return nil, err
+	}
+
+	balances := make([]*Balance, 0)
+	for key, b := range bl.balances {
+		if key.memberID == memberID {
+			c := *b
+			balances = append(balances, &c)
+		}
+	}
+
+	sort.Slice(balances, func(i, j int) bool {
+		return balances[i].Currency < balances[j].Currency
+	})
+
+	return balances, nil
+}
+
+func (bl *BalanceLedger) RequestPayout(memberID string, currency string, amount int64) (*Payout, error) {
+	return bl.RequestPayoutContext(context.Background(), memberID, currency, amount)
+}
+
+// RequestPayoutContext holds amount from the available balance until the
+// payout is paid or rejected. Requests exceeding the available balance fail
+// with ErrInsufficientBalance, even if they are made concurrently, by other
+// processes as well if the wrapped ledger is a LockingLedger.
+func (bl *BalanceLedger) RequestPayoutContext(ctx context.Context, memberID string, currency string, amount int64) (*Payout, error) {
+
+	if amount <= 0 {
+		return nil, ErrInvalidPayoutAmount
+	}
+
+	if amount < bl.minPayouts[currency] {
+		return nil, ErrBelowMinimumPayout
+	}
+
+	bl.mutex.Lock()
+	defer bl.mutex.Unlock()
+
+	if err := bl.load(ctx); err != nil {
+		return nil, err
+	}
+
+	p := &Payout{
+		ID:       uuid.New().String(),
+		MemberID: memberID,
+		Currency: currency,
+		Amount:   amount,
+	}
+
+	err := bl.guard(ctx, memberID, func(ctx context.Context, bb *balanceBook, l Ledger) error {
+
+		if bb.balance(memberID, currency).Available() < amount {
+			return ErrInsufficientBalance
+		}
+
+		return writePayout(ctx, bb, l, p, PayoutRequested)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c := *bl.payouts[p.ID]
+
+	return &c, nil
+}
+
+func (bl *BalanceLedger) ApprovePayout(id string) (*Payout, error) {
+	return bl.ApprovePayoutContext(context.Background(), id)
+}
+
+func (bl *BalanceLedger) ApprovePayoutContext(ctx context.Context, id string) (*Payout, error) {
+	return bl.transit(ctx, id, PayoutApproved, PayoutRequested)
+}
+
+func (bl *BalanceLedger) PayPayout(id string) (*Payout, error) {
+	return bl.PayPayoutContext(context.Background(), id)
+}
+
+// PayPayoutContext marks an approved payout as paid, which debits the
+// balance by its amount.
+func (bl *BalanceLedger) PayPayoutContext(ctx context.Context, id string) (*Payout, error) {
+	return bl.transit(ctx, id, PayoutPaid, PayoutApproved)
+}
+
+func (bl *BalanceLedger) RejectPayout(id string) (*Payout, error) {
+	return bl.RejectPayoutContext(context.Background(), id)
+}
+
+// RejectPayoutContext releases amount held by a pending payout.
+func (bl *BalanceLedger) RejectPayoutContext(ctx context.Context, id string) (*Payout, error) {
+	return bl.transit(ctx, id, PayoutRejected, PayoutRequested, PayoutApproved)
+}
+
+func (bl *BalanceLedger) GetPayout(id string) (*Payout, error) {
+	return bl.GetPayoutContext(context.Background(), id)
+}
+
+func (bl *BalanceLedger) GetPayoutContext(ctx context.Context, id string) (*Payout, error) {
+
+	bl.mutex.Lock()
+	defer bl.mutex.Unlock()
+
+	if err := bl.load(ctx); err != nil {
+		return nil, err
+	}
+
+	p, ok := bl.payouts[id]
+	if !ok {
+		return nil, ErrPayoutNotFound
+	}
+
+	c := *p
+
+	return &c, nil
+}
+
+func (bl *BalanceLedger) ListPayouts(memberID string) ([]*Payout, error) {
+	return bl.ListPayoutsContext(context.Background(), memberID)
+}
+
+// ListPayoutsContext returns payouts of member in order of request.
+func (bl *BalanceLedger) ListPayoutsContext(ctx context.Context, memberID string) ([]*Payout, error) {
+
+	bl.mutex.Lock()
+	defer bl.mutex.Unlock()
+
+	if err := bl.load(ctx); err != nil {
+		return nil, err
+	}
+
+	payouts := make([]*Payout, 0)
+	for _, p := range bl.payouts {
+		if p.MemberID == memberID {
+			c := *p
+			payouts = append(payouts, &c)
+		}
+	}
+
+	sort.Slice(payouts, func(i, j int) bool {
+
+		if !payouts[i].CreatedAt.Equal(payouts[j].CreatedAt) {
+			return payouts[i].CreatedAt.Before(payouts[j].CreatedAt)
+		}
+
+		return payouts[i].ID < payouts[j].ID
+	})
+
+	return payouts, nil
+}
+
+// transit moves payout to state if it's in one of states from.
+func (bl *BalanceLedger) transit(ctx context.Context, id string, state PayoutState, from ...PayoutState) (*Payout, error) {
+
+	bl.mutex.Lock()
+	defer bl.mutex.Unlock()
+
+	if err := bl.load(ctx); err != nil {
+		return nil, err
+	}
+
+	memberID, err := bl.payoutMember(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Payout
+	err = bl.guard(ctx, memberID, func(ctx context.Context, bb *balanceBook, l Ledger) error {
+
+		p, ok := bb.payouts[id]
+		if !ok {
+			return ErrPayoutNotFound
+		}
+
+		allowed := false
+		for _, s := range from {
+			if p.State == s {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			return ErrInvalidPayoutState
+		}
+
+		err := writePayout(ctx, bb, l, p, state)
+		if err != nil {
+			return err
+		}
+
+		c = *p
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// writePayout records state of payout to l and applies it to bb.
+func writePayout(ctx context.Context, bb *balanceBook, l Ledger, p *Payout, state PayoutState) error {
+
+	le := &LedgerEntry{
+		ID:          uuid.New().String(),
+		Channel:     PayoutChannel,
+		MemberID:    p.MemberID,
+		Contributor: p.MemberID,
+		Amount:      p.Amount,
+		Currency:    p.Currency,
+		Desc:        string(state),
+		PrimaryID:   p.ID,
+		IsPrimary:   state == PayoutRequested,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	// Only paying out debits the balance
+	if state == PayoutPaid {
+		le.Gain = -p.Amount
+		le.Total = -p.Amount
+	}
+
+	err := l.WriteRecordsContext(ctx, []*LedgerEntry{le})
+	if err != nil {
+		return err
+	}
+
+	bb.apply(le)
+
+	return nil
+}
+
+// Close closes the wrapped ledger if it can be closed.
+func (bl *BalanceLedger) Close() error {
+
+	if c, ok := bl.Ledger.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
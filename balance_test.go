@@ -0,0 +1,221 @@
+package bursary
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestEarnings(t *testing.T, l Ledger, memberID string, currency string, gain int64, commissions int64) {
+
+	err := l.WriteRecords([]*LedgerEntry{
+		{
+			ID:          genTestID(),
+			Channel:     "default",
+			MemberID:    memberID,
+			Gain:        gain,
+			Commissions: commissions,
+			Total:       gain + commissions,
+			Currency:    currency,
+			CreatedAt:   time.Now(),
+		},
+	})
+	assert.Nil(t, err)
+}
+
+func Test_BalanceLedger_Balances(t *testing.T) {
+
+	bl := NewBalanceLedger(NewLedgerMemory())
+
+	writeTestEarnings(t, bl, "a", "USD", 300, 20)
+	writeTestEarnings(t, bl, "a", "USD", -100, 0)
+	writeTestEarnings(t, bl, "a", "EUR", 50, 5)
+	writeTestEarnings(t, bl, "b", "USD", 10, 0)
+
+	b, err := bl.GetBalance("a", "USD")
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(220), b.Total)
+		assert.Equal(t, int64(220), b.Available())
+	}
+
+	balances, err := bl.ListBalances("a")
+	if assert.Nil(t, err) && assert.Len(t, balances, 2) {
+		assert.Equal(t, "EUR", balances[0].Currency)
+		assert.Equal(t, int64(55), balances[0].Total)
+		assert.Equal(t, "USD", balances[1].Currency)
+	}
+
+	// Unknown member
+	b, err = bl.GetBalance("c", "USD")
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(0), b.Total)
+	}
+}
+
+func Test_BalanceLedger_Payout(t *testing.T) {
+
+	bl := NewBalanceLedger(NewLedgerMemory(), WithMinimumPayout("USD", 50))
+
+	writeTestEarnings(t, bl, "a", "USD", 300, 0)
+
+	_, err := bl.RequestPayout("a", "USD", 0)
+	assert.ErrorIs(t, err, ErrInvalidPayoutAmount)
+
+	_, err = bl.RequestPayout("a", "USD", 49)
+	assert.ErrorIs(t, err, ErrBelowMinimumPayout)
+
+	_, err = bl.RequestPayout("a", "USD", 301)
+	assert.ErrorIs(t, err, ErrInsufficientBalance)
+
+	p, err := bl.RequestPayout("a", "USD", 200)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, PayoutRequested, p.State)
+
+	// Amount is held
+	b, _ := bl.GetBalance("a", "USD")
+	assert.Equal(t, int64(300), b.Total)
+	assert.Equal(t, int64(200), b.Held)
+
+	_, err = bl.RequestPayout("a", "USD", 101)
+	assert.ErrorIs(t, err, ErrInsufficientBalance)
+
+	// Paying out requires approval
+	_, err = bl.PayPayout(p.ID)
+	assert.ErrorIs(t, err, ErrInvalidPayoutState)
+
+	p, err = bl.ApprovePayout(p.ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, PayoutApproved, p.State)
+	}
+
+	p, err = bl.PayPayout(p.ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, PayoutPaid, p.State)
+	}
+
+	b, _ = bl.GetBalance("a", "USD")
+	assert.Equal(t, int64(100), b.Total)
+	assert.Equal(t, int64(0), b.Held)
+
+	// Paid payouts are final
+	_, err = bl.RejectPayout(p.ID)
+	assert.ErrorIs(t, err, ErrInvalidPayoutState)
+
+	// Rejecting releases the held amount
+	p, err = bl.RequestPayout("a", "USD", 100)
+	if assert.Nil(t, err) {
+		_, err = bl.RejectPayout(p.ID)
+		assert.Nil(t, err)
+	}
+
+	b, _ = bl.GetBalance("a", "USD")
+	assert.Equal(t, int64(100), b.Available())
+
+	_, err = bl.ApprovePayout("unknown")
+	assert.ErrorIs(t, err, ErrPayoutNotFound)
+
+	payouts, err := bl.ListPayouts("a")
+	if assert.Nil(t, err) && assert.Len(t, payouts, 2) {
+		assert.Equal(t, PayoutPaid, payouts[0].State)
+		assert.Equal(t, PayoutRejected, payouts[1].State)
+	}
+}
+
+func Test_BalanceLedger_Concurrent(t *testing.T) {
+
+	bl := NewBalanceLedger(NewLedgerMemory())
+
+	writeTestEarnings(t, bl, "a", "USD", 1000, 0)
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	accepted := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := bl.RequestPayout("a", "USD", 30)
+			if err == nil {
+				mutex.Lock()
+				accepted++
+				mutex.Unlock()
+				return
+			}
+
+			assert.ErrorIs(t, err, ErrInsufficientBalance)
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, 33, accepted)
+
+	b, _ := bl.GetBalance("a", "USD")
+	assert.Equal(t, int64(990), b.Held)
+	assert.Equal(t, int64(10), b.Available())
+}
+
+func Test_BalanceLedger_Reload(t *testing.T) {
+
+	l := NewLedgerMemory()
+	bl := NewBalanceLedger(l)
+
+	writeTestEarnings(t, bl, "a", "USD", 500, 0)
+
+	paid, _ := bl.RequestPayout("a", "USD", 100)
+	bl.ApprovePayout(paid.ID)
+	bl.PayPayout(paid.ID)
+
+	pending, _ := bl.RequestPayout("a", "USD", 150)
+	bl.ApprovePayout(pending.ID)
+
+	// Rebuilt from the ledger
+	bl = NewBalanceLedger(l)
+
+	b, err := bl.GetBalance("a", "USD")
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(400), b.Total)
+		assert.Equal(t, int64(150), b.Held)
+	}
+
+	p, err := bl.GetPayout(pending.ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, PayoutApproved, p.State)
+		assert.Equal(t, int64(150), p.Amount)
+	}
+
+	_, err = bl.PayPayout(pending.ID)
+	assert.Nil(t, err)
+
+	b, _ = bl.GetBalance("a", "USD")
+	assert.Equal(t, int64(250), b.Total)
+	assert.Equal(t, int64(0), b.Held)
+}
+
+func Test_BalanceLedger_Tickets(t *testing.T) {
+
+	bl := NewBalanceLedger(NewLedgerMemory())
+	bu := NewBursary(WithGeneralLedger(bl))
+	r := &Rule{Commission: 0.5, Share: 0.5}
+	ids := addTestChain(t, bu.RelationManager(), "slot", r, r, r)
+
+	ticket := NewTicket()
+	ticket.Channel = "slot"
+	ticket.MemberID = ids[2]
+	ticket.Amount = 1000
+	ticket.Fee = 100
+	ticket.Currency = "USD"
+	assert.Nil(t, bu.WriteTicket(ticket))
+
+	owner, _ := bl.GetBalance(ids[2], "USD")
+	assert.Equal(t, int64(550), owner.Total)
+
+	top, _ := bl.GetBalance(ids[0], "USD")
+	assert.Equal(t, int64(550), top.Total)
+}
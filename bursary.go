@@ -1,7 +1,9 @@
 package bursary
 
 import (
-	"math"
+	"context"
+	"io"
+	"reflect"
 
 	"github.com/google/uuid"
 )
@@ -10,18 +12,41 @@ type Bursary interface {
 	RelationManager() RelationManager
 	LedgerManager() LedgerManager
 	GeneralLedger() Ledger
+	Journal() JournalStore
+	ChannelRegistry() ChannelRegistry
 	GetLevels(memberId string) ([]*Member, error)
 	CalculateRewards(t *Ticket) ([]*LedgerEntry, error)
 	WriteTicket(t *Ticket) error
 	WriteEntry(le *LedgerEntry) error
 	WriteEntries(ledgerName string, entries []*LedgerEntry) error
+	RemoveChannel(channel string) error
+	SetDownstreamRule(upstream string, mid string, channel string, rule *Rule) error
+	ApplyRuleToSubtree(rootMid string, channel string, transform *RuleTransform) ([]*RuleChange, error)
+	Simulate(sim *Simulation) ([]*SimulatedTotal, error)
+
+	// Context-aware variants
+	GetLevelsContext(ctx context.Context, memberId string) ([]*Member, error)
+	CalculateRewardsContext(ctx context.Context, t *Ticket) ([]*LedgerEntry, error)
+	WriteTicketContext(ctx context.Context, t *Ticket) error
+	WriteEntryContext(ctx context.Context, le *LedgerEntry) error
+	WriteEntriesContext(ctx context.Context, ledgerName string, entries []*LedgerEntry) error
+	RemoveChannelContext(ctx context.Context, channel string) error
+	SetDownstreamRuleContext(ctx context.Context, upstream string, mid string, channel string, rule *Rule) error
+	ApplyRuleToSubtreeContext(ctx context.Context, rootMid string, channel string, transform *RuleTransform) ([]*RuleChange, error)
+	SimulateContext(ctx context.Context, sim *Simulation) ([]*SimulatedTotal, error)
+
 	Close() error
 }
 
 type bursary struct {
-	rm RelationManager
-	lm LedgerManager
-	gl Ledger
+	rm        RelationManager
+	lm        LedgerManager
+	gl        Ledger
+	js        JournalStore
+	cr        ChannelRegistry
+	routes    []Route
+	house     string
+	exponents map[string]int
 }
 
 type Opt func(*bursary)
@@ -46,7 +71,16 @@ func NewBursary(opts ...Opt) Bursary {
 	if b.gl == nil {
 		// Using memory to store ledger by default
 		b.gl = NewLedgerMemory()
-		b.lm.Add("general", b.gl)
+		b.lm.Add(GeneralLedgerName, b.gl)
+	} else if _, err := b.lm.Get(GeneralLedgerName); err != nil {
+		// Routes refer to general ledger by name
+		b.lm.Add(GeneralLedgerName, b.gl)
+	}
+
+	if len(b.routes) == 0 {
+		b.routes = []Route{
+			RouteToGeneral(),
+		}
 	}
 
 	return b
@@ -70,10 +104,50 @@ func WithGeneralLedger(l Ledger) Opt {
 	}
 }
 
+// WithLedgerRoutes makes WriteTicket write every entry to the ledgers
+// returned by routes. All entries go to general ledger only by default, and
+// entries of rules with period caps or tiers must still be routed there.
+func WithLedgerRoutes(routes ...Route) Opt {
+	return func(b *bursary) {
+		b.routes = append(b.routes, routes...)
+	}
+}
+
+// WithJournal makes WriteTicket write a double-entry journal transaction of
+// every ticket to js along with ledgers.
+func WithJournal(js JournalStore) Opt {
+	return func(b *bursary) {
+		b.js = js
+	}
+}
+
+// WithChannelRegistry makes CalculateRewards and WriteTicket reject tickets
+// for channels which aren't registered or are disabled in cr, and apply
+// metadata of channels to them.
+func WithChannelRegistry(cr ChannelRegistry) Opt {
+	return func(b *bursary) {
+		b.cr = cr
+	}
+}
+
+// WithHouseAccount makes the operator account houseID sit above root of
+// every tree. It receives residuals of contributions and fees, including
+// rounding remainders, as an entry of its own, and top-level members only
+// get what their rules grant.
+func WithHouseAccount(houseID string) Opt {
+	return func(b *bursary) {
+		b.house = houseID
+	}
+}
+
 func (b *bursary) RelationManager() RelationManager {
 	return b.rm
 }
 
+func (b *bursary) ChannelRegistry() ChannelRegistry {
+	return b.cr
+}
+
 func (b *bursary) LedgerManager() LedgerManager {
 	return b.lm
 }
@@ -82,9 +156,71 @@ func (b *bursary) GeneralLedger() Ledger {
 	return b.gl
 }
 
+// Journal returns nil if no journal store was given.
+func (b *bursary) Journal() JournalStore {
+	return b.js
+}
+
+// Close closes relation manager, channel registry and every ledger which
+// implements io.Closer.
+// All of them are closed even if some fail, and errors are returned as a
+// MultiError.
 func (b *bursary) Close() error {
-	b.rm.Close()
-	return nil
+
+	errs := make(MultiError, 0)
+
+	if err := b.rm.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if b.cr != nil {
+		if err := b.cr.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	ledgers := []Ledger{b.gl}
+
+	names, err := b.lm.List()
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, name := range names {
+
+		l, err := b.lm.Get(name)
+		if err != nil {
+			continue
+		}
+
+		ledgers = append(ledgers, l)
+	}
+
+	// The same ledger can be registered with several names
+	closed := make(map[io.Closer]bool)
+	for _, l := range ledgers {
+
+		c, ok := l.(io.Closer)
+		if !ok {
+			continue
+		}
+
+		// Only comparable ledgers can be told apart
+		if reflect.TypeOf(c).Comparable() {
+
+			if closed[c] {
+				continue
+			}
+
+			closed[c] = true
+		}
+
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs.errorOrNil()
 }
 
 func (b *bursary) AddMember(memberEntry *MemberEntry, upstream string) error {
@@ -94,8 +230,12 @@ func (b *bursary) AddMember(memberEntry *MemberEntry, upstream string) error {
 }
 
 func (b *bursary) GetLevels(memberId string) ([]*Member, error) {
+	return b.GetLevelsContext(context.Background(), memberId)
+}
+
+func (b *bursary) GetLevelsContext(ctx context.Context, memberId string) ([]*Member, error) {
 
-	upstreams, err := b.rm.GetUpstreams(memberId)
+	upstreams, err := b.rm.GetUpstreamsContext(ctx, memberId)
 	if err != nil {
 		return nil, err
 	}
@@ -110,18 +250,70 @@ func (b *bursary) GetLevels(memberId string) ([]*Member, error) {
 }
 
 func (b *bursary) CalculateRewards(t *Ticket) ([]*LedgerEntry, error) {
+	return b.CalculateRewardsContext(context.Background(), t)
+}
 
-	// Find out the edge member
-	m, err := b.rm.GetMember(t.MemberID)
+func (b *bursary) CalculateRewardsContext(ctx context.Context, t *Ticket) ([]*LedgerEntry, error) {
+
+	ch, err := b.resolveChannel(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, _, err := b.calculateRewards(ctx, t, ch)
+
+	return entries, err
+}
+
+// resolveChannel returns registered channel of ticket, or nil without channel
+// registry. Ticket without currency takes currency of the channel.
+func (b *bursary) resolveChannel(ctx context.Context, t *Ticket) (*Channel, error) {
+
+	if b.cr == nil {
+		return nil, nil
+	}
+
+	ch, err := ResolveChannel(ctx, b.cr, t.Channel)
 	if err != nil {
 		return nil, err
 	}
 
+	if len(ch.Currency) > 0 {
+
+		if len(t.Currency) == 0 {
+			t.Currency = ch.Currency
+		}
+
+		if t.Currency != ch.Currency {
+			return nil, ErrCurrencyMismatch
+		}
+	}
+
+	return ch, nil
+}
+
+// calculateRewards calculates entries of ticket in channel ch, which is nil
+// without channel registry. IDs of entries whose rules read general ledger
+// are returned as well.
+func (b *bursary) calculateRewards(ctx context.Context, t *Ticket, ch *Channel) ([]*LedgerEntry, map[string]bool, error) {
+
+	rounding := ch.rounding()
+
+	// Find out the edge member
+	m, err := b.rm.GetMemberContext(ctx, t.MemberID)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Getting rule for specific channel
-	r := m.GetChannelRule(t.Channel)
+	resolved, r := m.resolveChannelRule(t.Channel)
+	scope := ruleScope(t.Channel, resolved)
 	if r == nil {
-		// Using default rule if it doesn't exist
-		r = &DefaultRule
+		// Using default rule of channel or the global one if it doesn't exist
+		r = ch.defaultRule()
+		if r == nil {
+			r = &DefaultRule
+		}
 	}
 
 	// Create a new ledger entry for Calculating rewards for ticket owner
@@ -137,6 +329,7 @@ func (b *bursary) CalculateRewards(t *Ticket) ([]*LedgerEntry, error) {
 		Share:           r.Share,
 		ReturnedShare:   0.0,
 		CommissionShare: r.Commission,
+		Currency:        t.Currency,
 		Desc:            t.Desc,
 		Info:            t.Info,
 		IsPrimary:       true,
@@ -144,24 +337,46 @@ func (b *bursary) CalculateRewards(t *Ticket) ([]*LedgerEntry, error) {
 		CreatedAt:       t.CreatedAt,
 	}
 
+	// Entries read by caps and tiers must be written to general ledger
+	summed := make(map[string]bool)
+	if r.sumsLedger() {
+		summed[le.ID] = true
+	}
+
+	// Shares of the tier reached by volume
+	r, err = b.applyTier(ctx, t, scope, le, r)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Calculate gain and commissions
-	le.Commissions = int64(math.Floor(float64(t.Fee) * r.Commission))
-	le.Gain = int64(math.Floor(float64(t.Amount) * r.Share))
+	le.Commissions = rounding.Round(float64(t.Fee) * r.Commission)
+	le.Gain = rounding.Round(float64(t.Amount) * r.Share)
+
+	// Fixed amounts are paid on top of what the ticket brings
+	le.Fixed = b.fixedAmount(t, r)
+
+	// Excess over caps goes up to the next upstream
+	carryGain, carryCommissions, err := b.limitEarnings(ctx, t, scope, le, r)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	le.Contributions = t.Amount - le.Gain
 
 	// Deduct the delivered parts
 	fee := t.Fee - le.Commissions
 
-	le.Total = le.Amount - le.Gain + le.Commissions
+	le.Total = le.Amount - le.Gain + le.Commissions + le.Fixed
 
 	// Add entry of ticket owner to list
 	entries := make([]*LedgerEntry, 0)
 	entries = append(entries, le)
 
 	// Getting all levels from edge to root
-	levels, err := b.GetLevels(t.MemberID)
+	levels, err := b.GetLevelsContext(ctx, t.MemberID)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Calculating sharing and commissions by levels
@@ -172,7 +387,12 @@ func (b *bursary) CalculateRewards(t *Ticket) ([]*LedgerEntry, error) {
 		downstreamEntry.Upstream = l.ID
 
 		// Getting default rule
-		r := l.GetChannelRule(t.Channel)
+		resolved, r := l.resolveChannelRule(t.Channel)
+		scope := ruleScope(t.Channel, resolved)
+		if r == nil {
+			r = ch.defaultRule()
+		}
+
 		if r == nil {
 			// Using pervious rule if it doesn't exist
 			r = &Rule{
@@ -189,10 +409,12 @@ func (b *bursary) CalculateRewards(t *Ticket) ([]*LedgerEntry, error) {
 			Contributor:     downstreamEntry.ID,
 			Expense:         t.Expense,
 			Income:          t.Income,
+			Fee:             t.Fee,
 			Amount:          t.Amount,
 			Share:           r.Share,
 			ReturnedShare:   0.0,
 			CommissionShare: r.Commission,
+			Currency:        t.Currency,
 			Desc:            t.Desc,
 			Info:            t.Info,
 			IsPrimary:       false,
@@ -200,7 +422,18 @@ func (b *bursary) CalculateRewards(t *Ticket) ([]*LedgerEntry, error) {
 			CreatedAt:       t.CreatedAt,
 		}
 
-		if i != len(levels)-1 {
+		if r.sumsLedger() {
+			summed[le.ID] = true
+		}
+
+		r, err = b.applyTier(ctx, t, scope, le, r)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		le.Fixed = b.fixedAmount(t, r)
+
+		if i != len(levels)-1 || len(b.house) > 0 {
 
 			// Calculate gain and commissions shares
 			commissionShare := (r.Commission*100 - downstreamEntry.CommissionShare*100) / 100
@@ -210,8 +443,19 @@ func (b *bursary) CalculateRewards(t *Ticket) ([]*LedgerEntry, error) {
 			le.ReturnedShare = downstreamRule.ReturnedShare
 
 			// Calculate gain and commissions
-			le.Commissions = int64(math.Floor(float64(t.Fee) * commissionShare))
-			le.Gain = int64(math.Floor(float64(t.Amount) * share))
+			le.Commissions = rounding.Round(float64(t.Fee) * commissionShare)
+			le.Gain = rounding.Round(float64(t.Amount) * share)
+
+			if carryGain != 0 || carryCommissions != 0 {
+				le.Gain += carryGain
+				le.Commissions += carryCommissions
+				addAdjustment(le, AdjustmentCarried, carryGain+carryCommissions)
+			}
+
+			carryGain, carryCommissions, err = b.limitEarnings(ctx, t, scope, le, r)
+			if err != nil {
+				return nil, nil, err
+			}
 
 			fee -= le.Commissions
 
@@ -219,10 +463,23 @@ func (b *bursary) CalculateRewards(t *Ticket) ([]*LedgerEntry, error) {
 			// The top-level agent takes the rest of contributions and cormissions
 			le.Gain = downstreamEntry.Contributions
 			le.Commissions = fee
+
+			// Excess of the top-level agent is left unallocated
+			excessGain, excessCommissions, err := b.limitEarnings(ctx, t, scope, le, r)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if excessGain != 0 || excessCommissions != 0 {
+				setInfo(le, UnallocatedInfoKey, map[string]interface{}{
+					"gain":        excessGain,
+					"commissions": excessCommissions,
+				})
+			}
 		}
 
 		le.Contributions = downstreamEntry.Contributions - le.Gain
-		le.Total = le.Gain + le.Commissions
+		le.Total = le.Gain + le.Commissions + le.Fixed
 
 		entries = append(entries, le)
 
@@ -230,38 +487,149 @@ func (b *bursary) CalculateRewards(t *Ticket) ([]*LedgerEntry, error) {
 		downstreamRule = r
 	}
 
-	return entries, nil
+	if len(b.house) > 0 {
+		entries = append(entries, b.houseEntry(t, downstreamEntry, fee))
+	}
+
+	return entries, summed, nil
+}
+
+// houseEntry gives the rest of contributions and fee to house account.
+func (b *bursary) houseEntry(t *Ticket, downstreamEntry *LedgerEntry, fee int64) *LedgerEntry {
+
+	downstreamEntry.Upstream = b.house
+
+	le := &LedgerEntry{
+		ID:          uuid.New().String(),
+		Channel:     t.Channel,
+		MemberID:    b.house,
+		Contributor: downstreamEntry.ID,
+		Expense:     t.Expense,
+		Income:      t.Income,
+		Fee:         t.Fee,
+		Amount:      t.Amount,
+		Gain:        downstreamEntry.Contributions,
+		Commissions: fee,
+		Currency:    t.Currency,
+		Desc:        t.Desc,
+		Info:        t.Info,
+		IsPrimary:   false,
+		PrimaryID:   t.ID,
+		CreatedAt:   t.CreatedAt,
+	}
+
+	le.Total = le.Gain + le.Commissions
+
+	return le
 }
 
 func (b *bursary) WriteTicket(t *Ticket) error {
+	return b.WriteTicketContext(context.Background(), t)
+}
 
-	entries, err := b.CalculateRewards(t)
+func (b *bursary) WriteTicketContext(ctx context.Context, t *Ticket) error {
+
+	ch, err := b.resolveChannel(ctx, t)
+	if err != nil {
+		return err
+	}
+
+	entries, summed, err := b.calculateRewards(ctx, t, ch)
+	if err != nil {
+		return err
+	}
+
+	// Entries of ticket also go to ledgers of channel
+	extra := make([]Route, 0)
+	if ch != nil && len(ch.Ledgers) > 0 {
+		extra = append(extra, RouteTo(ch.Ledgers...))
+	}
+
+	// Every ledger is resolved before writing anything
+	writes, err := b.routeEntries(ctx, entries, extra...)
+	if err != nil {
+		return err
+	}
+
+	err = checkGeneralRoute(entries, writes, summed)
+	if err != nil {
+		return err
+	}
+
+	if b.js == nil {
+		return fanOut(ctx, writes)
+	}
+
+	jt, err := NewJournalTransaction(t, entries, b.house)
 	if err != nil {
 		return err
 	}
 
-	// Write reward results to general ledger
-	return b.gl.WriteRecords(entries)
+	// Journal is written before staged ledgers are committed
+	return fanOut(ctx, writes, func(ctx context.Context) error {
+		return b.js.WriteTransactionsContext(ctx, []*JournalTransaction{jt})
+	})
 }
 
 func (b *bursary) WriteEntry(le *LedgerEntry) error {
+	return b.WriteEntryContext(context.Background(), le)
+}
+
+func (b *bursary) WriteEntryContext(ctx context.Context, le *LedgerEntry) error {
 
 	// Attempt to find ledger for specific channel
-	l, err := b.lm.Get(le.Channel)
+	l, err := b.lm.GetContext(ctx, le.Channel)
 	if err != nil {
 		return err
 	}
 
-	return l.WriteRecords([]*LedgerEntry{le})
+	return l.WriteRecordsContext(ctx, []*LedgerEntry{le})
 }
 
 func (b *bursary) WriteEntries(ledgerName string, entries []*LedgerEntry) error {
+	return b.WriteEntriesContext(context.Background(), ledgerName, entries)
+}
+
+func (b *bursary) WriteEntriesContext(ctx context.Context, ledgerName string, entries []*LedgerEntry) error {
 
 	// Attempt to find ledger for specific channel
-	l, err := b.lm.Get(ledgerName)
+	l, err := b.lm.GetContext(ctx, ledgerName)
 	if err != nil {
 		return err
 	}
 
-	return l.WriteRecords(entries)
+	return l.WriteRecordsContext(ctx, entries)
+}
+
+func (b *bursary) RemoveChannel(channel string) error {
+	return b.RemoveChannelContext(context.Background(), channel)
+}
+
+// RemoveChannelContext disables channel in channel registry, or every
+// registered channel of a branch pattern such as "casino/*", before removing
+// their rules from all members. Disabled channels are kept in registry, so
+// they can be enabled again.
+func (b *bursary) RemoveChannelContext(ctx context.Context, channel string) error {
+
+	if b.cr != nil {
+
+		channels, err := b.cr.ListChannelsContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, ch := range channels {
+
+			if !ch.Enabled || !MatchChannel(channel, ch.Name) {
+				continue
+			}
+
+			err := b.cr.SetChannelEnabledContext(ctx, ch.Name, false)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return b.rm.RemoveChannelContext(ctx, channel)
 }
@@ -1,6 +1,7 @@
 package bursary
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -694,3 +695,181 @@ func Test_WriteEntries(t *testing.T) {
 		assert.Equal(t, ticket.ID, records[0].PrimaryID)
 	}
 }
+
+func Test_WriteTicketContext_Canceled(t *testing.T) {
+
+	bu := NewBursary()
+	defer bu.Close()
+
+	memberID := genTestID()
+	err := bu.RelationManager().AddMembers([]*MemberEntry{
+		&MemberEntry{
+			ID: memberID,
+			ChannelRules: map[string]*Rule{
+				"default": &Rule{
+					Commission: 1.0,
+					Share:      1.0,
+				},
+			},
+		},
+	}, "")
+	assert.Nil(t, err)
+
+	// Preparing a new ticket
+	ticket := NewTicket()
+	ticket.MemberID = memberID
+	ticket.Amount = 1000
+	ticket.Fee = 50
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Nothing should be written with a canceled context
+	err = bu.WriteTicketContext(ctx, ticket)
+	assert.Equal(t, context.Canceled, err)
+
+	records, err := bu.GeneralLedger().ReadRecordsByMemberID(memberID, NewCondition())
+	assert.Nil(t, err)
+	assert.Len(t, records, 0)
+}
+
+func Test_CalculateRewards_HouseAccount(t *testing.T) {
+
+	bu := NewBursary(WithHouseAccount("house"))
+	defer bu.Close()
+
+	levels := []*MemberEntry{
+		&MemberEntry{
+			ID: genTestID(),
+			ChannelRules: map[string]*Rule{
+				"default": &Rule{
+					Commission: 0.9,
+					Share:      0.8,
+				},
+			},
+		},
+		&MemberEntry{
+			ID: genTestID(),
+			ChannelRules: map[string]*Rule{
+				"default": &Rule{
+					Commission: 0.7,
+					Share:      0.6,
+				},
+			},
+		},
+		&MemberEntry{
+			ID: genTestID(),
+			ChannelRules: map[string]*Rule{
+				"default": &Rule{
+					Commission: 0.5,
+					Share:      0.3,
+				},
+			},
+		},
+	}
+
+	prevLevel := ""
+	for _, l := range levels {
+		err := bu.RelationManager().AddMembers([]*MemberEntry{
+			l,
+		}, prevLevel)
+		assert.Nil(t, err)
+
+		prevLevel = l.ID
+	}
+
+	// Preparing a new ticket
+	ticket := NewTicket()
+	ticket.Channel = "default"
+	ticket.MemberID = levels[2].ID
+	ticket.Amount = 999
+	ticket.Fee = 50
+	ticket.Total = 1049
+
+	entries, err := bu.CalculateRewards(ticket)
+	if !assert.Nil(t, err) || !assert.Len(t, entries, 4) {
+		return
+	}
+
+	// Answer from ticket owner to house account
+	ans := []map[string]int64{
+		map[string]int64{
+			"commission": 25,
+			"gain":       299, // 299.7
+		},
+		map[string]int64{
+			"commission": 10,
+			"gain":       299, // 299.7
+		},
+		// Top-level member only gets what its rule grants
+		map[string]int64{
+			"commission": 10,
+			"gain":       199, // 199.8
+		},
+		// Residuals and rounding remainders
+		map[string]int64{
+			"commission": 5,
+			"gain":       202,
+		},
+	}
+
+	gains := int64(0)
+	commissions := int64(0)
+	for i, entry := range entries {
+		assert.Equal(t, ans[i]["commission"], entry.Commissions)
+		assert.Equal(t, ans[i]["gain"], entry.Gain)
+
+		gains += entry.Gain
+		commissions += entry.Commissions
+	}
+
+	assert.Equal(t, ticket.Amount, gains)
+	assert.Equal(t, ticket.Fee, commissions)
+
+	house := entries[3]
+	assert.Equal(t, "house", house.MemberID)
+	assert.Equal(t, "house", entries[2].Upstream)
+	assert.Equal(t, entries[2].ID, house.Contributor)
+	assert.Equal(t, ticket.ID, house.PrimaryID)
+	assert.Equal(t, int64(207), house.Total)
+
+	// Ticket of top-level member
+	ticket = NewTicket()
+	ticket.Channel = "default"
+	ticket.MemberID = levels[0].ID
+	ticket.Amount = 1000
+	ticket.Fee = 100
+
+	entries, err = bu.CalculateRewards(ticket)
+	if assert.Nil(t, err) && assert.Len(t, entries, 2) {
+		assert.Equal(t, int64(800), entries[0].Gain)
+		assert.Equal(t, int64(90), entries[0].Commissions)
+		assert.Equal(t, int64(200), entries[1].Gain)
+		assert.Equal(t, int64(10), entries[1].Commissions)
+	}
+}
+
+func Test_WriteTicket_HouseAccount(t *testing.T) {
+
+	bu := NewBursary(
+		WithHouseAccount("house"),
+		WithLedgerRoutes(RouteByAgency("agency:")),
+	)
+	defer bu.Close()
+
+	r := &Rule{Commission: 0.5, Share: 0.5}
+	ids := addTestChain(t, bu.RelationManager(), "slot", r, r, r)
+
+	l := NewLedgerMemory()
+	assert.Nil(t, bu.LedgerManager().Add("agency:"+ids[0], l))
+
+	ticket := NewTicket()
+	ticket.Channel = "slot"
+	ticket.MemberID = ids[2]
+	ticket.Amount = 1000
+	ticket.Fee = 100
+	assert.Nil(t, bu.WriteTicket(ticket))
+
+	// House entry goes to ledger of the agency as well
+	assert.Equal(t, 4, countRecords(t, l))
+}
@@ -0,0 +1,157 @@
+package bursarytest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weedbox/bursary/v2"
+)
+
+// ChannelRegistryFactory returns an empty ChannelRegistry for a single test.
+// Cleaning up storage afterwards can be registered with t.Cleanup.
+type ChannelRegistryFactory func(t *testing.T) bursary.ChannelRegistry
+
+// RunChannelRegistrySuite runs the behavioural contract of ChannelRegistry
+// against the implementation created by factory.
+func RunChannelRegistrySuite(t *testing.T, factory ChannelRegistryFactory) {
+
+	t.Run("RegisterChannel", func(t *testing.T) {
+		testRegisterChannel(t, factory(t))
+	})
+
+	t.Run("UpdateChannel", func(t *testing.T) {
+		testUpdateChannel(t, factory(t))
+	})
+
+	t.Run("ListChannels", func(t *testing.T) {
+		testListChannels(t, factory(t))
+	})
+
+	t.Run("SetChannelEnabled", func(t *testing.T) {
+		testSetChannelEnabled(t, factory(t))
+	})
+}
+
+func newTestChannel(name string) *bursary.Channel {
+	return &bursary.Channel{
+		Name:        name,
+		DisplayName: "Channel " + name,
+		Currency:    "USD",
+		Enabled:     true,
+		DefaultRule: &bursary.Rule{
+			Commission: 0.1,
+			Share:      0.2,
+			TicketCap:  1000,
+		},
+		Rounding: bursary.RoundingHalfEven,
+		Ledgers:  []string{"audit"},
+	}
+}
+
+func testRegisterChannel(t *testing.T, cr bursary.ChannelRegistry) {
+
+	ch := newTestChannel("casino/slots")
+
+	err := cr.RegisterChannel(ch)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Names are unique
+	err = cr.RegisterChannel(ch)
+	assert.Equal(t, bursary.ErrChannelExists, err)
+
+	c, err := cr.GetChannel("casino/slots")
+	if assert.Nil(t, err) {
+		assert.Equal(t, ch.Name, c.Name)
+		assert.Equal(t, ch.DisplayName, c.DisplayName)
+		assert.Equal(t, "USD", c.Currency)
+		assert.True(t, c.Enabled)
+		assert.Equal(t, bursary.RoundingHalfEven, c.Rounding)
+		assert.Equal(t, []string{"audit"}, c.Ledgers)
+		assert.False(t, c.CreatedAt.IsZero())
+
+		if assert.NotNil(t, c.DefaultRule) {
+			assert.Equal(t, 0.1, c.DefaultRule.Commission)
+			assert.Equal(t, 0.2, c.DefaultRule.Share)
+			assert.Equal(t, int64(1000), c.DefaultRule.TicketCap)
+		}
+	}
+
+	_, err = cr.GetChannel("casino")
+	assert.Equal(t, bursary.ErrChannelNotFound, err)
+}
+
+func testUpdateChannel(t *testing.T, cr bursary.ChannelRegistry) {
+
+	ch := newTestChannel("sports")
+	if !assert.Nil(t, cr.RegisterChannel(ch)) {
+		return
+	}
+
+	ch.DisplayName = "Sports"
+	ch.DefaultRule = nil
+	ch.Ledgers = nil
+
+	err := cr.UpdateChannel(ch)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	c, err := cr.GetChannel("sports")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "Sports", c.DisplayName)
+		assert.Nil(t, c.DefaultRule)
+		assert.Empty(t, c.Ledgers)
+	}
+
+	err = cr.UpdateChannel(newTestChannel("unknown"))
+	assert.Equal(t, bursary.ErrChannelNotFound, err)
+}
+
+func testListChannels(t *testing.T, cr bursary.ChannelRegistry) {
+
+	for _, name := range []string{"b", "a/x", "a"} {
+		assert.Nil(t, cr.RegisterChannel(newTestChannel(name)))
+	}
+
+	channels, err := cr.ListChannels()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	names := make([]string, 0, len(channels))
+	for _, ch := range channels {
+		names = append(names, ch.Name)
+	}
+
+	// Ordered by name
+	assert.Equal(t, []string{"a", "a/x", "b"}, names)
+}
+
+func testSetChannelEnabled(t *testing.T, cr bursary.ChannelRegistry) {
+
+	if !assert.Nil(t, cr.RegisterChannel(newTestChannel("poker"))) {
+		return
+	}
+
+	err := cr.SetChannelEnabled("poker", false)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	c, err := cr.GetChannel("poker")
+	if assert.Nil(t, err) {
+		assert.False(t, c.Enabled)
+	}
+
+	assert.Nil(t, cr.SetChannelEnabled("poker", true))
+
+	c, err = cr.GetChannel("poker")
+	if assert.Nil(t, err) {
+		assert.True(t, c.Enabled)
+	}
+
+	err = cr.SetChannelEnabled("unknown", false)
+	assert.Equal(t, bursary.ErrChannelNotFound, err)
+}
@@ -0,0 +1,421 @@
+package bursarytest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/weedbox/bursary/v2"
+)
+
+// LedgerFactory returns an empty Ledger for a single test. Cleaning up
+// storage afterwards can be registered with t.Cleanup.
+type LedgerFactory func(t *testing.T) bursary.Ledger
+
+// RunLedgerSuite runs the behavioural contract of Ledger against the
+// implementation created by factory.
+func RunLedgerSuite(t *testing.T, factory LedgerFactory) {
+
+	t.Run("WriteRecords", func(t *testing.T) {
+		testWriteRecords(t, factory(t))
+	})
+
+	t.Run("ReadRecords_Pagination", func(t *testing.T) {
+		testReadRecordsPagination(t, factory(t))
+	})
+
+	t.Run("ReadRecords_Cursor", func(t *testing.T) {
+		testReadRecordsCursor(t, factory(t))
+	})
+
+	t.Run("ReadRecords_TimeRange", func(t *testing.T) {
+		testReadRecordsTimeRange(t, factory(t))
+	})
+
+	t.Run("ScanRecords", func(t *testing.T) {
+		testScanRecords(t, factory(t))
+	})
+
+	t.Run("HashChain", func(t *testing.T) {
+		testHashChain(t, factory(t))
+	})
+
+	t.Run("Context_Canceled", func(t *testing.T) {
+		testLedgerContextCanceled(t, factory(t))
+	})
+}
+
+// testTime is a fixed base time which survives round trips through storage.
+var testTime = time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestEntry(memberID string, createdAt time.Time) *bursary.LedgerEntry {
+	return &bursary.LedgerEntry{
+		ID:              uuid.New().String(),
+		Channel:         "default",
+		Upstream:        uuid.New().String(),
+		MemberID:        memberID,
+		Contributor:     memberID,
+		Amount:          1000,
+		Fee:             50,
+		Share:           0.3,
+		CommissionShare: 0.5,
+		Gain:            300,
+		Commissions:     25,
+		Contributions:   700,
+		Fixed:           5,
+		Total:           730,
+		Currency:        "USD",
+		Desc:            "test",
+		Info: map[string]interface{}{
+			"source": "bursarytest",
+		},
+		PrimaryID: uuid.New().String(),
+		IsPrimary: true,
+		CreatedAt: createdAt,
+		Sequence:  1,
+		PrevHash:  "prev",
+		Hash:      "hash",
+	}
+}
+
+func ledgerEntryIDs(entries []*bursary.LedgerEntry) []string {
+
+	ids := make([]string, 0, len(entries))
+	for _, le := range entries {
+		ids = append(ids, le.ID)
+	}
+
+	return ids
+}
+
+func testWriteRecords(t *testing.T, l bursary.Ledger) {
+
+	memberA := uuid.New().String()
+	memberB := uuid.New().String()
+
+	entries := []*bursary.LedgerEntry{
+		newTestEntry(memberA, testTime),
+		newTestEntry(memberB, testTime),
+		newTestEntry(memberA, testTime),
+	}
+
+	err := l.WriteRecords(entries)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Records of member A in order of writing
+	records, err := l.ReadRecordsByMemberID(memberA, bursary.NewCondition())
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, []string{entries[0].ID, entries[2].ID}, ledgerEntryIDs(records))
+
+	// Fields
+	if assert.Len(t, records, 2) {
+		r := records[0]
+		e := entries[0]
+		assert.Equal(t, e.Channel, r.Channel)
+		assert.Equal(t, e.Upstream, r.Upstream)
+		assert.Equal(t, e.MemberID, r.MemberID)
+		assert.Equal(t, e.Contributor, r.Contributor)
+		assert.Equal(t, e.Amount, r.Amount)
+		assert.Equal(t, e.Fee, r.Fee)
+		assert.Equal(t, e.Share, r.Share)
+		assert.Equal(t, e.CommissionShare, r.CommissionShare)
+		assert.Equal(t, e.Gain, r.Gain)
+		assert.Equal(t, e.Commissions, r.Commissions)
+		assert.Equal(t, e.Contributions, r.Contributions)
+		assert.Equal(t, e.Fixed, r.Fixed)
+		assert.Equal(t, e.Total, r.Total)
+		assert.Equal(t, e.Currency, r.Currency)
+		assert.Equal(t, e.Desc, r.Desc)
+		assert.Equal(t, e.Info["source"], r.Info["source"])
+		assert.Equal(t, e.PrimaryID, r.PrimaryID)
+		assert.Equal(t, e.IsPrimary, r.IsPrimary)
+		assert.True(t, e.CreatedAt.Equal(r.CreatedAt))
+		assert.Equal(t, e.Sequence, r.Sequence)
+		assert.Equal(t, e.PrevHash, r.PrevHash)
+		assert.Equal(t, e.Hash, r.Hash)
+	}
+
+	// Member without records
+	records, err = l.ReadRecordsByMemberID(uuid.New().String(), bursary.NewCondition())
+	if assert.Nil(t, err) {
+		assert.Len(t, records, 0)
+	}
+}
+
+func testReadRecordsPagination(t *testing.T, l bursary.Ledger) {
+
+	memberID := uuid.New().String()
+
+	// Records of other members are interleaved
+	entries := make([]*bursary.LedgerEntry, 0)
+	for i := 0; i < 5; i++ {
+
+		le := newTestEntry(memberID, testTime.Add(time.Duration(i)*time.Minute))
+		err := l.WriteRecords([]*bursary.LedgerEntry{
+			newTestEntry(uuid.New().String(), le.CreatedAt),
+			le,
+		})
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		entries = append(entries, le)
+	}
+
+	expected := [][]string{
+		ledgerEntryIDs(entries[0:2]),
+		ledgerEntryIDs(entries[2:4]),
+		ledgerEntryIDs(entries[4:5]),
+		[]string{},
+	}
+
+	for i, ids := range expected {
+
+		records, err := l.ReadRecordsByMemberID(memberID, &bursary.Condition{
+			Page:  i + 1,
+			Limit: 2,
+		})
+		if !assert.Nil(t, err) {
+			continue
+		}
+
+		assert.Equal(t, ids, ledgerEntryIDs(records))
+	}
+}
+
+func testReadRecordsCursor(t *testing.T, l bursary.Ledger) {
+
+	memberID := uuid.New().String()
+
+	write := func(n int) []*bursary.LedgerEntry {
+
+		entries := make([]*bursary.LedgerEntry, 0)
+		for i := 0; i < n; i++ {
+
+			le := newTestEntry(memberID, testTime)
+			err := l.WriteRecords([]*bursary.LedgerEntry{
+				newTestEntry(uuid.New().String(), testTime),
+				le,
+			})
+			if !assert.Nil(t, err) {
+				t.FailNow()
+			}
+
+			entries = append(entries, le)
+		}
+
+		return entries
+	}
+
+	entries := write(3)
+
+	// Invalid cursor
+	_, err := l.ReadRecordsByMemberID(memberID, &bursary.Condition{
+		Limit: 2,
+		After: "invalid",
+	})
+	assert.Equal(t, bursary.ErrInvalidCursor, err)
+
+	cond := &bursary.Condition{
+		Page:  1,
+		Limit: 2,
+	}
+
+	records, err := l.ReadRecordsByMemberID(memberID, cond)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, ledgerEntryIDs(entries[:2]), ledgerEntryIDs(records))
+	if !assert.NotEmpty(t, cond.NextCursor) {
+		return
+	}
+
+	// Records streaming in don't shift pages
+	entries = append(entries, write(2)...)
+
+	listed := ledgerEntryIDs(records)
+	for i := 0; i < 5 && len(cond.NextCursor) > 0; i++ {
+
+		cond = &bursary.Condition{
+			Limit: 2,
+			After: cond.NextCursor,
+		}
+
+		records, err := l.ReadRecordsByMemberID(memberID, cond)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		listed = append(listed, ledgerEntryIDs(records)...)
+	}
+
+	assert.Equal(t, ledgerEntryIDs(entries), listed)
+	assert.Empty(t, cond.NextCursor)
+}
+
+func testReadRecordsTimeRange(t *testing.T, l bursary.Ledger) {
+
+	memberID := uuid.New().String()
+
+	entries := make([]*bursary.LedgerEntry, 0)
+	for i := 0; i < 5; i++ {
+		entries = append(entries, newTestEntry(memberID, testTime.Add(time.Duration(i)*time.Hour)))
+	}
+
+	err := l.WriteRecords(entries)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Both ends are inclusive
+	cond := bursary.NewCondition()
+	cond.TimeRange = &bursary.TimeRange{
+		StartTime: entries[1].CreatedAt,
+		EndTime:   entries[3].CreatedAt,
+	}
+
+	records, err := l.ReadRecordsByMemberID(memberID, cond)
+	if assert.Nil(t, err) {
+		assert.Equal(t, ledgerEntryIDs(entries[1:4]), ledgerEntryIDs(records))
+	}
+
+	// Open end
+	cond = bursary.NewCondition()
+	cond.TimeRange = &bursary.TimeRange{
+		StartTime: entries[3].CreatedAt,
+	}
+
+	records, err = l.ReadRecordsByMemberID(memberID, cond)
+	if assert.Nil(t, err) {
+		assert.Equal(t, ledgerEntryIDs(entries[3:]), ledgerEntryIDs(records))
+	}
+}
+
+func testScanRecords(t *testing.T, l bursary.Ledger) {
+
+	memberA := uuid.New().String()
+	memberB := uuid.New().String()
+
+	entries := make([]*bursary.LedgerEntry, 0)
+	for i := 0; i < 6; i++ {
+
+		le := newTestEntry(memberA, testTime.Add(time.Duration(i)*time.Hour))
+		if i%2 == 1 {
+			le.MemberID = memberB
+			le.Channel = "slot"
+		}
+
+		entries = append(entries, le)
+	}
+
+	err := l.WriteRecords(entries)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	scan := func(filter *bursary.RecordFilter) []string {
+
+		ids := make([]string, 0)
+		err := l.ScanRecords(filter, func(le *bursary.LedgerEntry) error {
+			ids = append(ids, le.ID)
+			return nil
+		})
+		assert.Nil(t, err)
+
+		return ids
+	}
+
+	// Everything in order of writing
+	assert.Equal(t, ledgerEntryIDs(entries), scan(nil))
+
+	assert.Equal(t, ledgerEntryIDs([]*bursary.LedgerEntry{entries[0], entries[2], entries[4]}), scan(&bursary.RecordFilter{
+		MemberID: memberA,
+	}))
+
+	assert.Equal(t, ledgerEntryIDs([]*bursary.LedgerEntry{entries[1], entries[3], entries[5]}), scan(&bursary.RecordFilter{
+		Channel: "slot",
+	}))
+
+	assert.Equal(t, ledgerEntryIDs(entries[2:4]), scan(&bursary.RecordFilter{
+		TimeRange: &bursary.TimeRange{
+			StartTime: testTime.Add(2 * time.Hour),
+			EndTime:   testTime.Add(3 * time.Hour),
+		},
+	}))
+
+	// Early termination
+	count := 0
+	err = l.ScanRecords(nil, func(le *bursary.LedgerEntry) error {
+
+		count++
+		if count == 2 {
+			return bursary.ErrStopScan
+		}
+
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	// Errors are returned as is
+	errTest := errors.New("test")
+	err = l.ScanRecords(nil, func(le *bursary.LedgerEntry) error {
+		return errTest
+	})
+	assert.Equal(t, errTest, err)
+
+	// Canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = l.ScanRecordsContext(ctx, nil, func(le *bursary.LedgerEntry) error {
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func testHashChain(t *testing.T, l bursary.Ledger) {
+
+	hl := bursary.NewHashChainLedger(l)
+
+	memberID := uuid.New().String()
+	for i := 0; i < 3; i++ {
+
+		err := hl.WriteRecords([]*bursary.LedgerEntry{
+			newTestEntry(memberID, time.Now()),
+		})
+		if !assert.Nil(t, err) {
+			return
+		}
+	}
+
+	// Hashes should survive round trips through storage
+	assert.Nil(t, hl.Verify(0, 0))
+}
+
+func testLedgerContextCanceled(t *testing.T, l bursary.Ledger) {
+
+	memberID := uuid.New().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := l.WriteRecordsContext(ctx, []*bursary.LedgerEntry{
+		newTestEntry(memberID, testTime),
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	records, err := l.ReadRecordsByMemberID(memberID, bursary.NewCondition())
+	if assert.Nil(t, err) {
+		assert.Len(t, records, 0)
+	}
+}
@@ -0,0 +1,944 @@
+// Package bursarytest provides conformance tests which every implementation
+// of bursary interfaces is expected to pass.
+package bursarytest
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weedbox/bursary/v2"
+)
+
+// RelationManagerFactory returns an empty RelationManager for a single test.
+// Cleaning up storage afterwards can be registered with t.Cleanup.
+type RelationManagerFactory func(t *testing.T) bursary.RelationManager
+
+// RunRelationManagerSuite runs the behavioural contract of RelationManager
+// against the implementation created by factory.
+func RunRelationManagerSuite(t *testing.T, factory RelationManagerFactory) {
+
+	t.Run("AddMembers", func(t *testing.T) {
+		testAddMembers(t, factory(t))
+	})
+
+	t.Run("GetPath", func(t *testing.T) {
+		testGetPath(t, factory(t))
+	})
+
+	t.Run("MoveMembers_Deep", func(t *testing.T) {
+		testMoveMembersDeep(t, factory(t))
+	})
+
+	t.Run("DeleteMembers", func(t *testing.T) {
+		testDeleteMembers(t, factory(t))
+	})
+
+	t.Run("ListMembers_Pagination", func(t *testing.T) {
+		testListMembersPagination(t, factory(t))
+	})
+
+	t.Run("ListMembers_Sort", func(t *testing.T) {
+		testListMembersSort(t, factory(t))
+	})
+
+	t.Run("ListMembers_Cursor", func(t *testing.T) {
+		testListMembersCursor(t, factory(t))
+	})
+
+	t.Run("ChannelRules", func(t *testing.T) {
+		testChannelRules(t, factory(t))
+	})
+
+	t.Run("UpdateChannelRules", func(t *testing.T) {
+		testUpdateChannelRules(t, factory(t))
+	})
+
+	t.Run("ChannelRules_Copied", func(t *testing.T) {
+		testChannelRulesCopied(t, factory(t))
+	})
+
+	t.Run("GetUpstreams_Order", func(t *testing.T) {
+		testGetUpstreamsOrder(t, factory(t))
+	})
+
+	t.Run("Descendants", func(t *testing.T) {
+		testDescendants(t, factory(t))
+	})
+}
+
+// addChain adds members level by level below upstream, and returns entries
+// from the top level to the bottom.
+func addChain(t *testing.T, rm bursary.RelationManager, upstream string, depth int) []*bursary.MemberEntry {
+
+	levels := make([]*bursary.MemberEntry, 0, depth)
+
+	prevLevel := upstream
+	for i := 0; i < depth; i++ {
+
+		me := bursary.NewMemberEntry()
+		err := rm.AddMembers([]*bursary.MemberEntry{
+			me,
+		}, prevLevel)
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+
+		levels = append(levels, me)
+		prevLevel = me.ID
+	}
+
+	return levels
+}
+
+func memberIDs(members []*bursary.Member) []string {
+
+	ids := make([]string, 0, len(members))
+	for _, m := range members {
+		ids = append(ids, m.ID)
+	}
+
+	return ids
+}
+
+func entryIDs(entries []*bursary.MemberEntry) []string {
+
+	ids := make([]string, 0, len(entries))
+	for _, me := range entries {
+		ids = append(ids, me.ID)
+	}
+
+	return ids
+}
+
+func testAddMembers(t *testing.T, rm bursary.RelationManager) {
+
+	// Nothing to add
+	err := rm.AddMembers([]*bursary.MemberEntry{}, "")
+	assert.Equal(t, bursary.ErrMemberRequired, err)
+
+	// Upstream doesn't exist
+	err = rm.AddMembers([]*bursary.MemberEntry{
+		bursary.NewMemberEntry(),
+	}, bursary.NewMemberEntry().ID)
+	assert.Equal(t, bursary.ErrUpstreamNotFound, err)
+
+	// Member doesn't exist
+	_, err = rm.GetMember(bursary.NewMemberEntry().ID)
+	assert.Equal(t, bursary.ErrMemberNotFound, err)
+
+	// Add members with rules
+	agency := bursary.NewMemberEntry()
+	agency.ChannelRules["default"] = &bursary.Rule{
+		Commission:    1.0,
+		Share:         0.9,
+		ReturnedShare: 0.1,
+	}
+
+	err = rm.AddMembers([]*bursary.MemberEntry{
+		agency,
+	}, "")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	members := []*bursary.MemberEntry{
+		bursary.NewMemberEntry(),
+		bursary.NewMemberEntry(),
+	}
+
+	err = rm.AddMembers(members, agency.ID)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	m, err := rm.GetMember(agency.ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, agency.ID, m.ID)
+		assert.Len(t, m.RelationPath, 0)
+
+		if assert.NotNil(t, m.GetChannelRule("default")) {
+			assert.Equal(t, *agency.ChannelRules["default"], *m.GetChannelRule("default"))
+		}
+	}
+
+	for _, me := range members {
+
+		m, err := rm.GetMember(me.ID)
+		if !assert.Nil(t, err) {
+			continue
+		}
+
+		assert.Equal(t, me.ID, m.ID)
+		assert.Equal(t, agency.ID, m.Upstream)
+		assert.Equal(t, []string{agency.ID}, m.RelationPath)
+	}
+}
+
+func testGetPath(t *testing.T, rm bursary.RelationManager) {
+
+	levels := addChain(t, rm, "", 3)
+
+	// Path of root
+	p, err := rm.GetPath("")
+	if assert.Nil(t, err) {
+		assert.Len(t, p, 0)
+	}
+
+	// Path for downstreams of the bottom level
+	p, err = rm.GetPath(levels[2].ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, entryIDs(levels), p)
+	}
+}
+
+func testMoveMembersDeep(t *testing.T, rm bursary.RelationManager) {
+
+	levels := addChain(t, rm, "", 6)
+	other := addChain(t, rm, "", 2)
+
+	// Upstream doesn't exist
+	err := rm.MoveMembers([]string{levels[1].ID}, bursary.NewMemberEntry().ID)
+	assert.Equal(t, bursary.ErrUpstreamNotFound, err)
+
+	// Moving member into its own subtree is not allowed
+	err = rm.MoveMembers([]string{levels[1].ID}, levels[3].ID)
+	assert.Equal(t, bursary.ErrInvalidUpstream, err)
+
+	// Move the third level to another branch
+	err = rm.MoveMembers([]string{levels[2].ID}, other[1].ID)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	m, err := rm.GetMember(levels[2].ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, other[1].ID, m.Upstream)
+		assert.Equal(t, entryIDs(other), m.RelationPath)
+	}
+
+	// Every descendant should follow
+	for i := 3; i < len(levels); i++ {
+
+		m, err := rm.GetMember(levels[i].ID)
+		if !assert.Nil(t, err) {
+			continue
+		}
+
+		expected := entryIDs(other)
+		expected = append(expected, entryIDs(levels[2:i])...)
+
+		assert.Equal(t, levels[i-1].ID, m.Upstream)
+		assert.Equal(t, expected, m.RelationPath)
+	}
+
+	// Members above should stay untouched
+	m, err = rm.GetMember(levels[1].ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{levels[0].ID}, m.RelationPath)
+	}
+
+	// Move back to root
+	err = rm.MoveMembers([]string{levels[2].ID}, "")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	m, err = rm.GetMember(levels[5].ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, entryIDs(levels[2:5]), m.RelationPath)
+	}
+}
+
+func testDeleteMembers(t *testing.T, rm bursary.RelationManager) {
+
+	levels := addChain(t, rm, "", 3)
+
+	err := rm.DeleteMembers(entryIDs(levels[1:]))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	for _, l := range levels[1:] {
+		_, err := rm.GetMember(l.ID)
+		assert.Equal(t, bursary.ErrMemberNotFound, err)
+	}
+
+	// Others should be kept
+	m, err := rm.GetMember(levels[0].ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, levels[0].ID, m.ID)
+	}
+}
+
+func testListMembersPagination(t *testing.T, rm bursary.RelationManager) {
+
+	agency := addChain(t, rm, "", 1)[0]
+
+	members := make([]*bursary.MemberEntry, 0)
+	for i := 0; i < 5; i++ {
+		members = append(members, bursary.NewMemberEntry())
+	}
+
+	err := rm.AddMembers(members, agency.ID)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Grandchildren shouldn't be listed as direct downstreams
+	addChain(t, rm, members[0].ID, 2)
+
+	// Default condition
+	ms, err := rm.ListMembers(agency.ID, bursary.NewCondition())
+	if assert.Nil(t, err) {
+		assert.ElementsMatch(t, entryIDs(members), memberIDs(ms))
+	}
+
+	// Only agency is at root level
+	ms, err = rm.ListMembers("", bursary.NewCondition())
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{agency.ID}, memberIDs(ms))
+	}
+
+	all, err := rm.ListMembers(agency.ID, bursary.NewCondition())
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Pages
+	listed := make([]string, 0)
+	expected := []int{2, 2, 1, 0}
+	for i, n := range expected {
+
+		ms, err := rm.ListMembers(agency.ID, &bursary.Condition{
+			Page:  i + 1,
+			Limit: 2,
+		})
+		if !assert.Nil(t, err) {
+			continue
+		}
+
+		assert.Len(t, ms, n)
+
+		for _, m := range ms {
+			assert.Equal(t, agency.ID, m.Upstream)
+		}
+
+		listed = append(listed, memberIDs(ms)...)
+	}
+
+	// Pages should neither overlap nor miss anyone
+	assert.Equal(t, memberIDs(all), listed)
+}
+
+func testListMembersSort(t *testing.T, rm bursary.RelationManager) {
+
+	agency := addChain(t, rm, "", 1)[0]
+
+	// Members are created one by one
+	var mark time.Time
+	members := make([]*bursary.MemberEntry, 0)
+	for i := 0; i < 3; i++ {
+
+		if i == 2 {
+			time.Sleep(5 * time.Millisecond)
+			mark = time.Now()
+		}
+
+		time.Sleep(5 * time.Millisecond)
+
+		members = append(members, addChain(t, rm, agency.ID, 1)[0])
+	}
+
+	m, err := rm.GetMember(members[0].ID)
+	if assert.Nil(t, err) {
+		assert.False(t, m.CreatedAt.IsZero())
+	}
+
+	created := entryIDs(members)
+
+	byID := entryIDs(members)
+	sort.Strings(byID)
+
+	reversed := func(ids []string) []string {
+		r := make([]string, 0, len(ids))
+		for i := len(ids) - 1; i >= 0; i-- {
+			r = append(r, ids[i])
+		}
+		return r
+	}
+
+	// Creation time by default
+	ms, err := rm.ListMembers(agency.ID, nil)
+	if assert.Nil(t, err) {
+		assert.Equal(t, created, memberIDs(ms))
+	}
+
+	cases := []struct {
+		sort     []*bursary.SortField
+		expected []string
+	}{
+		{
+			sort:     []*bursary.SortField{{Field: bursary.SortByCreatedAt, Ascending: true}},
+			expected: created,
+		},
+		{
+			sort:     []*bursary.SortField{{Field: bursary.SortByCreatedAt, Ascending: false}},
+			expected: reversed(created),
+		},
+		{
+			sort:     []*bursary.SortField{{Field: bursary.SortByID, Ascending: true}},
+			expected: byID,
+		},
+		{
+			sort:     []*bursary.SortField{{Field: bursary.SortByID, Ascending: false}},
+			expected: reversed(byID),
+		},
+		{
+			// Same depth for all members so ID decides
+			sort:     []*bursary.SortField{{Field: bursary.SortByDepth, Ascending: true}},
+			expected: byID,
+		},
+	}
+
+	for _, c := range cases {
+
+		ms, err := rm.ListMembers(agency.ID, &bursary.Condition{
+			Page:  1,
+			Limit: 10,
+			Sort:  c.sort,
+		})
+		if assert.Nil(t, err) {
+			assert.Equal(t, c.expected, memberIDs(ms))
+		}
+	}
+
+	// Unknown field
+	_, err = rm.ListMembers(agency.ID, &bursary.Condition{
+		Page:  1,
+		Limit: 10,
+		Sort:  []*bursary.SortField{{Field: "name"}},
+	})
+	assert.Equal(t, bursary.ErrInvalidSortField, err)
+
+	// Time range applies to creation time
+	ms, err = rm.ListMembers(agency.ID, &bursary.Condition{
+		Page:  1,
+		Limit: 10,
+		TimeRange: &bursary.TimeRange{
+			StartTime: mark,
+		},
+	})
+	if assert.Nil(t, err) {
+		assert.Equal(t, created[2:], memberIDs(ms))
+	}
+
+	ms, err = rm.ListMembers(agency.ID, &bursary.Condition{
+		Page:  1,
+		Limit: 10,
+		TimeRange: &bursary.TimeRange{
+			EndTime: mark,
+		},
+	})
+	if assert.Nil(t, err) {
+		assert.Equal(t, created[:2], memberIDs(ms))
+	}
+
+	// Downline can be sorted as well
+	ms, err = rm.ListDescendants(agency.ID, 0, &bursary.Condition{
+		Page:  1,
+		Limit: 10,
+		Sort:  []*bursary.SortField{{Field: bursary.SortByCreatedAt, Ascending: false}},
+	})
+	if assert.Nil(t, err) {
+		assert.Equal(t, reversed(created), memberIDs(ms))
+	}
+}
+
+func testListMembersCursor(t *testing.T, rm bursary.RelationManager) {
+
+	agency := addChain(t, rm, "", 1)[0]
+
+	members := make([]*bursary.MemberEntry, 0)
+	for i := 0; i < 7; i++ {
+		members = append(members, bursary.NewMemberEntry())
+	}
+
+	err := rm.AddMembers(members, agency.ID)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	sorts := [][]*bursary.SortField{
+		nil,
+		{{Field: bursary.SortByID, Ascending: false}},
+		{{Field: bursary.SortByCreatedAt, Ascending: false}},
+	}
+
+	for _, fields := range sorts {
+
+		all, err := rm.ListMembers(agency.ID, &bursary.Condition{
+			Page:  1,
+			Limit: 100,
+			Sort:  fields,
+		})
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		listed := make([]string, 0)
+		cond := &bursary.Condition{
+			Page:  1,
+			Limit: 3,
+			Sort:  fields,
+		}
+
+		for i := 0; i < 5; i++ {
+
+			ms, err := rm.ListMembers(agency.ID, cond)
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			listed = append(listed, memberIDs(ms)...)
+
+			if len(cond.NextCursor) == 0 {
+				break
+			}
+
+			cond = &bursary.Condition{
+				Limit: 3,
+				Sort:  fields,
+				After: cond.NextCursor,
+			}
+		}
+
+		assert.Equal(t, memberIDs(all), listed)
+	}
+
+	// Cursor is bound to sorting of the query which returned it
+	cond := &bursary.Condition{
+		Page:  1,
+		Limit: 3,
+	}
+
+	_, err = rm.ListMembers(agency.ID, cond)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	_, err = rm.ListMembers(agency.ID, &bursary.Condition{
+		Limit: 3,
+		Sort:  []*bursary.SortField{{Field: bursary.SortByID, Ascending: true}},
+		After: cond.NextCursor,
+	})
+	assert.Equal(t, bursary.ErrInvalidCursor, err)
+
+	// Members added while paging are neither repeated nor shift pages
+	ms, err := rm.ListMembers(agency.ID, &bursary.Condition{
+		Limit: 3,
+		After: cond.NextCursor,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	seen := map[string]bool{}
+	for _, id := range memberIDs(ms) {
+		seen[id] = true
+	}
+
+	addChain(t, rm, agency.ID, 1)
+
+	ms, err = rm.ListMembers(agency.ID, &bursary.Condition{
+		Limit: 3,
+		After: cond.NextCursor,
+	})
+	if assert.Nil(t, err) {
+		for _, id := range memberIDs(ms) {
+			assert.True(t, seen[id])
+		}
+	}
+}
+
+func testChannelRules(t *testing.T, rm bursary.RelationManager) {
+
+	levels := addChain(t, rm, "", 3)
+
+	// Update rule
+	for _, l := range levels {
+		err := rm.UpdateChannelRule(l.ID, "default", &bursary.Rule{
+			Commission:    0.5,
+			Share:         0.4,
+			ReturnedShare: 0.1,
+		})
+		assert.Nil(t, err)
+	}
+
+	// Add a new rule
+	err := rm.UpdateChannelRule(levels[0].ID, "new", &bursary.Rule{
+		Commission:  0.9,
+		Share:       0.8,
+		TicketCap:   1000,
+		DailyCap:    5000,
+		PeriodCap:   20000,
+		Period:      bursary.PeriodWeek,
+		TicketFloor: 10,
+		Tiers: []*bursary.Tier{
+			{Threshold: 10000, Commission: 0.95, Share: 0.85},
+		},
+		TierVolume: bursary.TierVolumeFee,
+		Fixed:      1.5,
+		Bonuses: map[string]float64{
+			"first_deposit": 50,
+		},
+		Ceiling: &bursary.RuleCeiling{Commission: 0.6, Share: 0.5},
+	})
+	assert.Nil(t, err)
+
+	// Member doesn't exist
+	err = rm.UpdateChannelRule(bursary.NewMemberEntry().ID, "new", &bursary.Rule{})
+	assert.ErrorIs(t, err, bursary.ErrMemberNotFound)
+
+	err = rm.RemoveChannelRule(bursary.NewMemberEntry().ID, "default")
+	assert.ErrorIs(t, err, bursary.ErrMemberNotFound)
+
+	m, err := rm.GetMember(levels[0].ID)
+	if assert.Nil(t, err) {
+
+		r := m.GetChannelRule("default")
+		if assert.NotNil(t, r) {
+			assert.Equal(t, 0.5, r.Commission)
+			assert.Equal(t, 0.4, r.Share)
+			assert.Equal(t, 0.1, r.ReturnedShare)
+		}
+
+		r = m.GetChannelRule("new")
+		if assert.NotNil(t, r) {
+			assert.Equal(t, 0.9, r.Commission)
+			assert.Equal(t, 0.8, r.Share)
+			assert.Equal(t, int64(1000), r.TicketCap)
+			assert.Equal(t, int64(5000), r.DailyCap)
+			assert.Equal(t, int64(20000), r.PeriodCap)
+			assert.Equal(t, bursary.PeriodWeek, r.Period)
+			assert.Equal(t, int64(10), r.TicketFloor)
+			assert.Equal(t, bursary.TierVolumeFee, r.TierVolume)
+			if assert.Len(t, r.Tiers, 1) {
+				assert.Equal(t, int64(10000), r.Tiers[0].Threshold)
+				assert.Equal(t, 0.85, r.Tiers[0].Share)
+			}
+			assert.Equal(t, 1.5, r.Fixed)
+			assert.Equal(t, 50.0, r.Bonuses["first_deposit"])
+			if assert.NotNil(t, r.Ceiling) {
+				assert.Equal(t, 0.6, r.Ceiling.Commission)
+				assert.Equal(t, 0.5, r.Ceiling.Share)
+			}
+		}
+	}
+
+	// Remove rule of single member
+	err = rm.RemoveChannelRule(levels[0].ID, "new")
+	assert.Nil(t, err)
+
+	m, err = rm.GetMember(levels[0].ID)
+	if assert.Nil(t, err) {
+		assert.Nil(t, m.GetChannelRule("new"))
+		assert.NotNil(t, m.GetChannelRule("default"))
+	}
+
+	// Remove channel from all members
+	err = rm.RemoveChannel("default")
+	assert.Nil(t, err)
+
+	for _, l := range levels {
+		m, err := rm.GetMember(l.ID)
+		if assert.Nil(t, err) {
+			assert.Nil(t, m.GetChannelRule("default"))
+		}
+	}
+
+	// Hierarchical channels
+	channels := map[string]float64{
+		"casino":                0.1,
+		"casino/slots":          0.2,
+		"casino/slots/vendor":   0.3,
+		"casinos":               0.4,
+		bursary.WildcardChannel: 0.5,
+	}
+
+	for channel, share := range channels {
+		err := rm.UpdateChannelRule(levels[1].ID, channel, &bursary.Rule{
+			Share: share,
+		})
+		assert.Nil(t, err)
+	}
+
+	m, err = rm.GetMember(levels[1].ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, 0.3, m.GetChannelRule("casino/slots/vendor").Share)
+		assert.Equal(t, 0.2, m.GetChannelRule("casino/slots/other").Share)
+		assert.Equal(t, 0.1, m.GetChannelRule("casino/poker").Share)
+		assert.Equal(t, 0.5, m.GetChannelRule("sports").Share)
+	}
+
+	// Remove a whole branch
+	err = rm.RemoveChannel("casino/slots/*")
+	assert.Nil(t, err)
+
+	m, err = rm.GetMember(levels[1].ID)
+	if assert.Nil(t, err) {
+		assert.Len(t, m.ChannelRules, 3)
+		assert.Equal(t, 0.1, m.GetChannelRule("casino/slots/vendor").Share)
+	}
+
+	err = rm.RemoveChannel("casino/*")
+	assert.Nil(t, err)
+
+	m, err = rm.GetMember(levels[1].ID)
+	if assert.Nil(t, err) {
+		assert.Len(t, m.ChannelRules, 2)
+		assert.NotNil(t, m.ChannelRules["casinos"])
+		assert.Equal(t, 0.5, m.GetChannelRule("casino").Share)
+	}
+}
+
+func testUpdateChannelRules(t *testing.T, rm bursary.RelationManager) {
+
+	levels := addChain(t, rm, "", 3)
+
+	err := rm.UpdateChannelRule(levels[2].ID, "default", &bursary.Rule{Commission: 0.1})
+	assert.Nil(t, err)
+
+	err = rm.UpdateChannelRules("default", map[string]*bursary.Rule{
+		levels[0].ID: &bursary.Rule{Commission: 0.5, Share: 0.4},
+		levels[1].ID: &bursary.Rule{Commission: 0.3, Share: 0.2},
+	})
+	assert.Nil(t, err)
+
+	for i, commission := range []float64{0.5, 0.3, 0.1} {
+		m, err := rm.GetMember(levels[i].ID)
+		if assert.Nil(t, err) && assert.NotNil(t, m.ChannelRules["default"]) {
+			assert.Equal(t, commission, m.ChannelRules["default"].Commission)
+		}
+	}
+
+	// Nothing is updated if any member doesn't exist
+	err = rm.UpdateChannelRules("default", map[string]*bursary.Rule{
+		levels[0].ID:                &bursary.Rule{Commission: 0.9},
+		bursary.NewMemberEntry().ID: &bursary.Rule{Commission: 0.9},
+	})
+	assert.ErrorIs(t, err, bursary.ErrMemberNotFound)
+
+	m, err := rm.GetMember(levels[0].ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, 0.5, m.ChannelRules["default"].Commission)
+	}
+}
+
+// newCopiedRule returns a rule with every field holding references.
+func newCopiedRule() *bursary.Rule {
+	return &bursary.Rule{
+		Commission: 0.5,
+		Share:      0.4,
+		Tiers: []*bursary.Tier{
+			{Threshold: 10000, Commission: 0.6, Share: 0.5},
+		},
+		Bonuses: map[string]float64{
+			"first_deposit": 50,
+		},
+		Ceiling: &bursary.RuleCeiling{Commission: 0.4, Share: 0.3},
+	}
+}
+
+// mutateRule changes everything r refers to.
+func mutateRule(r *bursary.Rule) {
+	r.Share = 0.1
+	r.Tiers[0].Share = 0.1
+	r.Bonuses["first_deposit"] = 1
+	r.Ceiling.Share = 0.1
+}
+
+func testChannelRulesCopied(t *testing.T, rm bursary.RelationManager) {
+
+	added := newCopiedRule()
+	updated := newCopiedRule()
+	batched := newCopiedRule()
+
+	me := bursary.NewMemberEntry()
+	me.ChannelRules["added"] = added
+
+	err := rm.AddMembers([]*bursary.MemberEntry{me}, "")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Nil(t, rm.UpdateChannelRule(me.ID, "updated", updated))
+	assert.Nil(t, rm.UpdateChannelRules("batched", map[string]*bursary.Rule{
+		me.ID: batched,
+	}))
+
+	// Changing rules of caller after writing them doesn't affect members
+	mutateRule(added)
+	mutateRule(updated)
+	mutateRule(batched)
+
+	m, err := rm.GetMember(me.ID)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	for _, channel := range []string{"added", "updated", "batched"} {
+		r := m.ChannelRules[channel]
+		if assert.NotNil(t, r, channel) {
+			assert.Equal(t, newCopiedRule(), r, channel)
+		}
+	}
+
+	// Nor does changing rules of returned members
+	mutateRule(m.ChannelRules["added"])
+
+	m, err = rm.GetMember(me.ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, newCopiedRule(), m.ChannelRules["added"])
+	}
+}
+
+func testGetUpstreamsOrder(t *testing.T, rm bursary.RelationManager) {
+
+	levels := addChain(t, rm, "", 8)
+	bottom := levels[len(levels)-1]
+
+	// Upstreams are always from root to the closest upstream
+	upstreams, err := rm.GetUpstreams(bottom.ID)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, entryIDs(levels[:len(levels)-1]), memberIDs(upstreams))
+
+	// Put a new agency created later on top of the whole chain
+	agency := addChain(t, rm, "", 1)[0]
+	err = rm.MoveMembers([]string{levels[0].ID}, agency.ID)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	upstreams, err = rm.GetUpstreams(bottom.ID)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	expected := append([]string{agency.ID}, entryIDs(levels[:len(levels)-1])...)
+	assert.Equal(t, expected, memberIDs(upstreams))
+
+	// Levels used by bursary should be from the closest upstream to root
+	bu := bursary.NewBursary(
+		bursary.WithRelationManager(rm),
+	)
+
+	ls, err := bu.GetLevels(bottom.ID)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	for i, l := range ls {
+		assert.Equal(t, expected[len(expected)-i-1], l.ID)
+	}
+}
+
+func testDescendants(t *testing.T, rm bursary.RelationManager) {
+
+	agency := addChain(t, rm, "", 1)[0]
+
+	children := []*bursary.MemberEntry{
+		bursary.NewMemberEntry(),
+		bursary.NewMemberEntry(),
+		bursary.NewMemberEntry(),
+	}
+
+	err := rm.AddMembers(children, agency.ID)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	grandchildren := addChain(t, rm, children[0].ID, 2)
+	grandchildren = append(grandchildren, addChain(t, rm, children[1].ID, 1)...)
+
+	// Members of other branches shouldn't be involved
+	addChain(t, rm, "", 3)
+
+	// Member doesn't exist
+	_, err = rm.ListDescendants(bursary.NewMemberEntry().ID, 0, nil)
+	assert.Equal(t, bursary.ErrMemberNotFound, err)
+
+	_, err = rm.CountDescendants(bursary.NewMemberEntry().ID)
+	assert.Equal(t, bursary.ErrMemberNotFound, err)
+
+	count, err := rm.CountDescendants(agency.ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, 6, count)
+	}
+
+	count, err = rm.CountDescendants(grandchildren[2].ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, 0, count)
+	}
+
+	// Level by level, members of the same level are ordered by ID
+	level1 := entryIDs(children)
+	sort.Strings(level1)
+
+	level2 := []string{grandchildren[0].ID, grandchildren[2].ID}
+	sort.Strings(level2)
+
+	expected := append(append(append([]string{}, level1...), level2...), grandchildren[1].ID)
+	depths := []int{1, 1, 1, 2, 2, 3}
+
+	ms, err := rm.ListDescendants(agency.ID, 0, nil)
+	if assert.Nil(t, err) {
+		assert.Equal(t, expected, memberIDs(ms))
+
+		for i, m := range ms {
+			assert.Equal(t, depths[i], m.Depth)
+		}
+	}
+
+	// Limited levels
+	ms, err = rm.ListDescendants(agency.ID, 2, nil)
+	if assert.Nil(t, err) {
+		assert.Equal(t, expected[:5], memberIDs(ms))
+	}
+
+	ms, err = rm.ListDescendants(agency.ID, 1, nil)
+	if assert.Nil(t, err) {
+		assert.Equal(t, level1, memberIDs(ms))
+	}
+
+	// Depth is relative to the given member
+	ms, err = rm.ListDescendants(children[0].ID, 0, nil)
+	if assert.Nil(t, err) {
+		assert.Equal(t, entryIDs(grandchildren[:2]), memberIDs(ms))
+
+		for i, m := range ms {
+			assert.Equal(t, i+1, m.Depth)
+		}
+	}
+
+	// Pages
+	listed := make([]string, 0)
+	for page := 1; page <= 4; page++ {
+
+		ms, err := rm.ListDescendants(agency.ID, 0, &bursary.Condition{
+			Page:  page,
+			Limit: 4,
+		})
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		listed = append(listed, memberIDs(ms)...)
+	}
+
+	assert.Equal(t, expected, listed)
+}
@@ -0,0 +1,55 @@
+package bursary
+
+import "strings"
+
+const (
+	// ChannelSeparator separates levels of hierarchical channels, such as
+	// "casino/slots/provider-x".
+	ChannelSeparator = "/"
+
+	// WildcardChannel is the channel of rule applied to channels without
+	// rules of their own or of their parents.
+	WildcardChannel = "*"
+)
+
+// ChannelAncestors returns channel followed by its parents up to the top,
+// such as "a/b/c", "a/b" and "a".
+func ChannelAncestors(channel string) []string {
+
+	ancestors := []string{channel}
+	for {
+		idx := strings.LastIndex(channel, ChannelSeparator)
+		if idx < 0 {
+			break
+		}
+
+		channel = channel[:idx]
+		ancestors = append(ancestors, channel)
+	}
+
+	return ancestors
+}
+
+// ChannelBranch returns the channel at top of a branch pattern "a/b/*", which
+// covers "a/b" and all channels below it.
+func ChannelBranch(pattern string) (string, bool) {
+
+	suffix := ChannelSeparator + WildcardChannel
+	if !strings.HasSuffix(pattern, suffix) {
+		return "", false
+	}
+
+	return strings.TrimSuffix(pattern, suffix), true
+}
+
+// MatchChannel reports whether channel is selected by pattern, which is
+// either a channel name or a branch pattern "a/b/*".
+func MatchChannel(pattern string, channel string) bool {
+
+	branch, ok := ChannelBranch(pattern)
+	if !ok {
+		return pattern == channel
+	}
+
+	return channel == branch || strings.HasPrefix(channel, branch+ChannelSeparator)
+}
@@ -0,0 +1,142 @@
+package bursary
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+var (
+	ErrChannelNotFound  = errors.New("bursary: channel not found")
+	ErrChannelExists    = errors.New("bursary: channel exists")
+	ErrChannelDisabled  = errors.New("bursary: channel disabled")
+	ErrCurrencyMismatch = errors.New("bursary: currency of ticket doesn't match channel")
+)
+
+// RoundingPolicy tells how shares of amounts and fees are rounded to integers.
+type RoundingPolicy string
+
+const (
+	RoundingFloor    RoundingPolicy = "floor"
+	RoundingHalfUp   RoundingPolicy = "half_up"
+	RoundingHalfEven RoundingPolicy = "half_even"
+)
+
+// Round rounds v by the policy. Unknown policies round down as RoundingFloor.
+func (p RoundingPolicy) Round(v float64) int64 {
+
+	switch p {
+	case RoundingHalfUp:
+		return int64(math.Round(v))
+	case RoundingHalfEven:
+		return int64(math.RoundToEven(v))
+	}
+
+	return int64(math.Floor(v))
+}
+
+// Channel holds metadata of a channel. A registered channel covers all
+// channels below it, unless they're registered as well.
+type Channel struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Currency    string `json:"currency,omitempty"`
+	Enabled     bool   `json:"enabled"`
+
+	// DefaultRule applies to members without rule for the channel
+	DefaultRule *Rule          `json:"default_rule,omitempty"`
+	Rounding    RoundingPolicy `json:"rounding,omitempty"` // floor by default
+
+	// Ledgers receive entries of tickets in the channel besides routes
+	Ledgers []string `json:"ledgers,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (ch *Channel) clone() *Channel {
+
+	c := *ch
+
+	if ch.DefaultRule != nil {
+		c.DefaultRule = ch.DefaultRule.clone()
+	}
+
+	if ch.Ledgers != nil {
+		c.Ledgers = make([]string, len(ch.Ledgers))
+		copy(c.Ledgers, ch.Ledgers)
+	}
+
+	return &c
+}
+
+func (ch *Channel) rounding() RoundingPolicy {
+
+	if ch == nil {
+		return RoundingFloor
+	}
+
+	return ch.Rounding
+}
+
+// defaultRule returns default rule of channel, or nil if there is none.
+func (ch *Channel) defaultRule() *Rule {
+
+	if ch == nil {
+		return nil
+	}
+
+	return ch.DefaultRule
+}
+
+type ChannelRegistry interface {
+	RegisterChannel(ch *Channel) error
+	UpdateChannel(ch *Channel) error
+	GetChannel(name string) (*Channel, error)
+	ListChannels() ([]*Channel, error)
+	SetChannelEnabled(name string, enabled bool) error
+
+	// Context-aware variants
+	RegisterChannelContext(ctx context.Context, ch *Channel) error
+	UpdateChannelContext(ctx context.Context, ch *Channel) error
+	GetChannelContext(ctx context.Context, name string) (*Channel, error)
+	ListChannelsContext(ctx context.Context) ([]*Channel, error)
+	SetChannelEnabledContext(ctx context.Context, name string, enabled bool) error
+
+	Close() error
+}
+
+// ResolveChannel returns the most specific channel registered up the
+// hierarchy of channel. It fails with ErrChannelNotFound if none of them is
+// registered, and with ErrChannelDisabled if any of them is disabled.
+func ResolveChannel(ctx context.Context, cr ChannelRegistry, channel string) (*Channel, error) {
+
+	var resolved *Channel
+	for _, name := range ChannelAncestors(channel) {
+
+		ch, err := cr.GetChannelContext(ctx, name)
+		if err == ErrChannelNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		// Disabling a channel disables all channels below it
+		if !ch.Enabled {
+			return nil, ErrChannelDisabled
+		}
+
+		if resolved == nil {
+			resolved = ch
+		}
+	}
+
+	if resolved == nil {
+		return nil, ErrChannelNotFound
+	}
+
+	return resolved, nil
+}
@@ -0,0 +1,226 @@
+package channel_registry_postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kulado/sqlxmigrate"
+	"github.com/weedbox/bursary/v2"
+)
+
+type Opt func(*ChannelRegistryPostgres)
+
+type ChannelRegistryPostgres struct {
+	db        *sqlx.DB
+	tableName string
+}
+
+func NewChannelRegistryPostgres(opts ...Opt) *ChannelRegistryPostgres {
+	cr := &ChannelRegistryPostgres{}
+
+	for _, opt := range opts {
+		opt(cr)
+	}
+
+	if len(cr.tableName) == 0 {
+		cr.tableName = "channels"
+	}
+
+	return cr
+}
+
+func WithDb(db *sqlx.DB) Opt {
+	return func(cr *ChannelRegistryPostgres) {
+		cr.db = db
+	}
+}
+
+func WithTableName(tableName string) Opt {
+	return func(cr *ChannelRegistryPostgres) {
+		cr.tableName = tableName
+	}
+}
+
+func (cr *ChannelRegistryPostgres) Init() error {
+
+	// Initializing table
+	m := sqlxmigrate.New(cr.db, sqlxmigrate.DefaultOptions, []*sqlxmigrate.Migration{
+		{
+			ID: "202610190101",
+			Migrate: func(tx *sql.Tx) error {
+
+				q := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (
+						"name" TEXT,
+						"display_name" TEXT NOT NULL DEFAULT '',
+						"currency" TEXT NOT NULL DEFAULT '',
+						"enabled" BOOLEAN NOT NULL DEFAULT TRUE,
+						"default_rule" JSONB,
+						"rounding" TEXT NOT NULL DEFAULT '',
+						"ledgers" TEXT[],
+						"created_at" timestamp with time zone,
+						"updated_at" timestamp with time zone,
+						PRIMARY KEY ("name")
+					)`, cr.tableName)
+
+				_, err := tx.Exec(q)
+				return err
+			},
+			Rollback: func(tx *sql.Tx) error {
+				q := fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, cr.tableName)
+				_, err := tx.Exec(q)
+				return err
+			},
+		},
+	})
+
+	if err := m.Migrate(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (cr *ChannelRegistryPostgres) Close() error {
+	return cr.db.Close()
+}
+
+func (cr *ChannelRegistryPostgres) RegisterChannel(ch *bursary.Channel) error {
+	return cr.RegisterChannelContext(context.Background(), ch)
+}
+
+func (cr *ChannelRegistryPostgres) RegisterChannelContext(ctx context.Context, ch *bursary.Channel) error {
+
+	record := NewChannelRecord(ch)
+	record.CreatedAt = time.Now()
+	record.UpdatedAt = record.CreatedAt
+
+	cmd := fmt.Sprintf(`INSERT INTO "%s" (
+			name,
+			display_name,
+			currency,
+			enabled,
+			default_rule,
+			rounding,
+			ledgers,
+			created_at,
+			updated_at
+		) VALUES (
+			:name,
+			:display_name,
+			:currency,
+			:enabled,
+			:default_rule,
+			:rounding,
+			:ledgers,
+			:created_at,
+			:updated_at
+		) ON CONFLICT (name) DO NOTHING`, cr.tableName)
+
+	res, err := sqlx.NamedExecContext(ctx, cr.db, cmd, record)
+	if err != nil {
+		return err
+	}
+
+	return affected(res, bursary.ErrChannelExists)
+}
+
+func (cr *ChannelRegistryPostgres) UpdateChannel(ch *bursary.Channel) error {
+	return cr.UpdateChannelContext(context.Background(), ch)
+}
+
+func (cr *ChannelRegistryPostgres) UpdateChannelContext(ctx context.Context, ch *bursary.Channel) error {
+
+	record := NewChannelRecord(ch)
+	record.UpdatedAt = time.Now()
+
+	cmd := fmt.Sprintf(`UPDATE "%s" SET
+			display_name = :display_name,
+			currency = :currency,
+			enabled = :enabled,
+			default_rule = :default_rule,
+			rounding = :rounding,
+			ledgers = :ledgers,
+			updated_at = :updated_at
+		WHERE name = :name`, cr.tableName)
+
+	res, err := sqlx.NamedExecContext(ctx, cr.db, cmd, record)
+	if err != nil {
+		return err
+	}
+
+	return affected(res, bursary.ErrChannelNotFound)
+}
+
+func (cr *ChannelRegistryPostgres) GetChannel(name string) (*bursary.Channel, error) {
+	return cr.GetChannelContext(context.Background(), name)
+}
+
+func (cr *ChannelRegistryPostgres) GetChannelContext(ctx context.Context, name string) (*bursary.Channel, error) {
+
+	cmd := fmt.Sprintf(`SELECT * FROM "%s" WHERE name = $1`, cr.tableName)
+	records := []ChannelRecord{}
+	err := sqlx.SelectContext(ctx, cr.db, &records, cmd, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, bursary.ErrChannelNotFound
+	}
+
+	return records[0].ToChannel(), nil
+}
+
+func (cr *ChannelRegistryPostgres) ListChannels() ([]*bursary.Channel, error) {
+	return cr.ListChannelsContext(context.Background())
+}
+
+func (cr *ChannelRegistryPostgres) ListChannelsContext(ctx context.Context) ([]*bursary.Channel, error) {
+
+	cmd := fmt.Sprintf(`SELECT * FROM "%s" ORDER BY name`, cr.tableName)
+	records := []ChannelRecord{}
+	err := sqlx.SelectContext(ctx, cr.db, &records, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	channels := make([]*bursary.Channel, 0, len(records))
+	for i := range records {
+		channels = append(channels, records[i].ToChannel())
+	}
+
+	return channels, nil
+}
+
+func (cr *ChannelRegistryPostgres) SetChannelEnabled(name string, enabled bool) error {
+	return cr.SetChannelEnabledContext(context.Background(), name, enabled)
+}
+
+func (cr *ChannelRegistryPostgres) SetChannelEnabledContext(ctx context.Context, name string, enabled bool) error {
+
+	cmd := fmt.Sprintf(`UPDATE "%s" SET enabled = $1, updated_at = $2 WHERE name = $3`, cr.tableName)
+	res, err := cr.db.ExecContext(ctx, cmd, enabled, time.Now(), name)
+	if err != nil {
+		return err
+	}
+
+	return affected(res, bursary.ErrChannelNotFound)
+}
+
+// affected returns errNone if no row was affected by res.
+func affected(res sql.Result, errNone error) error {
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return errNone
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package channel_registry_postgres
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/weedbox/bursary/v2"
+	"github.com/weedbox/bursary/v2/bursarytest"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+var testDb *sqlx.DB
+var testTable = "channels_test"
+var testCR *ChannelRegistryPostgres
+
+func TestMain(m *testing.M) {
+
+	// Tests require a running PostgreSQL server
+	dsn := os.Getenv("BURSARY_TEST_POSTGRES_DSN")
+	if len(dsn) == 0 {
+		fmt.Println("BURSARY_TEST_POSTGRES_DSN is not set, skipping PostgreSQL tests")
+		os.Exit(0)
+	}
+
+	// Connect to postgres server
+	db, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	testDb = db
+
+	cr := NewChannelRegistryPostgres(
+		WithDb(testDb),
+		WithTableName(testTable),
+	)
+
+	err = cr.Init()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	testCR = cr
+
+	os.Exit(m.Run())
+}
+
+func uninit() {
+	cmd := fmt.Sprintf(`TRUNCATE TABLE %s`, testTable)
+	_, err := testDb.Exec(cmd)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func Test_ChannelRegistryPostgres_Conformance(t *testing.T) {
+	bursarytest.RunChannelRegistrySuite(t, func(t *testing.T) bursary.ChannelRegistry {
+		t.Cleanup(uninit)
+		return testCR
+	})
+}
@@ -0,0 +1,46 @@
+package channel_registry_postgres
+
+import (
+	"github.com/lib/pq"
+	"github.com/weedbox/bursary/v2"
+)
+
+func NewChannelRecord(ch *bursary.Channel) *ChannelRecord {
+
+	ledgers := pq.StringArray(ch.Ledgers)
+	if ledgers == nil {
+		ledgers = pq.StringArray{}
+	}
+
+	return &ChannelRecord{
+		Name:        ch.Name,
+		DisplayName: ch.DisplayName,
+		Currency:    ch.Currency,
+		Enabled:     ch.Enabled,
+		DefaultRule: RuleData{Rule: ch.DefaultRule},
+		Rounding:    string(ch.Rounding),
+		Ledgers:     ledgers,
+		CreatedAt:   ch.CreatedAt,
+		UpdatedAt:   ch.UpdatedAt,
+	}
+}
+
+func (cr *ChannelRecord) ToChannel() *bursary.Channel {
+
+	ch := &bursary.Channel{
+		Name:        cr.Name,
+		DisplayName: cr.DisplayName,
+		Currency:    cr.Currency,
+		Enabled:     cr.Enabled,
+		DefaultRule: cr.DefaultRule.Rule,
+		Rounding:    bursary.RoundingPolicy(cr.Rounding),
+		CreatedAt:   cr.CreatedAt,
+		UpdatedAt:   cr.UpdatedAt,
+	}
+
+	if len(cr.Ledgers) > 0 {
+		ch.Ledgers = []string(cr.Ledgers)
+	}
+
+	return ch
+}
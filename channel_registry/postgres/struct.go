@@ -0,0 +1,68 @@
+package channel_registry_postgres
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/weedbox/bursary/v2"
+)
+
+// RuleData stores default rule of channel as JSONB.
+type RuleData struct {
+	Rule *bursary.Rule
+}
+
+func (rd RuleData) Value() (driver.Value, error) {
+
+	if rd.Rule == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(rd.Rule)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+func (rd *RuleData) Scan(src interface{}) error {
+
+	var source []byte
+	switch v := src.(type) {
+	case nil:
+		rd.Rule = nil
+		return nil
+	case []byte:
+		source = v
+	case string:
+		source = []byte(v)
+	default:
+		return errors.New("Type assertion .([]byte) failed.")
+	}
+
+	r := &bursary.Rule{}
+	err := json.Unmarshal(source, r)
+	if err != nil {
+		return err
+	}
+
+	rd.Rule = r
+
+	return nil
+}
+
+type ChannelRecord struct {
+	Name        string         `db:"name"`
+	DisplayName string         `db:"display_name"`
+	Currency    string         `db:"currency"`
+	Enabled     bool           `db:"enabled"`
+	DefaultRule RuleData       `db:"default_rule"`
+	Rounding    string         `db:"rounding"`
+	Ledgers     pq.StringArray `db:"ledgers"`
+	CreatedAt   time.Time      `db:"created_at"`
+	UpdatedAt   time.Time      `db:"updated_at"`
+}
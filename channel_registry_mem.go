@@ -0,0 +1,146 @@
+package bursary
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type channelRegistryMemory struct {
+	mutex    sync.RWMutex
+	channels map[string]*Channel
+}
+
+func NewChannelRegistryMemory() ChannelRegistry {
+	return &channelRegistryMemory{
+		channels: make(map[string]*Channel),
+	}
+}
+
+func (cr *channelRegistryMemory) RegisterChannel(ch *Channel) error {
+	return cr.RegisterChannelContext(context.Background(), ch)
+}
+
+func (cr *channelRegistryMemory) RegisterChannelContext(ctx context.Context, ch *Channel) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	if _, ok := cr.channels[ch.Name]; ok {
+		return ErrChannelExists
+	}
+
+	c := ch.clone()
+	c.CreatedAt = time.Now().UTC()
+	c.UpdatedAt = c.CreatedAt
+
+	cr.channels[c.Name] = c
+
+	return nil
+}
+
+func (cr *channelRegistryMemory) UpdateChannel(ch *Channel) error {
+	return cr.UpdateChannelContext(context.Background(), ch)
+}
+
+func (cr *channelRegistryMemory) UpdateChannelContext(ctx context.Context, ch *Channel) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	old, ok := cr.channels[ch.Name]
+	if !ok {
+		return ErrChannelNotFound
+	}
+
+	c := ch.clone()
+	c.CreatedAt = old.CreatedAt
+	c.UpdatedAt = time.Now().UTC()
+
+	cr.channels[c.Name] = c
+
+	return nil
+}
+
+func (cr *channelRegistryMemory) GetChannel(name string) (*Channel, error) {
+	return cr.GetChannelContext(context.Background(), name)
+}
+
+func (cr *channelRegistryMemory) GetChannelContext(ctx context.Context, name string) (*Channel, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
+
+	ch, ok := cr.channels[name]
+	if !ok {
+		return nil, ErrChannelNotFound
+	}
+
+	return ch.clone(), nil
+}
+
+func (cr *channelRegistryMemory) ListChannels() ([]*Channel, error) {
+	return cr.ListChannelsContext(context.Background())
+}
+
+func (cr *channelRegistryMemory) ListChannelsContext(ctx context.Context) ([]*Channel, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
+
+	channels := make([]*Channel, 0, len(cr.channels))
+	for _, ch := range cr.channels {
+		channels = append(channels, ch.clone())
+	}
+
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].Name < channels[j].Name
+	})
+
+	return channels, nil
+}
+
+func (cr *channelRegistryMemory) SetChannelEnabled(name string, enabled bool) error {
+	return cr.SetChannelEnabledContext(context.Background(), name, enabled)
+}
+
+func (cr *channelRegistryMemory) SetChannelEnabledContext(ctx context.Context, name string, enabled bool) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	ch, ok := cr.channels[name]
+	if !ok {
+		return ErrChannelNotFound
+	}
+
+	ch.Enabled = enabled
+	ch.UpdatedAt = time.Now().UTC()
+
+	return nil
+}
+
+func (cr *channelRegistryMemory) Close() error {
+	return nil
+}
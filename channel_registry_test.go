@@ -0,0 +1,170 @@
+package bursary
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestChannelBursary(t *testing.T, channels ...*Channel) (Bursary, []string) {
+
+	cr := NewChannelRegistryMemory()
+	for _, ch := range channels {
+		assert.Nil(t, cr.RegisterChannel(ch))
+	}
+
+	bu := NewBursary(WithChannelRegistry(cr))
+	ids := addTestChain(t, bu.RelationManager(), "default", newLimitTestRules()...)
+
+	return bu, ids
+}
+
+func Test_RoundingPolicy_Round(t *testing.T) {
+
+	assert.Equal(t, int64(2), RoundingFloor.Round(2.5))
+	assert.Equal(t, int64(-3), RoundingFloor.Round(-2.5))
+	assert.Equal(t, int64(3), RoundingHalfUp.Round(2.5))
+	assert.Equal(t, int64(2), RoundingHalfEven.Round(2.5))
+	assert.Equal(t, int64(4), RoundingHalfEven.Round(3.5))
+	assert.Equal(t, int64(2), RoundingPolicy("").Round(2.9))
+}
+
+func Test_ResolveChannel(t *testing.T) {
+
+	cr := NewChannelRegistryMemory()
+	assert.Nil(t, cr.RegisterChannel(&Channel{Name: "casino", Enabled: true}))
+	assert.Nil(t, cr.RegisterChannel(&Channel{Name: "casino/slots", Enabled: true, Currency: "USD"}))
+
+	ch, err := ResolveChannel(context.Background(), cr, "casino/slots/vendor")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "casino/slots", ch.Name)
+	}
+
+	ch, err = ResolveChannel(context.Background(), cr, "casino/poker")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "casino", ch.Name)
+	}
+
+	_, err = ResolveChannel(context.Background(), cr, "sports")
+	assert.Equal(t, ErrChannelNotFound, err)
+
+	// Disabling parent disables the branch
+	assert.Nil(t, cr.SetChannelEnabled("casino", false))
+
+	_, err = ResolveChannel(context.Background(), cr, "casino/slots")
+	assert.Equal(t, ErrChannelDisabled, err)
+}
+
+func Test_WriteTicket_ChannelRegistry(t *testing.T) {
+
+	bu, ids := newTestChannelBursary(t,
+		&Channel{Name: "default", Enabled: true},
+		&Channel{Name: "closed", Enabled: false},
+	)
+	defer bu.Close()
+
+	ticket := newLimitTestTicket(ids[2])
+	assert.Nil(t, bu.WriteTicket(ticket))
+
+	ticket = newLimitTestTicket(ids[2])
+	ticket.Channel = "unknown"
+	assert.Equal(t, ErrChannelNotFound, bu.WriteTicket(ticket))
+
+	ticket.Channel = "closed"
+	assert.Equal(t, ErrChannelDisabled, bu.WriteTicket(ticket))
+
+	_, err := bu.CalculateRewards(ticket)
+	assert.Equal(t, ErrChannelDisabled, err)
+
+	assert.Equal(t, 3, countRecords(t, bu.GeneralLedger()))
+}
+
+func Test_WriteTicket_ChannelMetadata(t *testing.T) {
+
+	bu, ids := newTestChannelBursary(t, &Channel{
+		Name:     "slot",
+		Enabled:  true,
+		Currency: "EUR",
+		DefaultRule: &Rule{
+			Commission: 0.5,
+			Share:      0.25,
+		},
+		Rounding: RoundingHalfUp,
+		Ledgers:  []string{"slots"},
+	})
+	defer bu.Close()
+
+	l := NewLedgerMemory()
+	assert.Nil(t, bu.LedgerManager().Add("slots", l))
+
+	// Members have no rule for the channel
+	ticket := newLimitTestTicket(ids[2])
+	ticket.Channel = "slot"
+	ticket.Amount = 1001
+
+	entries, err := bu.CalculateRewards(ticket)
+	if assert.Nil(t, err) {
+		assert.Equal(t, "EUR", ticket.Currency)
+		assert.Equal(t, "EUR", entries[0].Currency)
+
+		// 250.25 is rounded half up
+		assert.Equal(t, int64(250), entries[0].Gain)
+		assert.Equal(t, int64(50), entries[0].Commissions)
+	}
+
+	ticket.Amount = 1002
+
+	entries, err = bu.CalculateRewards(ticket)
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(251), entries[0].Gain)
+	}
+
+	assert.Nil(t, bu.WriteTicket(ticket))
+	assert.Equal(t, 3, countRecords(t, l))
+
+	ticket = newLimitTestTicket(ids[2])
+	ticket.Channel = "slot"
+	ticket.Currency = "USD"
+	assert.Equal(t, ErrCurrencyMismatch, bu.WriteTicket(ticket))
+}
+
+func Test_RemoveChannel_ChannelRegistry(t *testing.T) {
+
+	bu, ids := newTestChannelBursary(t,
+		&Channel{Name: "casino", Enabled: true},
+		&Channel{Name: "casino/slots", Enabled: true},
+		&Channel{Name: "sports", Enabled: true},
+	)
+	defer bu.Close()
+
+	rm := bu.RelationManager()
+	assert.Nil(t, rm.UpdateChannelRule(ids[2], "casino/slots", &Rule{Share: 0.1}))
+	assert.Nil(t, rm.UpdateChannelRule(ids[2], "sports", &Rule{Share: 0.1}))
+
+	err := bu.RemoveChannel("casino/*")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Channels are kept but disabled
+	for _, name := range []string{"casino", "casino/slots"} {
+		ch, err := bu.ChannelRegistry().GetChannel(name)
+		if assert.Nil(t, err) {
+			assert.False(t, ch.Enabled)
+		}
+	}
+
+	ch, _ := bu.ChannelRegistry().GetChannel("sports")
+	assert.True(t, ch.Enabled)
+
+	m, err := rm.GetMember(ids[2])
+	if assert.Nil(t, err) {
+		assert.Nil(t, m.ChannelRules["casino/slots"])
+		assert.NotNil(t, m.ChannelRules["sports"])
+	}
+
+	ticket := newLimitTestTicket(ids[2])
+	ticket.Channel = "casino/slots"
+	assert.Equal(t, ErrChannelDisabled, bu.WriteTicket(ticket))
+}
@@ -0,0 +1,57 @@
+package bursary
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ChannelAncestors(t *testing.T) {
+	assert.Equal(t, []string{"a/b/c", "a/b", "a"}, ChannelAncestors("a/b/c"))
+	assert.Equal(t, []string{"default"}, ChannelAncestors("default"))
+}
+
+func Test_MatchChannel(t *testing.T) {
+
+	assert.True(t, MatchChannel("a/b", "a/b"))
+	assert.False(t, MatchChannel("a/b", "a/b/c"))
+
+	assert.True(t, MatchChannel("a/b/*", "a/b"))
+	assert.True(t, MatchChannel("a/b/*", "a/b/c/d"))
+	assert.False(t, MatchChannel("a/b/*", "a/bc"))
+	assert.False(t, MatchChannel("a/b/*", "a"))
+
+	// Wildcard rule itself
+	assert.True(t, MatchChannel(WildcardChannel, WildcardChannel))
+	assert.False(t, MatchChannel(WildcardChannel, "a"))
+}
+
+func Test_CalculateRewards_ChannelHierarchy(t *testing.T) {
+
+	bu := NewBursary()
+	defer bu.Close()
+
+	ids := addTestChain(t, bu.RelationManager(), "default", newLimitTestRules()...)
+
+	// Owner has rules for the parent channel and a wildcard
+	rm := bu.RelationManager()
+	assert.Nil(t, rm.UpdateChannelRule(ids[2], "casino", &Rule{Commission: 0.5, Share: 0.4}))
+	assert.Nil(t, rm.UpdateChannelRule(ids[2], WildcardChannel, &Rule{Commission: 0.1, Share: 0.1}))
+	assert.Nil(t, rm.UpdateChannelRule(ids[1], WildcardChannel, &Rule{Commission: 0.7, Share: 0.6}))
+
+	ticket := newLimitTestTicket(ids[2])
+	ticket.Channel = "casino/slots/vendor"
+
+	entries, err := bu.CalculateRewards(ticket)
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(400), entries[0].Gain)
+		assert.Equal(t, int64(200), entries[1].Gain)
+	}
+
+	ticket.Channel = "sports"
+
+	entries, err = bu.CalculateRewards(ticket)
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(100), entries[0].Gain)
+	}
+}
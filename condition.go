@@ -1,12 +1,32 @@
 package bursary
 
-import "time"
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidSortField = errors.New("bursary: invalid sort field")
+)
+
+// Fields of members which can be sorted by
+const (
+	SortByID        = "id"
+	SortByCreatedAt = "created_at"
+	SortByDepth     = "depth"
+)
 
 type Condition struct {
 	Page      int          `json:"page"`
 	Limit     int          `json:"limit"`
 	TimeRange *TimeRange   `json:"timeRange,omitempty"`
 	Sort      []*SortField `json:"sort,omitempty"`
+
+	// After is NextCursor of the previous page. Page is ignored if it is set.
+	After string `json:"after,omitempty"`
+
+	// NextCursor is set by queries if there are more items after the page
+	NextCursor string `json:"nextCursor,omitempty"`
 }
 
 type TimeRange struct {
@@ -25,3 +45,58 @@ func NewCondition() *Condition {
 		Limit: 20,
 	}
 }
+
+// Contains reports whether ts is in the range. Both ends are inclusive, and
+// zero time on either end leaves that side unbounded. A nil range contains
+// every time.
+func (tr *TimeRange) Contains(ts time.Time) bool {
+
+	if tr == nil {
+		return true
+	}
+
+	if !tr.StartTime.IsZero() && ts.Before(tr.StartTime) {
+		return false
+	}
+
+	if !tr.EndTime.IsZero() && ts.After(tr.EndTime) {
+		return false
+	}
+
+	return true
+}
+
+// MemberSort validates fields for sorting members and falls back to def if
+// no field was given. ID is appended as the last key unless it is sorted by
+// already, so that order is always total and pages never overlap.
+func MemberSort(fields []*SortField, def ...*SortField) ([]*SortField, error) {
+
+	if len(fields) == 0 {
+		fields = def
+	}
+
+	sorted := make([]*SortField, 0, len(fields)+1)
+
+	hasID := false
+	for _, f := range fields {
+
+		switch f.Field {
+		case SortByID:
+			hasID = true
+		case SortByCreatedAt, SortByDepth:
+		default:
+			return nil, ErrInvalidSortField
+		}
+
+		sorted = append(sorted, f)
+	}
+
+	if !hasID {
+		sorted = append(sorted, &SortField{
+			Field:     SortByID,
+			Ascending: true,
+		})
+	}
+
+	return sorted, nil
+}
@@ -0,0 +1,32 @@
+package bursary_test
+
+import (
+	"testing"
+
+	"github.com/weedbox/bursary/v2"
+	"github.com/weedbox/bursary/v2/bursarytest"
+)
+
+func Test_RelationManagerMemory_Conformance(t *testing.T) {
+	bursarytest.RunRelationManagerSuite(t, func(t *testing.T) bursary.RelationManager {
+		return bursary.NewRelationManagerMemory()
+	})
+}
+
+func Test_RelationManagerOverlay_Conformance(t *testing.T) {
+	bursarytest.RunRelationManagerSuite(t, func(t *testing.T) bursary.RelationManager {
+		return bursary.NewRelationManagerOverlay(bursary.NewRelationManagerMemory())
+	})
+}
+
+func Test_LedgerMemory_Conformance(t *testing.T) {
+	bursarytest.RunLedgerSuite(t, func(t *testing.T) bursary.Ledger {
+		return bursary.NewLedgerMemory()
+	})
+}
+
+func Test_ChannelRegistryMemory_Conformance(t *testing.T) {
+	bursarytest.RunChannelRegistrySuite(t, func(t *testing.T) bursary.ChannelRegistry {
+		return bursary.NewChannelRegistryMemory()
+	})
+}
@@ -0,0 +1,111 @@
+package bursary
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidCursor = errors.New("bursary: invalid cursor")
+)
+
+// Cursor points right after the last item of a page. For members it holds
+// sort keys of that item, and for ledger entries the sequence of writing.
+// Callers should treat encoded cursors as opaque strings.
+type Cursor struct {
+	Sort      string    `json:"s,omitempty"`
+	ID        string    `json:"id,omitempty"`
+	CreatedAt time.Time `json:"ts"`
+	Depth     int       `json:"d,omitempty"`
+	Seq       int64     `json:"seq,omitempty"`
+}
+
+// NewMemberCursor returns cursor after m when members are sorted by fields.
+func NewMemberCursor(m *Member, fields []*SortField) *Cursor {
+	return &Cursor{
+		Sort:      sortSignature(fields),
+		ID:        m.ID,
+		CreatedAt: m.CreatedAt,
+		Depth:     len(m.RelationPath),
+	}
+}
+
+// ParseCursor decodes s which was returned as NextCursor by a query sorted by
+// fields. Cursors of queries sorted differently are rejected.
+func ParseCursor(s string, fields []*SortField) (*Cursor, error) {
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	if c.Sort != sortSignature(fields) {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+func (c *Cursor) String() string {
+
+	raw, _ := json.Marshal(c)
+
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// Compare returns a negative number if c comes before o in order of fields,
+// a positive number if it comes after, and zero if both point to the same
+// position.
+func (c *Cursor) Compare(o *Cursor, fields []*SortField) int {
+
+	for _, f := range fields {
+
+		var r int
+		switch f.Field {
+		case SortByID:
+			r = strings.Compare(c.ID, o.ID)
+		case SortByCreatedAt:
+			if c.CreatedAt.Before(o.CreatedAt) {
+				r = -1
+			} else if c.CreatedAt.After(o.CreatedAt) {
+				r = 1
+			}
+		case SortByDepth:
+			r = c.Depth - o.Depth
+		}
+
+		if r == 0 {
+			continue
+		}
+
+		if !f.Ascending {
+			return -r
+		}
+
+		return r
+	}
+
+	return 0
+}
+
+func sortSignature(fields []*SortField) string {
+
+	keys := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if f.Ascending {
+			keys = append(keys, f.Field+"+")
+		} else {
+			keys = append(keys, f.Field+"-")
+		}
+	}
+
+	return strings.Join(keys, ",")
+}
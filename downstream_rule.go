@@ -0,0 +1,276 @@
+package bursary
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+var (
+	ErrNotAncestor        = errors.New("bursary: not an ancestor of member")
+	ErrRuleExceedsCeiling = errors.New("bursary: rule exceeds ceiling")
+)
+
+// RuleCeiling limits rules of all members below the member whose rule holds
+// it, in the channel of the rule and all channels below.
+type RuleCeiling struct {
+	Commission float64 `json:"commission"`
+	Share      float64 `json:"share"`
+}
+
+// lower returns the lower of both ceilings on each share. Nil means no
+// ceiling.
+func (c *RuleCeiling) lower(o *RuleCeiling) *RuleCeiling {
+
+	if c == nil {
+		return o
+	}
+
+	if o == nil {
+		return c
+	}
+
+	l := *c
+
+	if o.Commission < l.Commission {
+		l.Commission = o.Commission
+	}
+
+	if o.Share < l.Share {
+		l.Share = o.Share
+	}
+
+	return &l
+}
+
+// exceededBy reports whether r grants more than the ceiling.
+func (c *RuleCeiling) exceededBy(r *Rule) bool {
+
+	if c == nil {
+		return false
+	}
+
+	if r.Commission > c.Commission || r.Share > c.Share {
+		return true
+	}
+
+	for _, tier := range r.Tiers {
+		if tier.Commission > c.Commission || tier.Share > c.Share {
+			return true
+		}
+	}
+
+	return r.Ceiling != nil && (r.Ceiling.Commission > c.Commission || r.Ceiling.Share > c.Share)
+}
+
+// clamp returns a copy of r lowered to the ceiling, or nil if r doesn't
+// exceed it.
+func (c *RuleCeiling) clamp(r *Rule) *Rule {
+
+	if !c.exceededBy(r) {
+		return nil
+	}
+
+	cr := r.clone()
+	cr.Commission = minFloat(cr.Commission, c.Commission)
+	cr.Share = minFloat(cr.Share, c.Share)
+
+	for _, tier := range cr.Tiers {
+		tier.Commission = minFloat(tier.Commission, c.Commission)
+		tier.Share = minFloat(tier.Share, c.Share)
+	}
+
+	if cr.Ceiling != nil {
+		cr.Ceiling = cr.Ceiling.lower(c)
+	}
+
+	return cr
+}
+
+func minFloat(a float64, b float64) float64 {
+
+	if b < a {
+		return b
+	}
+
+	return a
+}
+
+// ceilingAbove returns ceiling applied to member by all its upstreams in
+// channel.
+func (b *bursary) ceilingAbove(ctx context.Context, mid string, channel string) (*RuleCeiling, error) {
+
+	upstreams, err := b.rm.GetUpstreamsContext(ctx, mid)
+	if err != nil {
+		return nil, err
+	}
+
+	var ceiling *RuleCeiling
+	for _, u := range upstreams {
+		if r := u.GetChannelRule(channel); r != nil {
+			ceiling = ceiling.lower(r.Ceiling)
+		}
+	}
+
+	return ceiling, nil
+}
+
+// walkDownline calls fn for every descendant of mid, level by level, so that
+// upstreams are always visited before their downstreams.
+func walkDownline(ctx context.Context, rm RelationManager, mid string, fn func(m *Member) error) error {
+
+	cond := &Condition{
+		Limit: 500,
+		Sort: []*SortField{
+			{Field: SortByDepth, Ascending: true},
+		},
+	}
+
+	for {
+		members, err := rm.ListDescendantsContext(ctx, mid, 0, cond)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range members {
+			if err := fn(m); err != nil {
+				return err
+			}
+		}
+
+		if len(cond.NextCursor) == 0 {
+			return nil
+		}
+
+		cond.After = cond.NextCursor
+		cond.NextCursor = ""
+	}
+}
+
+func (b *bursary) SetDownstreamRule(upstream string, mid string, channel string, rule *Rule) error {
+	return b.SetDownstreamRuleContext(context.Background(), upstream, mid, channel, rule)
+}
+
+// SetDownstreamRuleContext sets rule of channel for member mid on behalf of
+// upstream, which must be one of its ancestors. Rule can't exceed ceilings
+// set by any upstream of mid. Ceiling of rule then cascades through the
+// downline of mid, and rules of channel and channels below it exceeding
+// ceilings are lowered. Rules are written in a batch per channel.
+func (b *bursary) SetDownstreamRuleContext(ctx context.Context, upstream string, mid string, channel string, rule *Rule) error {
+
+	if rule == nil {
+		return nil
+	}
+
+	path, err := b.rm.GetPathContext(ctx, mid)
+	if err != nil {
+		return err
+	}
+
+	ancestor := false
+	for _, id := range path {
+		if id == upstream {
+			ancestor = true
+			break
+		}
+	}
+
+	if !ancestor || upstream == mid {
+		return ErrNotAncestor
+	}
+
+	ceiling, err := b.ceilingAbove(ctx, mid, channel)
+	if err != nil {
+		return err
+	}
+
+	if ceiling.exceededBy(rule) {
+		return ErrRuleExceedsCeiling
+	}
+
+	updates, err := b.cascadeCeiling(ctx, mid, channel, ceiling.lower(rule.Ceiling))
+	if err != nil {
+		return err
+	}
+
+	updates[channel][mid] = rule
+
+	// Channels below go first, as lowering their rules early is harmless if
+	// writing the channel itself fails
+	channels := make([]string, 0, len(updates))
+	for ch := range updates {
+		if ch != channel {
+			channels = append(channels, ch)
+		}
+	}
+
+	sort.Strings(channels)
+	channels = append(channels, channel)
+
+	for _, ch := range channels {
+		err := b.rm.UpdateChannelRulesContext(ctx, ch, updates[ch])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cascadeCeiling returns rules of descendants of mid lowered to ceiling, or
+// ceilings set below mid, by channel and member. Rules resolved for channel
+// are lowered at channel, so that rules inherited from channels above or the
+// wildcard one can't escape ceiling, while rules of channels below are
+// lowered where they are.
+func (b *bursary) cascadeCeiling(ctx context.Context, mid string, channel string, ceiling *RuleCeiling) (map[string]map[string]*Rule, error) {
+
+	updates := map[string]map[string]*Rule{
+		channel: make(map[string]*Rule),
+	}
+
+	// Ceilings applied to downstreams of each member
+	ceilings := map[string]*RuleCeiling{
+		mid: ceiling,
+	}
+
+	branch := channel + ChannelSeparator + WildcardChannel
+
+	err := walkDownline(ctx, b.rm, mid, func(m *Member) error {
+
+		c := ceilings[m.Upstream]
+		ceilings[m.ID] = c
+
+		if r := m.GetChannelRule(channel); r != nil {
+
+			if cr := c.clamp(r); cr != nil {
+				updates[channel][m.ID] = cr
+				r = cr
+			}
+
+			ceilings[m.ID] = c.lower(r.Ceiling)
+		}
+
+		for ch, r := range m.ChannelRules {
+
+			if ch == channel || !MatchChannel(branch, ch) {
+				continue
+			}
+
+			if cr := c.clamp(r); cr != nil {
+
+				if _, ok := updates[ch]; !ok {
+					updates[ch] = make(map[string]*Rule)
+				}
+
+				updates[ch][m.ID] = cr
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return updates, nil
+}
@@ -0,0 +1,235 @@
+package bursary
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestRule(t *testing.T, bu Bursary, mid string, channel string) *Rule {
+
+	m, err := bu.RelationManager().GetMember(mid)
+	if !assert.Nil(t, err) {
+		return nil
+	}
+
+	return m.ChannelRules[channel]
+}
+
+func Test_SetDownstreamRule_NotAncestor(t *testing.T) {
+
+	bu := NewBursary()
+	ids := addTestChain(t, bu.RelationManager(), "default", newLimitTestRules()...)
+
+	// Sibling of the middle level
+	sibling := genTestID()
+	err := bu.RelationManager().AddMembers([]*MemberEntry{
+		&MemberEntry{ID: sibling},
+	}, ids[0])
+	assert.Nil(t, err)
+
+	rule := &Rule{Commission: 0.5, Share: 0.4}
+
+	assert.ErrorIs(t, bu.SetDownstreamRule(ids[2], ids[1], "default", rule), ErrNotAncestor)
+	assert.ErrorIs(t, bu.SetDownstreamRule(ids[1], ids[1], "default", rule), ErrNotAncestor)
+	assert.ErrorIs(t, bu.SetDownstreamRule(sibling, ids[1], "default", rule), ErrNotAncestor)
+	assert.ErrorIs(t, bu.SetDownstreamRule(genTestID(), ids[1], "default", rule), ErrNotAncestor)
+
+	// Any ancestor may set rules
+	assert.Nil(t, bu.SetDownstreamRule(ids[0], ids[2], "default", rule))
+	assert.Equal(t, 0.4, getTestRule(t, bu, ids[2], "default").Share)
+}
+
+func Test_SetDownstreamRule_ExceedsCeiling(t *testing.T) {
+
+	bu := NewBursary()
+	ids := addTestChain(t, bu.RelationManager(), "default", newLimitTestRules()...)
+
+	err := bu.SetDownstreamRule(ids[0], ids[1], "default", &Rule{
+		Commission: 0.7,
+		Share:      0.6,
+		Ceiling:    &RuleCeiling{Commission: 0.6, Share: 0.4},
+	})
+	assert.Nil(t, err)
+
+	err = bu.SetDownstreamRule(ids[1], ids[2], "default", &Rule{Commission: 0.6, Share: 0.5})
+	assert.ErrorIs(t, err, ErrRuleExceedsCeiling)
+
+	// Tiers can't exceed it either
+	err = bu.SetDownstreamRule(ids[1], ids[2], "default", &Rule{
+		Commission: 0.5,
+		Share:      0.3,
+		Tiers: []*Tier{
+			{Threshold: 10000, Commission: 0.6, Share: 0.5},
+		},
+	})
+	assert.ErrorIs(t, err, ErrRuleExceedsCeiling)
+
+	// Nor ceilings set below
+	err = bu.SetDownstreamRule(ids[1], ids[2], "default", &Rule{
+		Commission: 0.5,
+		Share:      0.3,
+		Ceiling:    &RuleCeiling{Commission: 0.6, Share: 0.5},
+	})
+	assert.ErrorIs(t, err, ErrRuleExceedsCeiling)
+
+	// Ceiling covers sub-channels
+	err = bu.SetDownstreamRule(ids[1], ids[2], "default/slot", &Rule{Commission: 0.6, Share: 0.5})
+	assert.ErrorIs(t, err, ErrRuleExceedsCeiling)
+
+	err = bu.SetDownstreamRule(ids[1], ids[2], "default", &Rule{Commission: 0.6, Share: 0.4})
+	assert.Nil(t, err)
+
+	// Rule is unchanged by rejected attempts
+	r := getTestRule(t, bu, ids[2], "default")
+	if assert.NotNil(t, r) {
+		assert.Equal(t, 0.6, r.Commission)
+		assert.Equal(t, 0.4, r.Share)
+		assert.Nil(t, r.Ceiling)
+	}
+}
+
+func Test_SetDownstreamRule_ClampDownline(t *testing.T) {
+
+	bu := NewBursary()
+	ids := addTestChain(t, bu.RelationManager(), "default", newLimitTestRules()...)
+
+	rm := bu.RelationManager()
+	assert.Nil(t, rm.UpdateChannelRule(ids[2], "default/slot", &Rule{
+		Commission: 0.5,
+		Share:      0.4,
+		Tiers: []*Tier{
+			{Threshold: 10000, Commission: 0.6, Share: 0.5},
+		},
+	}))
+	assert.Nil(t, rm.UpdateChannelRule(ids[2], "other", &Rule{Commission: 0.5, Share: 0.4}))
+
+	err := bu.SetDownstreamRule(ids[0], ids[1], "default", &Rule{
+		Commission: 0.7,
+		Share:      0.6,
+		Ceiling:    &RuleCeiling{Commission: 0.4, Share: 0.2},
+	})
+	assert.Nil(t, err)
+
+	r := getTestRule(t, bu, ids[2], "default")
+	if assert.NotNil(t, r) {
+		assert.Equal(t, 0.4, r.Commission)
+		assert.Equal(t, 0.2, r.Share)
+	}
+
+	r = getTestRule(t, bu, ids[2], "default/slot")
+	if assert.NotNil(t, r) {
+		assert.Equal(t, 0.4, r.Commission)
+		assert.Equal(t, 0.2, r.Share)
+		if assert.Len(t, r.Tiers, 1) {
+			assert.Equal(t, 0.4, r.Tiers[0].Commission)
+			assert.Equal(t, 0.2, r.Tiers[0].Share)
+		}
+	}
+
+	// Other channels are untouched
+	r = getTestRule(t, bu, ids[2], "other")
+	if assert.NotNil(t, r) {
+		assert.Equal(t, 0.5, r.Commission)
+		assert.Equal(t, 0.4, r.Share)
+	}
+}
+
+func Test_SetDownstreamRule_Cascade(t *testing.T) {
+
+	bu := NewBursary()
+	rules := newLimitTestRules()
+	rules[2].Commission = 0.3
+	rules[2].Share = 0.2
+	ids := addTestChain(t, bu.RelationManager(), "default", rules...)
+
+	// Fourth level below the bottom
+	leaf := genTestID()
+	err := bu.RelationManager().AddMembers([]*MemberEntry{
+		&MemberEntry{
+			ID: leaf,
+			ChannelRules: map[string]*Rule{
+				"default": &Rule{Commission: 0.2, Share: 0.15},
+			},
+		},
+	}, ids[2])
+	assert.Nil(t, err)
+
+	err = bu.SetDownstreamRule(ids[1], ids[2], "default", &Rule{
+		Commission: 0.3,
+		Share:      0.2,
+		Ceiling:    &RuleCeiling{Commission: 0.25, Share: 0.15},
+	})
+	assert.Nil(t, err)
+
+	// Lowering the ceiling above lowers the ceiling below and its downline
+	err = bu.SetDownstreamRule(ids[0], ids[1], "default", &Rule{
+		Commission: 0.7,
+		Share:      0.6,
+		Ceiling:    &RuleCeiling{Commission: 0.2, Share: 0.1},
+	})
+	assert.Nil(t, err)
+
+	r := getTestRule(t, bu, ids[2], "default")
+	if assert.NotNil(t, r) {
+		assert.Equal(t, 0.2, r.Commission)
+		assert.Equal(t, 0.1, r.Share)
+		if assert.NotNil(t, r.Ceiling) {
+			assert.Equal(t, 0.2, r.Ceiling.Commission)
+			assert.Equal(t, 0.1, r.Ceiling.Share)
+		}
+	}
+
+	r = getTestRule(t, bu, leaf, "default")
+	if assert.NotNil(t, r) {
+		assert.Equal(t, 0.2, r.Commission)
+		assert.Equal(t, 0.1, r.Share)
+	}
+
+	// Tickets follow the lowered rules
+	entries, err := bu.CalculateRewards(newLimitTestTicket(leaf))
+	if assert.Nil(t, err) && assert.Len(t, entries, 4) {
+		assert.Equal(t, int64(100), entries[0].Gain)
+	}
+}
+
+func Test_SetDownstreamRule_InheritedRules(t *testing.T) {
+
+	bu := NewBursary()
+	ids := addTestChain(t, bu.RelationManager(), "default", newLimitTestRules()...)
+
+	// Rules resolved for casino/slots through channels above
+	rm := bu.RelationManager()
+	assert.Nil(t, rm.UpdateChannelRule(ids[2], "casino", &Rule{Commission: 0.7, Share: 0.6}))
+
+	leaf := genTestID()
+	err := rm.AddMembers([]*MemberEntry{
+		&MemberEntry{
+			ID: leaf,
+			ChannelRules: map[string]*Rule{
+				WildcardChannel: &Rule{Commission: 0.5, Share: 0.4},
+			},
+		},
+	}, ids[2])
+	assert.Nil(t, err)
+
+	err = bu.SetDownstreamRule(ids[0], ids[1], "casino/slots", &Rule{
+		Commission: 0.7,
+		Share:      0.6,
+		Ceiling:    &RuleCeiling{Commission: 0.1, Share: 0.1},
+	})
+	assert.Nil(t, err)
+
+	for _, id := range []string{ids[2], leaf} {
+		m, err := rm.GetMember(id)
+		if assert.Nil(t, err) {
+			r := m.GetChannelRule("casino/slots")
+			assert.Equal(t, 0.1, r.Commission)
+			assert.Equal(t, 0.1, r.Share)
+		}
+	}
+
+	// Rules of other channels are kept
+	assert.Equal(t, 0.7, getTestRule(t, bu, ids[2], "casino").Commission)
+	assert.Equal(t, 0.5, getTestRule(t, bu, leaf, WildcardChannel).Commission)
+}
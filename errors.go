@@ -0,0 +1,56 @@
+package bursary
+
+import (
+	"errors"
+	"strings"
+)
+
+// MultiError holds errors of an operation which kept going after failures,
+// such as closing every ledger.
+type MultiError []error
+
+func (me MultiError) Error() string {
+
+	msgs := make([]string, 0, len(me))
+	for _, err := range me {
+		msgs = append(msgs, err.Error())
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// Is makes errors.Is look into every error, which it doesn't do on its own
+// before Go 1.20.
+func (me MultiError) Is(target error) bool {
+
+	for _, err := range me {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// As makes errors.As look into every error, and sets target to the first
+// error matching it.
+func (me MultiError) As(target interface{}) bool {
+
+	for _, err := range me {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// errorOrNil returns nil if there is no error at all.
+func (me MultiError) errorOrNil() error {
+
+	if len(me) == 0 {
+		return nil
+	}
+
+	return me
+}
@@ -0,0 +1,74 @@
+package bursary
+
+import (
+	"math"
+	"strings"
+)
+
+// currencyExponents lists currencies whose minor unit isn't a hundredth.
+// Tickets without currency have no minor unit.
+var currencyExponents = map[string]int{
+	"":    0,
+	"BHD": 3,
+	"CLP": 0,
+	"ISK": 0,
+	"JOD": 3,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"OMR": 3,
+	"TND": 3,
+	"UGX": 0,
+	"VND": 0,
+}
+
+// WithCurrencyExponent sets the number of decimal digits of minor unit of
+// currency, which is used to convert fixed amounts of rules. Currencies have
+// 2 digits unless they're known to have another number.
+func WithCurrencyExponent(currency string, exponent int) Opt {
+	return func(b *bursary) {
+
+		if b.exponents == nil {
+			b.exponents = make(map[string]int)
+		}
+
+		b.exponents[strings.ToUpper(currency)] = exponent
+	}
+}
+
+func (b *bursary) currencyExponent(currency string) int {
+
+	currency = strings.ToUpper(currency)
+
+	if exp, ok := b.exponents[currency]; ok {
+		return exp
+	}
+
+	if exp, ok := currencyExponents[currency]; ok {
+		return exp
+	}
+
+	return 2
+}
+
+// minorUnits converts amount in major units of currency to minor units,
+// rounding half away from zero.
+func (b *bursary) minorUnits(currency string, amount float64) int64 {
+	return int64(math.Round(amount * math.Pow10(b.currencyExponent(currency))))
+}
+
+// fixedAmount returns fixed amount of r for ticket in minor units, which is
+// the fixed amount per ticket plus the bonus of ticket event.
+func (b *bursary) fixedAmount(t *Ticket, r *Rule) int64 {
+
+	amount := r.Fixed
+	if len(t.Event) > 0 {
+		amount += r.Bonuses[t.Event]
+	}
+
+	if amount == 0 {
+		return 0
+	}
+
+	return b.minorUnits(t.Currency, amount)
+}
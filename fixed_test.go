@@ -0,0 +1,104 @@
+package bursary
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MinorUnits(t *testing.T) {
+
+	b := NewBursary(WithCurrencyExponent("xau", 4)).(*bursary)
+
+	assert.Equal(t, int64(5000), b.minorUnits("USD", 50))
+	assert.Equal(t, int64(1235), b.minorUnits("usd", 12.345))
+	assert.Equal(t, int64(-1235), b.minorUnits("USD", -12.345))
+	assert.Equal(t, int64(13), b.minorUnits("JPY", 12.5))
+	assert.Equal(t, int64(1500), b.minorUnits("KWD", 1.5))
+	assert.Equal(t, int64(15000), b.minorUnits("XAU", 1.5))
+
+	// No currency
+	assert.Equal(t, int64(2), b.minorUnits("", 1.5))
+}
+
+func Test_CalculateRewards_Fixed(t *testing.T) {
+
+	bu := NewBursary()
+	defer bu.Close()
+
+	rules := newLimitTestRules()
+	rules[2].Bonuses = map[string]float64{
+		"first_deposit": 50,
+	}
+	rules[1].Fixed = 0.125
+	rules[1].TicketCap = 200
+	ids := addTestChain(t, bu.RelationManager(), "default", rules...)
+
+	ticket := newLimitTestTicket(ids[2])
+	ticket.Currency = "USD"
+
+	// No bonus without event
+	entries, err := bu.CalculateRewards(ticket)
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(0), entries[0].Fixed)
+		assert.Equal(t, int64(13), entries[1].Fixed)
+	}
+
+	ticket.Event = "first_deposit"
+
+	entries, err = bu.CalculateRewards(ticket)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	owner := entries[0]
+	assert.Equal(t, int64(5000), owner.Fixed)
+	assert.Equal(t, int64(300), owner.Gain)
+	assert.Equal(t, int64(50), owner.Commissions)
+	assert.Equal(t, int64(700), owner.Contributions)
+	assert.Equal(t, int64(5350), owner.Earnings())
+
+	// Fixed amounts count toward caps, which take gain first
+	mid := entries[1]
+	assert.Equal(t, int64(187), mid.Gain+mid.Commissions)
+	assert.Equal(t, int64(13), mid.Fixed)
+	assert.Equal(t, int64(200), mid.Total)
+
+	// Shares are left untouched
+	gains := int64(0)
+	for _, le := range entries {
+		gains += le.Gain
+	}
+
+	assert.Equal(t, ticket.Amount, gains)
+
+	jt, err := NewJournalTransaction(ticket, entries, "")
+	if assert.Nil(t, err) {
+		assert.Nil(t, jt.Validate())
+	}
+}
+
+func Test_WriteTicket_FixedJournal(t *testing.T) {
+
+	js := NewJournalStoreMemory()
+	bu := NewBursary(WithJournal(js))
+	defer bu.Close()
+
+	rules := newLimitTestRules()
+	rules[2].Fixed = 1
+	ids := addTestChain(t, bu.RelationManager(), "default", rules...)
+
+	ticket := newLimitTestTicket(ids[2])
+	ticket.Currency = "EUR"
+	assert.Nil(t, bu.WriteTicket(ticket))
+
+	bonuses, err := js.Balance(ChannelBonuses(ticket.Channel))
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(100), bonuses)
+	}
+
+	payable, err := js.Balance(MemberPayable(ids[2]))
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(-450), payable)
+	}
+}
@@ -0,0 +1,321 @@
+package bursary
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	ErrLedgerTampered = errors.New("bursary: ledger tampered")
+)
+
+// TamperError tells which entry broke the chain and how.
+type TamperError struct {
+	Sequence int64
+	Reason   string
+}
+
+func (e *TamperError) Error() string {
+	return fmt.Sprintf("%s: sequence %d: %s", ErrLedgerTampered, e.Sequence, e.Reason)
+}
+
+func (e *TamperError) Unwrap() error {
+	return ErrLedgerTampered
+}
+
+// HashChainLedger wraps a ledger to make its history tamper-evident. Every
+// entry written through it gets the next sequence number and a hash covering
+// the entry and the hash of the previous one. Entries written to the
+// underlying ledger directly have no sequence and are ignored.
+type HashChainLedger struct {
+	Ledger
+
+	mutex  sync.Mutex
+	loaded bool
+	seq    int64
+	last   string
+}
+
+// canonicalEntry is the encoding of an entry which is hashed. Fields are in
+// fixed order, and time is truncated to microseconds in UTC so that it
+// survives round trips through every storage.
+type canonicalEntry struct {
+	Sequence        int64                  `json:"sequence"`
+	PrevHash        string                 `json:"prev_hash"`
+	ID              string                 `json:"id"`
+	Channel         string                 `json:"channel"`
+	Upstream        string                 `json:"upstream"`
+	MemberID        string                 `json:"member_id"`
+	Contributor     string                 `json:"contributor"`
+	Expense         int64                  `json:"expense"`
+	Income          int64                  `json:"income"`
+	Amount          int64                  `json:"amount"`
+	Fee             int64                  `json:"fee"`
+	Share           float64                `json:"share"`
+	ReturnedShare   float64                `json:"returned_share"`
+	CommissionShare float64                `json:"commission_share"`
+	Gain            int64                  `json:"gain"`
+	Commissions     int64                  `json:"commissions"`
+	Contributions   int64                  `json:"contributions"`
+	Fixed           int64                  `json:"fixed,omitempty"`
+	Total           int64                  `json:"total"`
+	Currency        string                 `json:"currency,omitempty"`
+	Desc            string                 `json:"desc"`
+	Info            map[string]interface{} `json:"info"`
+	PrimaryID       string                 `json:"primary_id"`
+	IsPrimary       bool                   `json:"is_primary"`
+	CreatedAt       string                 `json:"created_at"`
+}
+
+func NewHashChainLedger(l Ledger) *HashChainLedger {
+	return &HashChainLedger{
+		Ledger: l,
+	}
+}
+
+// HashEntry returns hash of le chained to its PrevHash.
+func HashEntry(le *LedgerEntry) (string, error) {
+
+	info := le.Info
+	if len(info) == 0 {
+		// Storages don't tell nil from empty
+		info = nil
+	}
+
+	data, err := json.Marshal(&canonicalEntry{
+		Sequence:        le.Sequence,
+		PrevHash:        le.PrevHash,
+		ID:              le.ID,
+		Channel:         le.Channel,
+		Upstream:        le.Upstream,
+		MemberID:        le.MemberID,
+		Contributor:     le.Contributor,
+		Expense:         le.Expense,
+		Income:          le.Income,
+		Amount:          le.Amount,
+		Fee:             le.Fee,
+		Share:           le.Share,
+		ReturnedShare:   le.ReturnedShare,
+		CommissionShare: le.CommissionShare,
+		Gain:            le.Gain,
+		Commissions:     le.Commissions,
+		Contributions:   le.Contributions,
+		Fixed:           le.Fixed,
+		Total:           le.Total,
+		Currency:        le.Currency,
+		Desc:            le.Desc,
+		Info:            info,
+		PrimaryID:       le.PrimaryID,
+		IsPrimary:       le.IsPrimary,
+		CreatedAt:       le.CreatedAt.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// load finds the tail of chain when writing for the first time.
+func (hl *HashChainLedger) load(ctx context.Context) error {
+
+	if hl.loaded {
+		return nil
+	}
+
+	err := hl.Ledger.ScanRecordsContext(ctx, nil, func(le *LedgerEntry) error {
+
+		if le.Sequence > hl.seq {
+			hl.seq = le.Sequence
+			hl.last = le.Hash
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	hl.loaded = true
+
+	return nil
+}
+
+func (hl *HashChainLedger) WriteRecords(entries []*LedgerEntry) error {
+	return hl.WriteRecordsContext(context.Background(), entries)
+}
+
+// WriteRecordsContext writes copies of entries with sequence and hashes set,
+// leaving entries of caller untouched, as they may be written to other
+// ledgers as well. The chain only moves forward if writing succeeded.
+func (hl *HashChainLedger) WriteRecordsContext(ctx context.Context, entries []*LedgerEntry) error {
+
+	hl.mutex.Lock()
+	defer hl.mutex.Unlock()
+
+	if err := hl.load(ctx); err != nil {
+		return err
+	}
+
+	stamped := make([]*LedgerEntry, 0, len(entries))
+
+	seq := hl.seq
+	last := hl.last
+	for _, e := range entries {
+
+		seq++
+
+		le := *e
+		le.Sequence = seq
+		le.PrevHash = last
+
+		hash, err := HashEntry(&le)
+		if err != nil {
+			return err
+		}
+
+		le.Hash = hash
+		last = hash
+
+		stamped = append(stamped, &le)
+	}
+
+	err := hl.Ledger.WriteRecordsContext(ctx, stamped)
+	if err != nil {
+		return err
+	}
+
+	hl.seq = seq
+	hl.last = last
+
+	return nil
+}
+
+func (hl *HashChainLedger) Verify(from int64, to int64) error {
+	return hl.VerifyContext(context.Background(), from, to)
+}
+
+// VerifyContext checks entries with sequence from from to to, and returns a
+// TamperError for the first modified, missing or reordered entry. Sequence
+// starts from 1, and to less than 1 means the end of ledger, which must reach
+// the head of chain known to hl, so that entries deleted at the end are
+// detected as well.
+func (hl *HashChainLedger) VerifyContext(ctx context.Context, from int64, to int64) error {
+
+	if from < 1 {
+		from = 1
+	}
+
+	hl.mutex.Lock()
+	err := hl.load(ctx)
+	head := hl.seq
+	last := hl.last
+	hl.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	expected := from
+	prev := ""
+	err = hl.Ledger.ScanRecordsContext(ctx, nil, func(le *LedgerEntry) error {
+
+		// Not chained
+		if le.Sequence == 0 || le.Sequence < from {
+
+			// Hash of the entry right before the range links to the first one
+			if le.Sequence == from-1 {
+				prev = le.Hash
+			}
+
+			return nil
+		}
+
+		if to > 0 && le.Sequence > to {
+			return ErrStopScan
+		}
+
+		if le.Sequence != expected {
+			return &TamperError{
+				Sequence: expected,
+				Reason:   fmt.Sprintf("found sequence %d instead", le.Sequence),
+			}
+		}
+
+		hash, err := HashEntry(le)
+		if err != nil {
+			return err
+		}
+
+		if hash != le.Hash {
+			return &TamperError{
+				Sequence: le.Sequence,
+				Reason:   "hash mismatch",
+			}
+		}
+
+		// The first entry of range is trusted if the entry before is unknown
+		if le.PrevHash != prev && (le.Sequence != from || len(prev) > 0 || from == 1) {
+			return &TamperError{
+				Sequence: le.Sequence,
+				Reason:   "broken link to previous entry",
+			}
+		}
+
+		prev = le.Hash
+		expected++
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Entries at the end of range are missing
+	if to > 0 && expected <= to {
+		return &TamperError{
+			Sequence: expected,
+			Reason:   "entry missing",
+		}
+	}
+
+	if to > 0 {
+		return nil
+	}
+
+	// Entries at the end of ledger are missing
+	if expected <= head {
+		return &TamperError{
+			Sequence: expected,
+			Reason:   "entry missing",
+		}
+	}
+
+	if expected-1 == head && expected > from && prev != last {
+		return &TamperError{
+			Sequence: head,
+			Reason:   "entry missing",
+		}
+	}
+
+	return nil
+}
+
+// Close closes the underlying ledger if it implements io.Closer.
+func (hl *HashChainLedger) Close() error {
+
+	if c, ok := hl.Ledger.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package bursary
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestChain(t *testing.T, l Ledger, n int) []*LedgerEntry {
+
+	entries := make([]*LedgerEntry, 0, n)
+	for i := 0; i < n; i++ {
+		entries = append(entries, &LedgerEntry{
+			ID:        genTestID(),
+			Channel:   "default",
+			MemberID:  "member",
+			Amount:    int64(100 * (i + 1)),
+			Info:      map[string]interface{}{"round": i},
+			CreatedAt: time.Now(),
+		})
+	}
+
+	// Written in two batches
+	assert.Nil(t, l.WriteRecords(entries[:n/2]))
+	assert.Nil(t, l.WriteRecords(entries[n/2:]))
+
+	return entries
+}
+
+func assertTampered(t *testing.T, err error, seq int64) {
+
+	var te *TamperError
+	if assert.True(t, errors.As(err, &te)) {
+		assert.Equal(t, seq, te.Sequence)
+	}
+
+	assert.ErrorIs(t, err, ErrLedgerTampered)
+}
+
+func Test_HashChainLedger_Write(t *testing.T) {
+
+	l := NewLedgerMemory()
+	hl := NewHashChainLedger(l)
+	written := writeTestChain(t, hl, 6)
+
+	// Entries of caller are left untouched
+	assert.Equal(t, int64(0), written[0].Sequence)
+	assert.Empty(t, written[0].Hash)
+
+	entries := l.(*ledgerMemory).records
+	for i, le := range entries {
+		assert.Equal(t, int64(i+1), le.Sequence)
+		assert.NotEmpty(t, le.Hash)
+
+		if i == 0 {
+			assert.Empty(t, le.PrevHash)
+		} else {
+			assert.Equal(t, entries[i-1].Hash, le.PrevHash)
+		}
+	}
+
+	assert.Nil(t, hl.Verify(0, 0))
+	assert.Nil(t, hl.Verify(2, 4))
+	assert.Nil(t, hl.Verify(6, 6))
+
+	// Chain continues after restart
+	reopened := NewHashChainLedger(hl.Ledger)
+	writeTestChain(t, reopened, 2)
+
+	more := l.(*ledgerMemory).records[6:]
+	assert.Equal(t, int64(7), more[0].Sequence)
+	assert.Equal(t, entries[5].Hash, more[0].PrevHash)
+
+	assert.Nil(t, reopened.Verify(0, 0))
+
+	// Range goes beyond the end
+	assertTampered(t, reopened.Verify(7, 10), 9)
+}
+
+func Test_HashChainLedger_Modified(t *testing.T) {
+
+	l := NewLedgerMemory()
+	hl := NewHashChainLedger(l)
+	writeTestChain(t, hl, 6)
+
+	records := l.(*ledgerMemory).records
+	records[2].Amount = 1
+
+	assertTampered(t, hl.Verify(0, 0), 3)
+
+	// Hash was recomputed by whoever modified it
+	hash, err := HashEntry(records[2])
+	assert.Nil(t, err)
+	records[2].Hash = hash
+
+	assertTampered(t, hl.Verify(0, 0), 4)
+
+	// Entries before are still fine
+	assert.Nil(t, hl.Verify(1, 2))
+}
+
+func Test_HashChainLedger_Deleted(t *testing.T) {
+
+	l := NewLedgerMemory()
+	hl := NewHashChainLedger(l)
+	writeTestChain(t, hl, 6)
+
+	lm := l.(*ledgerMemory)
+	lm.records = append(lm.records[:3:3], lm.records[4:]...)
+
+	assertTampered(t, hl.Verify(0, 0), 4)
+
+	// The last one
+	lm.records = lm.records[:len(lm.records)-1]
+	assertTampered(t, hl.Verify(5, 6), 6)
+}
+
+func Test_HashChainLedger_TailDeleted(t *testing.T) {
+
+	l := NewLedgerMemory()
+	hl := NewHashChainLedger(l)
+	writeTestChain(t, hl, 6)
+
+	// Chain ends early at the end of ledger
+	lm := l.(*ledgerMemory)
+	lm.records = lm.records[:4]
+
+	assertTampered(t, hl.Verify(0, 0), 5)
+	assertTampered(t, hl.Verify(3, 0), 5)
+
+	lm.records = lm.records[:0]
+	assertTampered(t, hl.Verify(0, 0), 1)
+}
+
+func Test_HashChainLedger_Reordered(t *testing.T) {
+
+	l := NewLedgerMemory()
+	hl := NewHashChainLedger(l)
+	writeTestChain(t, hl, 6)
+
+	records := l.(*ledgerMemory).records
+	records[1], records[2] = records[2], records[1]
+
+	assertTampered(t, hl.Verify(0, 0), 2)
+}
+
+func Test_HashChainLedger_Routes(t *testing.T) {
+
+	gl := NewHashChainLedger(NewLedgerMemory())
+	other := NewHashChainLedger(NewLedgerMemory())
+
+	bu := NewBursary(
+		WithGeneralLedger(gl),
+		WithLedgerRoutes(RouteToGeneral(), RouteTo("other")),
+	)
+	defer bu.Close()
+
+	assert.Nil(t, bu.LedgerManager().Add("other", other))
+
+	// Other chain is ahead
+	writeTestChain(t, other, 2)
+
+	r := &Rule{Commission: 0.5, Share: 0.5}
+	ids := addTestChain(t, bu.RelationManager(), "slot", r, r, r)
+
+	ticket := NewTicket()
+	ticket.Channel = "slot"
+	ticket.MemberID = ids[2]
+	ticket.Amount = 1000
+	assert.Nil(t, bu.WriteTicket(ticket))
+
+	// Both chains stay intact when sharing entries
+	assert.Nil(t, gl.Verify(0, 0))
+	assert.Nil(t, other.Verify(0, 0))
+}
@@ -0,0 +1,265 @@
+package bursary
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrUnbalancedJournal = errors.New("bursary: unbalanced journal transaction")
+)
+
+// HouseAccount receives whatever is left after distributing a ticket.
+const HouseAccount = "house"
+
+// MemberReceivable is the account of what member owes for its tickets.
+func MemberReceivable(memberID string) string {
+	return "member:" + memberID + ":receivable"
+}
+
+// MemberPayable is the account of rewards owed to member.
+func MemberPayable(memberID string) string {
+	return "member:" + memberID + ":payable"
+}
+
+// ChannelRevenue is the account of ticket amounts in channel.
+func ChannelRevenue(channel string) string {
+	return "channel:" + channel + ":revenue"
+}
+
+// ChannelFees is the account of ticket fees in channel.
+func ChannelFees(channel string) string {
+	return "channel:" + channel + ":fees"
+}
+
+// ChannelBonuses is the account of fixed amounts paid to members in channel.
+func ChannelBonuses(channel string) string {
+	return "channel:" + channel + ":bonuses"
+}
+
+// JournalLine is either a debit or a credit of an account. Both are never
+// negative.
+type JournalLine struct {
+	Account string `json:"account"`
+	Debit   int64  `json:"debit"`
+	Credit  int64  `json:"credit"`
+}
+
+// JournalTransaction is the double-entry view of rewards of a ticket.
+type JournalTransaction struct {
+	ID        string         `json:"id"`
+	TicketID  string         `json:"ticket_id"`
+	Channel   string         `json:"channel"`
+	Desc      string         `json:"desc"`
+	Lines     []*JournalLine `json:"lines"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// NewJournalTransaction turns rewards calculated for a ticket into a
+// balanced transaction:
+//
+//	Dr member:<owner>:receivable   amount + fee
+//	    Cr channel:<c>:revenue     amount
+//	    Cr channel:<c>:fees        fee
+//	Dr channel:<c>:revenue         gain of each member
+//	Dr channel:<c>:fees            commissions of each member
+//	    Cr member:<id>:payable     gain + commissions of each member
+//	Dr channel:<c>:bonuses         fixed amounts of each member
+//	    Cr member:<id>:payable     fixed amounts of each member
+//
+// Earnings of entries of house, which is ID of the house account or empty
+// without one, and anything not distributed to members are credited to
+// house. Negative amounts move to the opposite side.
+func NewJournalTransaction(t *Ticket, entries []*LedgerEntry, house string) (*JournalTransaction, error) {
+
+	jt := &JournalTransaction{
+		ID:        t.ID,
+		TicketID:  t.ID,
+		Channel:   t.Channel,
+		Desc:      t.Desc,
+		Lines:     make([]*JournalLine, 0),
+		CreatedAt: t.CreatedAt,
+	}
+
+	revenue := ChannelRevenue(t.Channel)
+	fees := ChannelFees(t.Channel)
+
+	// Recognizing revenue and fees of ticket
+	jt.debit(MemberReceivable(t.MemberID), t.Amount+t.Fee)
+	jt.credit(revenue, t.Amount)
+	jt.credit(fees, t.Fee)
+
+	// Distributing to members
+	gains := int64(0)
+	commissions := int64(0)
+	for _, le := range entries {
+
+		// House account earns for itself rather than being owed
+		account := MemberPayable(le.MemberID)
+		if len(house) > 0 && le.MemberID == house {
+			account = HouseAccount
+		}
+
+		jt.debit(revenue, le.Gain)
+		jt.debit(fees, le.Commissions)
+		jt.credit(account, le.Gain+le.Commissions)
+
+		// Fixed amounts are expenses rather than parts of ticket
+		jt.debit(ChannelBonuses(t.Channel), le.Fixed)
+		jt.credit(account, le.Fixed)
+
+		gains += le.Gain
+		commissions += le.Commissions
+	}
+
+	// The rest goes to house
+	jt.debit(revenue, t.Amount-gains)
+	jt.debit(fees, t.Fee-commissions)
+	jt.credit(HouseAccount, t.Amount-gains+t.Fee-commissions)
+
+	if err := jt.Validate(); err != nil {
+		return nil, err
+	}
+
+	return jt, nil
+}
+
+func (jt *JournalTransaction) debit(account string, amount int64) {
+
+	switch {
+	case amount > 0:
+		jt.Lines = append(jt.Lines, &JournalLine{
+			Account: account,
+			Debit:   amount,
+		})
+	case amount < 0:
+		jt.Lines = append(jt.Lines, &JournalLine{
+			Account: account,
+			Credit:  -amount,
+		})
+	}
+}
+
+func (jt *JournalTransaction) credit(account string, amount int64) {
+	jt.debit(account, -amount)
+}
+
+// Validate makes sure that debits equal credits.
+func (jt *JournalTransaction) Validate() error {
+
+	debits := int64(0)
+	credits := int64(0)
+	for _, l := range jt.Lines {
+
+		if l.Debit < 0 || l.Credit < 0 {
+			return ErrUnbalancedJournal
+		}
+
+		debits += l.Debit
+		credits += l.Credit
+	}
+
+	if debits != credits {
+		return ErrUnbalancedJournal
+	}
+
+	return nil
+}
+
+// JournalFilter selects transactions. Empty fields match everything.
+type JournalFilter struct {
+	Account   string     `json:"account,omitempty"`
+	TicketID  string     `json:"ticket_id,omitempty"`
+	Channel   string     `json:"channel,omitempty"`
+	TimeRange *TimeRange `json:"timeRange,omitempty"`
+}
+
+// Match reports whether jt is selected by the filter. A nil filter matches
+// every transaction.
+func (f *JournalFilter) Match(jt *JournalTransaction) bool {
+
+	if f == nil {
+		return true
+	}
+
+	if len(f.TicketID) > 0 && jt.TicketID != f.TicketID {
+		return false
+	}
+
+	if len(f.Channel) > 0 && jt.Channel != f.Channel {
+		return false
+	}
+
+	if !f.TimeRange.Contains(jt.CreatedAt) {
+		return false
+	}
+
+	if len(f.Account) == 0 {
+		return true
+	}
+
+	for _, l := range jt.Lines {
+		if l.Account == f.Account {
+			return true
+		}
+	}
+
+	return false
+}
+
+// JournalStore keeps journal transactions, which can be fed to an external
+// general ledger system.
+type JournalStore interface {
+	WriteTransactions(txs []*JournalTransaction) error
+	ReadTransactions(filter *JournalFilter, cond *Condition) ([]*JournalTransaction, error)
+	Balance(account string) (int64, error)
+
+	// Context-aware variants
+	WriteTransactionsContext(ctx context.Context, txs []*JournalTransaction) error
+	ReadTransactionsContext(ctx context.Context, filter *JournalFilter, cond *Condition) ([]*JournalTransaction, error)
+	BalanceContext(ctx context.Context, account string) (int64, error)
+}
+
+// ExportJournalCSV writes every line of txs as a row of CSV with header.
+func ExportJournalCSV(w io.Writer, txs []*JournalTransaction) error {
+
+	cw := csv.NewWriter(w)
+
+	err := cw.Write([]string{
+		"transaction_id",
+		"ticket_id",
+		"channel",
+		"created_at",
+		"account",
+		"debit",
+		"credit",
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, jt := range txs {
+		for _, l := range jt.Lines {
+			err := cw.Write([]string{
+				jt.ID,
+				jt.TicketID,
+				jt.Channel,
+				jt.CreatedAt.UTC().Format(time.RFC3339Nano),
+				l.Account,
+				strconv.FormatInt(l.Debit, 10),
+				strconv.FormatInt(l.Credit, 10),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
@@ -0,0 +1,144 @@
+package bursary
+
+import (
+	"context"
+	"sync"
+)
+
+type journalStoreMemory struct {
+	mutex    sync.RWMutex
+	txs      []*JournalTransaction
+	balances map[string]int64
+}
+
+func NewJournalStoreMemory() JournalStore {
+	return &journalStoreMemory{
+		txs:      make([]*JournalTransaction, 0),
+		balances: make(map[string]int64),
+	}
+}
+
+func (js *journalStoreMemory) WriteTransactions(txs []*JournalTransaction) error {
+	return js.WriteTransactionsContext(context.Background(), txs)
+}
+
+func (js *journalStoreMemory) WriteTransactionsContext(ctx context.Context, txs []*JournalTransaction) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Unbalanced transactions are rejected before writing anything
+	for _, jt := range txs {
+		if err := jt.Validate(); err != nil {
+			return err
+		}
+	}
+
+	js.mutex.Lock()
+	defer js.mutex.Unlock()
+
+	for _, jt := range txs {
+
+		js.txs = append(js.txs, jt)
+
+		for _, l := range jt.Lines {
+			js.balances[l.Account] += l.Debit - l.Credit
+		}
+	}
+
+	return nil
+}
+
+func (js *journalStoreMemory) ReadTransactions(filter *JournalFilter, cond *Condition) ([]*JournalTransaction, error) {
+	return js.ReadTransactionsContext(context.Background(), filter, cond)
+}
+
+func (js *journalStoreMemory) ReadTransactionsContext(ctx context.Context, filter *JournalFilter, cond *Condition) ([]*JournalTransaction, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if cond == nil {
+		cond = NewCondition()
+	}
+
+	if cond.Page < 1 {
+		cond.Page = 1
+	}
+
+	if cond.Limit < 1 {
+		cond.Limit = 1
+	}
+
+	// Sequence of transactions is their position in the store
+	from := 0
+	skip := (cond.Page - 1) * cond.Limit
+	if len(cond.After) > 0 {
+
+		after, err := ParseCursor(cond.After, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if after.Seq < 0 {
+			return nil, ErrInvalidCursor
+		}
+
+		from = int(after.Seq)
+		skip = 0
+	}
+
+	js.mutex.RLock()
+	defer js.mutex.RUnlock()
+
+	cond.NextCursor = ""
+
+	txs := make([]*JournalTransaction, 0)
+
+	last := 0
+	for i := from; i < len(js.txs); i++ {
+
+		jt := js.txs[i]
+
+		if !filter.Match(jt) {
+			continue
+		}
+
+		if skip > 0 {
+			skip--
+			continue
+		}
+
+		// There are more transactions after the page
+		if len(txs) >= cond.Limit {
+			cond.NextCursor = (&Cursor{
+				Seq: int64(last + 1),
+			}).String()
+			break
+		}
+
+		txs = append(txs, jt)
+		last = i
+	}
+
+	return txs, nil
+}
+
+func (js *journalStoreMemory) Balance(account string) (int64, error) {
+	return js.BalanceContext(context.Background(), account)
+}
+
+// BalanceContext returns debits minus credits of account.
+func (js *journalStoreMemory) BalanceContext(ctx context.Context, account string) (int64, error) {
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	js.mutex.RLock()
+	defer js.mutex.RUnlock()
+
+	return js.balances[account], nil
+}
@@ -0,0 +1,303 @@
+package bursary
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NewJournalTransaction(t *testing.T) {
+
+	bu := NewBursary()
+	defer bu.Close()
+
+	r := &Rule{Commission: 0.5, Share: 0.5}
+	ids := addTestChain(t, bu.RelationManager(), "slot", r, r, r)
+
+	ticket := NewTicket()
+	ticket.Channel = "slot"
+	ticket.MemberID = ids[2]
+	ticket.Amount = 1000
+	ticket.Fee = 100
+
+	entries, err := bu.CalculateRewards(ticket)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	jt, err := NewJournalTransaction(ticket, entries, "")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, ticket.ID, jt.TicketID)
+	assert.Nil(t, jt.Validate())
+
+	balances := make(map[string]int64)
+	for _, l := range jt.Lines {
+		assert.True(t, l.Debit >= 0 && l.Credit >= 0)
+		balances[l.Account] += l.Debit - l.Credit
+	}
+
+	assert.Equal(t, int64(1100), balances[MemberReceivable(ids[2])])
+	assert.Equal(t, int64(0), balances[ChannelRevenue("slot")])
+	assert.Equal(t, int64(0), balances[ChannelFees("slot")])
+	assert.Equal(t, int64(0), balances[HouseAccount])
+
+	for _, le := range entries {
+		assert.Equal(t, -(le.Gain + le.Commissions), balances[MemberPayable(le.MemberID)])
+	}
+}
+
+func Test_NewJournalTransaction_Negative(t *testing.T) {
+
+	bu := NewBursary()
+	defer bu.Close()
+
+	r := &Rule{Commission: 0.5, Share: 0.5}
+	ids := addTestChain(t, bu.RelationManager(), "slot", r, r, r)
+
+	// Member won
+	ticket := NewTicket()
+	ticket.Channel = "slot"
+	ticket.MemberID = ids[2]
+	ticket.Amount = -1000
+	ticket.Fee = 100
+
+	entries, err := bu.CalculateRewards(ticket)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	jt, err := NewJournalTransaction(ticket, entries, "")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	for _, l := range jt.Lines {
+		assert.True(t, l.Debit >= 0 && l.Credit >= 0)
+	}
+
+	assert.Nil(t, jt.Validate())
+}
+
+func Test_JournalTransaction_Validate(t *testing.T) {
+
+	jt := &JournalTransaction{
+		Lines: []*JournalLine{
+			&JournalLine{Account: "a", Debit: 100},
+			&JournalLine{Account: "b", Credit: 90},
+		},
+	}
+
+	assert.Equal(t, ErrUnbalancedJournal, jt.Validate())
+
+	js := NewJournalStoreMemory()
+	assert.Equal(t, ErrUnbalancedJournal, js.WriteTransactions([]*JournalTransaction{jt}))
+}
+
+func Test_WriteTicket_Journal(t *testing.T) {
+
+	bu := NewBursary(
+		WithJournal(NewJournalStoreMemory()),
+	)
+	defer bu.Close()
+
+	r := &Rule{Commission: 0.5, Share: 0.5}
+	ids := addTestChain(t, bu.RelationManager(), "slot", r, r, r)
+
+	tickets := make([]*Ticket, 0)
+	for i := 0; i < 3; i++ {
+
+		ticket := NewTicket()
+		ticket.Channel = "slot"
+		ticket.MemberID = ids[2-i]
+		ticket.Amount = 1000
+		ticket.Fee = 100
+
+		err := bu.WriteTicket(ticket)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		tickets = append(tickets, ticket)
+	}
+
+	js := bu.Journal()
+
+	txs, err := js.ReadTransactions(nil, nil)
+	if assert.Nil(t, err) {
+		assert.Len(t, txs, 3)
+	}
+
+	txs, err = js.ReadTransactions(&JournalFilter{
+		TicketID: tickets[1].ID,
+	}, nil)
+	if assert.Nil(t, err) && assert.Len(t, txs, 1) {
+		assert.Equal(t, tickets[1].ID, txs[0].TicketID)
+	}
+
+	// The top-level agency is paid for every ticket
+	txs, err = js.ReadTransactions(&JournalFilter{
+		Account: MemberPayable(ids[0]),
+	}, nil)
+	if assert.Nil(t, err) {
+		assert.Len(t, txs, 3)
+	}
+
+	txs, err = js.ReadTransactions(&JournalFilter{
+		Account: MemberReceivable(ids[2]),
+	}, &Condition{
+		Page:  1,
+		Limit: 10,
+	})
+	if assert.Nil(t, err) {
+		assert.Len(t, txs, 1)
+	}
+
+	// Pages
+	txs, err = js.ReadTransactions(nil, &Condition{
+		Page:  2,
+		Limit: 2,
+	})
+	if assert.Nil(t, err) && assert.Len(t, txs, 1) {
+		assert.Equal(t, tickets[2].ID, txs[0].TicketID)
+	}
+
+	// Balances across tickets
+	balance, err := js.Balance(ChannelRevenue("slot"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), balance)
+
+	// Ticket of the top-level agency itself leaves the rest to house
+	total, err := js.Balance(HouseAccount)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-550), total)
+
+	for _, id := range ids {
+
+		balance, err := js.Balance(MemberReceivable(id))
+		assert.Nil(t, err)
+		total += balance
+
+		balance, err = js.Balance(MemberPayable(id))
+		assert.Nil(t, err)
+		total += balance
+	}
+
+	assert.Equal(t, int64(0), total)
+
+	// Export
+	all, err := js.ReadTransactions(nil, nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	var buf bytes.Buffer
+	err = ExportJournalCSV(&buf, all)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if assert.Nil(t, err) {
+
+		lines := 0
+		for _, jt := range all {
+			lines += len(jt.Lines)
+		}
+
+		assert.Len(t, rows, lines+1)
+		assert.Equal(t, "account", rows[0][4])
+	}
+}
+
+func Test_JournalStore_Cursor(t *testing.T) {
+
+	js := NewJournalStoreMemory()
+
+	ids := make([]string, 0)
+	for i := 0; i < 5; i++ {
+
+		ticket := NewTicket()
+		ticket.Channel = "slot"
+		ticket.MemberID = genTestID()
+		ticket.Amount = 1000
+
+		jt, err := NewJournalTransaction(ticket, nil, "")
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		assert.Nil(t, js.WriteTransactions([]*JournalTransaction{jt}))
+		ids = append(ids, jt.ID)
+	}
+
+	cond := &Condition{Limit: 2}
+
+	read := make([]string, 0)
+	for {
+		txs, err := js.ReadTransactions(nil, cond)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		for _, jt := range txs {
+			read = append(read, jt.ID)
+		}
+
+		if len(cond.NextCursor) == 0 {
+			break
+		}
+
+		cond = &Condition{
+			Limit: 2,
+			After: cond.NextCursor,
+		}
+	}
+
+	assert.Equal(t, ids, read)
+
+	_, err := js.ReadTransactions(nil, &Condition{Limit: 2, After: "invalid"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func Test_WriteTicket_HouseJournal(t *testing.T) {
+
+	bu := NewBursary(
+		WithHouseAccount("operator"),
+		WithJournal(NewJournalStoreMemory()),
+	)
+	defer bu.Close()
+
+	ids := addTestChain(t, bu.RelationManager(), "default", newLimitTestRules()...)
+
+	ticket := newLimitTestTicket(ids[2])
+	assert.Nil(t, bu.WriteTicket(ticket))
+
+	// House entry is posted to house account rather than a member payable
+	js := bu.Journal()
+
+	balance, err := js.Balance(MemberPayable("operator"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), balance)
+
+	balance, err = js.Balance(HouseAccount)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-210), balance)
+
+	// Info of ticket can't tell which entry is of house
+	ticket = newLimitTestTicket(ids[2])
+	ticket.Info = map[string]interface{}{"house": true}
+	assert.Nil(t, bu.WriteTicket(ticket))
+
+	balance, err = js.Balance(MemberPayable(ids[2]))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-700), balance)
+
+	balance, err = js.Balance(HouseAccount)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-420), balance)
+}
@@ -1,6 +1,16 @@
 package bursary
 
-import "time"
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrStopScan can be returned by the function passed to ScanRecords to
+	// stop scanning early without an error.
+	ErrStopScan = errors.New("bursary: stop scan")
+)
 
 type LedgerEntry struct {
 	ID              string                 `json:"id"`
@@ -18,15 +28,65 @@ type LedgerEntry struct {
 	Gain            int64                  `json:"gain"`        // amount * (share + returned share by downstream)
 	Commissions     int64                  `json:"commissions"` // fee * commission share
 	Contributions   int64                  `json:"contributions"`
-	Total           int64                  `json:"total"` // profit + commissions
+	Fixed           int64                  `json:"fixed,omitempty"` // fixed amounts and bonuses of rule
+	Total           int64                  `json:"total"`           // profit + commissions + fixed
+	Currency        string                 `json:"currency,omitempty"`
 	Desc            string                 `json:"desc"`
 	Info            map[string]interface{} `json:"info"`
 	PrimaryID       string                 `json:"primary_id"`
 	IsPrimary       bool                   `json:"is_primary"`
 	CreatedAt       time.Time              `json:"created_at"`
+
+	// Chaining fields set by HashChainLedger
+	Sequence int64  `json:"sequence,omitempty"`
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// Earnings returns what the member of entry earns from it, which is negative
+// for payouts.
+func (le *LedgerEntry) Earnings() int64 {
+	return le.Gain + le.Commissions + le.Fixed
+}
+
+// RecordFilter selects entries to be scanned. Empty fields match every entry.
+type RecordFilter struct {
+	MemberID  string     `json:"member_id,omitempty"`
+	Channel   string     `json:"channel,omitempty"`
+	TimeRange *TimeRange `json:"timeRange,omitempty"`
+}
+
+// Match reports whether le is selected by the filter. A nil filter matches
+// every entry.
+func (f *RecordFilter) Match(le *LedgerEntry) bool {
+
+	if f == nil {
+		return true
+	}
+
+	if len(f.MemberID) > 0 && le.MemberID != f.MemberID {
+		return false
+	}
+
+	if len(f.Channel) > 0 && le.Channel != f.Channel {
+		return false
+	}
+
+	return f.TimeRange.Contains(le.CreatedAt)
 }
 
 type Ledger interface {
 	WriteRecords(entries []*LedgerEntry) error
 	ReadRecordsByMemberID(memberID string, cond *Condition) ([]*LedgerEntry, error)
+
+	// ScanRecords calls fn for every entry selected by filter in order of
+	// writing, without loading all of them at once. Returning ErrStopScan
+	// from fn stops scanning and ScanRecords returns nil, while any other
+	// error is returned as is.
+	ScanRecords(filter *RecordFilter, fn func(le *LedgerEntry) error) error
+
+	// Context-aware variants
+	WriteRecordsContext(ctx context.Context, entries []*LedgerEntry) error
+	ReadRecordsByMemberIDContext(ctx context.Context, memberID string, cond *Condition) ([]*LedgerEntry, error)
+	ScanRecordsContext(ctx context.Context, filter *RecordFilter, fn func(le *LedgerEntry) error) error
 }
@@ -0,0 +1,88 @@
+package ledger_sqlite
+
+import (
+	"time"
+
+	"github.com/weedbox/bursary/v2"
+)
+
+func NewEntryRecord(le *bursary.LedgerEntry) *EntryRecord {
+	return &EntryRecord{
+		ID:              le.ID,
+		Channel:         le.Channel,
+		Upstream:        le.Upstream,
+		MemberID:        le.MemberID,
+		Contributor:     le.Contributor,
+		Expense:         le.Expense,
+		Income:          le.Income,
+		Amount:          le.Amount,
+		Fee:             le.Fee,
+		Share:           le.Share,
+		ReturnedShare:   le.ReturnedShare,
+		CommissionShare: le.CommissionShare,
+		Gain:            le.Gain,
+		Commissions:     le.Commissions,
+		Contributions:   le.Contributions,
+		Fixed:           le.Fixed,
+		Total:           le.Total,
+		Currency:        le.Currency,
+		Desc:            le.Desc,
+		Info:            Info(le.Info),
+		PrimaryID:       le.PrimaryID,
+		IsPrimary:       le.IsPrimary,
+		CreatedAt:       unixNano(le.CreatedAt),
+		Sequence:        le.Sequence,
+		PrevHash:        le.PrevHash,
+		Hash:            le.Hash,
+	}
+}
+
+func (er *EntryRecord) ToLedgerEntry() *bursary.LedgerEntry {
+	return &bursary.LedgerEntry{
+		ID:              er.ID,
+		Channel:         er.Channel,
+		Upstream:        er.Upstream,
+		MemberID:        er.MemberID,
+		Contributor:     er.Contributor,
+		Expense:         er.Expense,
+		Income:          er.Income,
+		Amount:          er.Amount,
+		Fee:             er.Fee,
+		Share:           er.Share,
+		ReturnedShare:   er.ReturnedShare,
+		CommissionShare: er.CommissionShare,
+		Gain:            er.Gain,
+		Commissions:     er.Commissions,
+		Contributions:   er.Contributions,
+		Fixed:           er.Fixed,
+		Total:           er.Total,
+		Currency:        er.Currency,
+		Desc:            er.Desc,
+		Info:            map[string]interface{}(er.Info),
+		PrimaryID:       er.PrimaryID,
+		IsPrimary:       er.IsPrimary,
+		CreatedAt:       fromUnixNano(er.CreatedAt),
+		Sequence:        er.Sequence,
+		PrevHash:        er.PrevHash,
+		Hash:            er.Hash,
+	}
+}
+
+// unixNano keeps zero time as 0, which cannot be represented in nanoseconds.
+func unixNano(t time.Time) int64 {
+
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano()
+}
+
+func fromUnixNano(ns int64) time.Time {
+
+	if ns == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, ns).UTC()
+}
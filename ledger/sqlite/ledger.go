@@ -0,0 +1,549 @@
+package ledger_sqlite
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/weedbox/bursary/v2"
+)
+
+type Opt func(*LedgerSQLite)
+
+type LedgerSQLite struct {
+	db        *sqlx.DB
+	tx        *sqlx.Tx
+	tableName string
+}
+
+func NewLedgerSQLite(opts ...Opt) *LedgerSQLite {
+	l := &LedgerSQLite{}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	if len(l.tableName) == 0 {
+		l.tableName = "ledger"
+	}
+
+	return l
+}
+
+func WithDb(db *sqlx.DB) Opt {
+	return func(l *LedgerSQLite) {
+		l.db = db
+	}
+}
+
+func WithTableName(tableName string) Opt {
+	return func(l *LedgerSQLite) {
+		l.tableName = tableName
+	}
+}
+
+// WithTx returns a copy of the ledger bound to an existing transaction. Every
+// operation of the copy runs inside tx, and committing or rolling back is left
+// to the caller.
+func (l *LedgerSQLite) WithTx(tx *sqlx.Tx) *LedgerSQLite {
+	c := *l
+	c.tx = tx
+	return &c
+}
+
+func (l *LedgerSQLite) ext() sqlx.ExtContext {
+
+	if l.tx != nil {
+		return l.tx
+	}
+
+	return l.db
+}
+
+func (l *LedgerSQLite) Init() error {
+
+	// Initializing table
+	stmts := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (
+			"seq" INTEGER PRIMARY KEY AUTOINCREMENT,
+			"id" TEXT NOT NULL,
+			"channel" TEXT NOT NULL,
+			"upstream" TEXT NOT NULL,
+			"member_id" TEXT NOT NULL,
+			"contributor" TEXT NOT NULL,
+			"expense" INTEGER NOT NULL,
+			"income" INTEGER NOT NULL,
+			"amount" INTEGER NOT NULL,
+			"fee" INTEGER NOT NULL,
+			"share" REAL NOT NULL,
+			"returned_share" REAL NOT NULL,
+			"commission_share" REAL NOT NULL,
+			"gain" INTEGER NOT NULL,
+			"commissions" INTEGER NOT NULL,
+			"contributions" INTEGER NOT NULL,
+			"total" INTEGER NOT NULL,
+			"desc" TEXT NOT NULL,
+			"info" TEXT,
+			"primary_id" TEXT NOT NULL,
+			"is_primary" BOOLEAN NOT NULL,
+			"created_at" INTEGER NOT NULL,
+			"sequence" INTEGER NOT NULL DEFAULT 0,
+			"prev_hash" TEXT NOT NULL DEFAULT '',
+			"hash" TEXT NOT NULL DEFAULT '',
+			"currency" TEXT NOT NULL DEFAULT '',
+			"fixed" INTEGER NOT NULL DEFAULT 0
+		)`, l.tableName),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s_member_id_idx" ON "%s" ("member_id", "seq")`, l.tableName, l.tableName),
+	}
+
+	tx, err := l.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	for _, q := range stmts {
+		_, err := tx.Exec(q)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	err = l.addColumns(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// addColumns upgrades tables created by earlier versions.
+func (l *LedgerSQLite) addColumns(tx *sqlx.Tx) error {
+
+	columns := []struct {
+		name string
+		def  string
+	}{
+		{"sequence", `INTEGER NOT NULL DEFAULT 0`},
+		{"prev_hash", `TEXT NOT NULL DEFAULT ''`},
+		{"hash", `TEXT NOT NULL DEFAULT ''`},
+		{"currency", `TEXT NOT NULL DEFAULT ''`},
+		{"fixed", `INTEGER NOT NULL DEFAULT 0`},
+	}
+
+	existing := make([]string, 0)
+	err := tx.Select(&existing, fmt.Sprintf(`SELECT name FROM pragma_table_info('%s')`, l.tableName))
+	if err != nil {
+		return err
+	}
+
+	found := make(map[string]bool)
+	for _, name := range existing {
+		found[name] = true
+	}
+
+	for _, c := range columns {
+
+		if found[c.name] {
+			continue
+		}
+
+		_, err := tx.Exec(fmt.Sprintf(`ALTER TABLE "%s" ADD COLUMN "%s" %s`, l.tableName, c.name, c.def))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *LedgerSQLite) Close() error {
+
+	// Connection is owned by whoever created the transaction
+	if l.tx != nil {
+		return nil
+	}
+
+	return l.db.Close()
+}
+
+func (l *LedgerSQLite) WriteRecords(entries []*bursary.LedgerEntry) error {
+	return l.WriteRecordsContext(context.Background(), entries)
+}
+
+func (l *LedgerSQLite) WriteRecordsContext(ctx context.Context, entries []*bursary.LedgerEntry) error {
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	if l.tx != nil {
+		return l.writeRecords(ctx, l.tx, entries)
+	}
+
+	// All entries are written or none of them
+	tx, err := l.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = l.writeRecords(ctx, tx, entries)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// LockContext runs fn with a copy of the ledger bound to a transaction which
+// holds the write lock of the database, so no other connection or process can
+// write until fn returns. The transaction is committed if fn succeeds, and
+// rolled back otherwise.
+func (l *LedgerSQLite) LockContext(ctx context.Context, fn func(ctx context.Context, l bursary.Ledger) error) error {
+
+	// Transaction is owned by caller
+	if l.tx != nil {
+
+		err := l.lock(ctx, l.tx)
+		if err != nil {
+			return err
+		}
+
+		return fn(ctx, l)
+	}
+
+	tx, err := l.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = l.lock(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = fn(ctx, l.WithTx(tx))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lock takes the write lock of the database in tx before anything is read,
+// as a transaction upgrading its read lock fails instead of waiting for
+// others to finish.
+func (l *LedgerSQLite) lock(ctx context.Context, tx *sqlx.Tx) error {
+
+	sqlStr := fmt.Sprintf(`DELETE FROM "%s" WHERE 0`, l.tableName)
+
+	_, err := tx.ExecContext(ctx, sqlStr)
+
+	return err
+}
+
+type stagedWrite struct {
+	tx *sqlx.Tx
+}
+
+func (sw *stagedWrite) Commit() error {
+
+	// Joined a transaction owned by another ledger
+	if sw.tx == nil {
+		return nil
+	}
+
+	return sw.tx.Commit()
+}
+
+func (sw *stagedWrite) Rollback() error {
+
+	if sw.tx == nil {
+		return nil
+	}
+
+	return sw.tx.Rollback()
+}
+
+// StageRecords writes entries in a transaction which is committed by Commit
+// of the returned write. Ledgers sharing a database join the same
+// transaction while being staged for the same write.
+func (l *LedgerSQLite) StageRecords(ctx context.Context, entries []*bursary.LedgerEntry) (bursary.StagedWrite, error) {
+
+	// Transaction is owned by caller
+	if l.tx != nil {
+		return &stagedWrite{}, l.writeRecords(ctx, l.tx, entries)
+	}
+
+	scope := bursary.StageScopeFromContext(ctx)
+	if scope != nil {
+
+		// Another ledger of the same database owns the transaction, and
+		// beginning another one would wait for it forever
+		if actual, ok := scope.Load(l.db); ok {
+
+			err := l.writeRecords(ctx, actual.(*sqlx.Tx), entries)
+			if err != nil {
+				return nil, err
+			}
+
+			return &stagedWrite{}, nil
+		}
+	}
+
+	tx, err := l.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	sw := &stagedWrite{
+		tx: tx,
+	}
+
+	if scope != nil {
+
+		actual, loaded := scope.LoadOrStore(l.db, tx)
+		if loaded {
+
+			// Another ledger of the same database began one meanwhile
+			tx.Rollback()
+			tx = actual.(*sqlx.Tx)
+			sw.tx = nil
+		}
+	}
+
+	err = l.writeRecords(ctx, tx, entries)
+	if err != nil {
+		sw.Rollback()
+		return nil, err
+	}
+
+	return sw, nil
+}
+
+func (l *LedgerSQLite) writeRecords(ctx context.Context, tx *sqlx.Tx, entries []*bursary.LedgerEntry) error {
+
+	cmd := fmt.Sprintf(`INSERT INTO "%s" (
+			id,
+			channel,
+			upstream,
+			member_id,
+			contributor,
+			expense,
+			income,
+			amount,
+			fee,
+			share,
+			returned_share,
+			commission_share,
+			gain,
+			commissions,
+			contributions,
+			total,
+			"desc",
+			info,
+			primary_id,
+			is_primary,
+			created_at,
+			sequence,
+			prev_hash,
+			hash,
+			currency,
+			fixed
+		) VALUES (
+			:id,
+			:channel,
+			:upstream,
+			:member_id,
+			:contributor,
+			:expense,
+			:income,
+			:amount,
+			:fee,
+			:share,
+			:returned_share,
+			:commission_share,
+			:gain,
+			:commissions,
+			:contributions,
+			:total,
+			:desc,
+			:info,
+			:primary_id,
+			:is_primary,
+			:created_at,
+			:sequence,
+			:prev_hash,
+			:hash,
+			:currency,
+			:fixed
+		)`, l.tableName)
+
+	for _, le := range entries {
+		_, err := sqlx.NamedExecContext(ctx, tx, cmd, NewEntryRecord(le))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *LedgerSQLite) ReadRecordsByMemberID(memberID string, cond *bursary.Condition) ([]*bursary.LedgerEntry, error) {
+	return l.ReadRecordsByMemberIDContext(context.Background(), memberID, cond)
+}
+
+func (l *LedgerSQLite) ReadRecordsByMemberIDContext(ctx context.Context, memberID string, cond *bursary.Condition) ([]*bursary.LedgerEntry, error) {
+
+	if cond == nil {
+		cond = bursary.NewCondition()
+	}
+
+	if cond.Page < 1 {
+		cond.Page = 1
+	}
+
+	if cond.Limit < 1 {
+		cond.Limit = 1
+	}
+
+	offset := (cond.Page - 1) * cond.Limit
+
+	// Preparing conditions
+	where := `member_id = ?`
+	args := []interface{}{
+		memberID,
+	}
+
+	// Cursor holds sequence of the last record
+	if len(cond.After) > 0 {
+
+		after, err := bursary.ParseCursor(cond.After, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		where += ` AND seq > ?`
+		args = append(args, after.Seq)
+		offset = 0
+	}
+
+	if cond.TimeRange != nil {
+
+		if !cond.TimeRange.StartTime.IsZero() {
+			where += ` AND created_at >= ?`
+			args = append(args, cond.TimeRange.StartTime.UnixNano())
+		}
+
+		if !cond.TimeRange.EndTime.IsZero() {
+			where += ` AND created_at <= ?`
+			args = append(args, cond.TimeRange.EndTime.UnixNano())
+		}
+	}
+
+	// One more record tells whether there is next page
+	args = append(args, cond.Limit+1, offset)
+
+	cmd := fmt.Sprintf(`SELECT * FROM "%s" WHERE %s ORDER BY seq LIMIT ? OFFSET ?`, l.tableName, where)
+
+	entries := make([]*bursary.LedgerEntry, 0)
+
+	rows, err := l.ext().QueryxContext(ctx, cmd, args...)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	cond.NextCursor = ""
+
+	last := int64(0)
+	for rows.Next() {
+		record := &EntryRecord{}
+		err := rows.StructScan(record)
+		if err != nil {
+			return entries, err
+		}
+
+		if len(entries) == cond.Limit {
+			cond.NextCursor = (&bursary.Cursor{
+				Seq: last,
+			}).String()
+			break
+		}
+
+		entries = append(entries, record.ToLedgerEntry())
+		last = record.Seq
+	}
+
+	return entries, rows.Err()
+}
+
+func (l *LedgerSQLite) ScanRecords(filter *bursary.RecordFilter, fn func(le *bursary.LedgerEntry) error) error {
+	return l.ScanRecordsContext(context.Background(), filter, fn)
+}
+
+func (l *LedgerSQLite) ScanRecordsContext(ctx context.Context, filter *bursary.RecordFilter, fn func(le *bursary.LedgerEntry) error) error {
+
+	if filter == nil {
+		filter = &bursary.RecordFilter{}
+	}
+
+	// Preparing conditions
+	where := `1 = 1`
+	args := make([]interface{}, 0)
+
+	if len(filter.MemberID) > 0 {
+		where += ` AND member_id = ?`
+		args = append(args, filter.MemberID)
+	}
+
+	if len(filter.Channel) > 0 {
+		where += ` AND channel = ?`
+		args = append(args, filter.Channel)
+	}
+
+	if filter.TimeRange != nil {
+
+		if !filter.TimeRange.StartTime.IsZero() {
+			where += ` AND created_at >= ?`
+			args = append(args, filter.TimeRange.StartTime.UnixNano())
+		}
+
+		if !filter.TimeRange.EndTime.IsZero() {
+			where += ` AND created_at <= ?`
+			args = append(args, filter.TimeRange.EndTime.UnixNano())
+		}
+	}
+
+	cmd := fmt.Sprintf(`SELECT * FROM "%s" WHERE %s ORDER BY seq`, l.tableName, where)
+
+	// Rows are read one by one instead of being loaded at once
+	rows, err := l.ext().QueryxContext(ctx, cmd, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record := &EntryRecord{}
+		err := rows.StructScan(record)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(record.ToLedgerEntry()); err != nil {
+
+			if err == bursary.ErrStopScan {
+				return nil
+			}
+
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,430 @@
+package ledger_sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/weedbox/bursary/v2"
+	"github.com/weedbox/bursary/v2/bursarytest"
+
+	_ "modernc.org/sqlite"
+)
+
+func newTestLedger(t *testing.T) *LedgerSQLite {
+
+	db, err := sqlx.Open("sqlite", filepath.Join(t.TempDir(), "ledger.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewLedgerSQLite(
+		WithDb(db),
+	)
+
+	err = l.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		l.Close()
+	})
+
+	return l
+}
+
+func Test_LedgerSQLite_Conformance(t *testing.T) {
+	bursarytest.RunLedgerSuite(t, func(t *testing.T) bursary.Ledger {
+		return newTestLedger(t)
+	})
+}
+
+func Test_LedgerSQLite_MultipleTables(t *testing.T) {
+
+	l := newTestLedger(t)
+
+	// Another ledger in the same database
+	other := NewLedgerSQLite(
+		WithDb(l.db),
+		WithTableName("other"),
+	)
+
+	err := other.Init()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	le := &bursary.LedgerEntry{
+		ID:       "test",
+		MemberID: "member",
+	}
+
+	err = other.WriteRecords([]*bursary.LedgerEntry{
+		le,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	records, err := l.ReadRecordsByMemberID(le.MemberID, nil)
+	if assert.Nil(t, err) {
+		assert.Len(t, records, 0)
+	}
+
+	records, err = other.ReadRecordsByMemberID(le.MemberID, nil)
+	if assert.Nil(t, err) {
+		assert.Len(t, records, 1)
+	}
+}
+
+var errTestLedger = errors.New("test ledger")
+
+// failingLedger rejects every write.
+type failingLedger struct {
+	bursary.Ledger
+}
+
+func (l *failingLedger) WriteRecordsContext(ctx context.Context, entries []*bursary.LedgerEntry) error {
+	return errTestLedger
+}
+
+func Test_LedgerSQLite_Routes(t *testing.T) {
+
+	l := newTestLedger(t)
+
+	// Ledger per channel in the same database
+	slot := NewLedgerSQLite(
+		WithDb(l.db),
+		WithTableName("slot"),
+	)
+
+	err := slot.Init()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	bu := bursary.NewBursary(
+		bursary.WithGeneralLedger(l),
+		bursary.WithLedgerRoutes(
+			bursary.RouteToGeneral(),
+			bursary.RouteByChannel(),
+		),
+	)
+
+	assert.Nil(t, bu.LedgerManager().Add("slot", slot))
+
+	agency := bursary.NewMemberEntry()
+	err = bu.RelationManager().AddMembers([]*bursary.MemberEntry{
+		agency,
+	}, "")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	ticket := bursary.NewTicket()
+	ticket.Channel = "slot"
+	ticket.MemberID = agency.ID
+	ticket.Amount = 100
+
+	// Both tables are written in a single transaction
+	err = bu.WriteTicket(ticket)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	for _, ledger := range []bursary.Ledger{l, slot} {
+		records, err := ledger.ReadRecordsByMemberID(agency.ID, nil)
+		if assert.Nil(t, err) {
+			assert.Len(t, records, 1)
+		}
+	}
+
+	// Failure of another ledger rolls back both tables
+	assert.Nil(t, bu.LedgerManager().Add("failing", &failingLedger{}))
+
+	bu = bursary.NewBursary(
+		bursary.WithRelationManager(bu.RelationManager()),
+		bursary.WithLedgerManager(bu.LedgerManager()),
+		bursary.WithGeneralLedger(l),
+		bursary.WithLedgerRoutes(
+			bursary.RouteToGeneral(),
+			bursary.RouteByChannel(),
+			bursary.RouteTo("failing"),
+		),
+	)
+
+	ticket = bursary.NewTicket()
+	ticket.Channel = "slot"
+	ticket.MemberID = agency.ID
+
+	err = bu.WriteTicket(ticket)
+	assert.Equal(t, errTestLedger, err)
+
+	for _, ledger := range []bursary.Ledger{l, slot} {
+		records, err := ledger.ReadRecordsByMemberID(agency.ID, nil)
+		if assert.Nil(t, err) {
+			assert.Len(t, records, 1)
+		}
+	}
+}
+
+func Test_LedgerSQLite_HashChain(t *testing.T) {
+
+	l := newTestLedger(t)
+	hl := bursary.NewHashChainLedger(l)
+
+	for i := 0; i < 5; i++ {
+		err := hl.WriteRecords([]*bursary.LedgerEntry{
+			&bursary.LedgerEntry{
+				ID:        fmt.Sprintf("entry_%d", i),
+				MemberID:  "member",
+				Amount:    int64(i),
+				Share:     0.3,
+				Info:      map[string]interface{}{"round": i},
+				CreatedAt: time.Now(),
+			},
+		})
+		if !assert.Nil(t, err) {
+			return
+		}
+	}
+
+	// Hashes survive round trips through database
+	assert.Nil(t, hl.Verify(0, 0))
+
+	// Chain continues after restart
+	reopened := bursary.NewHashChainLedger(l)
+	err := reopened.WriteRecords([]*bursary.LedgerEntry{
+		&bursary.LedgerEntry{
+			ID:       "entry_5",
+			MemberID: "member",
+		},
+	})
+	if assert.Nil(t, err) {
+		assert.Nil(t, reopened.Verify(0, 0))
+	}
+
+	// Modified by SQL
+	_, err = l.db.Exec(`UPDATE "ledger" SET amount = 100 WHERE id = 'entry_2'`)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	err = hl.Verify(0, 0)
+	assert.ErrorIs(t, err, bursary.ErrLedgerTampered)
+}
+
+func Test_LedgerSQLite_Upgrade(t *testing.T) {
+
+	db, err := sqlx.Open("sqlite", filepath.Join(t.TempDir(), "ledger.db"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer db.Close()
+
+	// Table created by earlier versions
+	_, err = db.Exec(`CREATE TABLE "ledger" (
+		"seq" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"id" TEXT NOT NULL,
+		"channel" TEXT NOT NULL,
+		"upstream" TEXT NOT NULL,
+		"member_id" TEXT NOT NULL,
+		"contributor" TEXT NOT NULL,
+		"expense" INTEGER NOT NULL,
+		"income" INTEGER NOT NULL,
+		"amount" INTEGER NOT NULL,
+		"fee" INTEGER NOT NULL,
+		"share" REAL NOT NULL,
+		"returned_share" REAL NOT NULL,
+		"commission_share" REAL NOT NULL,
+		"gain" INTEGER NOT NULL,
+		"commissions" INTEGER NOT NULL,
+		"contributions" INTEGER NOT NULL,
+		"total" INTEGER NOT NULL,
+		"desc" TEXT NOT NULL,
+		"info" TEXT,
+		"primary_id" TEXT NOT NULL,
+		"is_primary" BOOLEAN NOT NULL,
+		"created_at" INTEGER NOT NULL
+	)`)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	l := NewLedgerSQLite(
+		WithDb(db),
+	)
+
+	// Twice to make sure that it can be run again
+	assert.Nil(t, l.Init())
+	assert.Nil(t, l.Init())
+
+	err = l.WriteRecords([]*bursary.LedgerEntry{
+		&bursary.LedgerEntry{
+			ID:       "test",
+			MemberID: "member",
+			Sequence: 1,
+			Hash:     "hash",
+		},
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	records, err := l.ReadRecordsByMemberID("member", nil)
+	if assert.Nil(t, err) && assert.Len(t, records, 1) {
+		assert.Equal(t, int64(1), records[0].Sequence)
+		assert.Equal(t, "hash", records[0].Hash)
+	}
+}
+
+func Test_LedgerSQLite_RoutesSingleConnection(t *testing.T) {
+
+	l := newTestLedger(t)
+	l.db.SetMaxOpenConns(1)
+
+	slot := NewLedgerSQLite(
+		WithDb(l.db),
+		WithTableName("slot"),
+	)
+
+	err := slot.Init()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	bu := bursary.NewBursary(
+		bursary.WithGeneralLedger(l),
+		bursary.WithLedgerRoutes(
+			bursary.RouteToGeneral(),
+			bursary.RouteByChannel(),
+		),
+	)
+
+	assert.Nil(t, bu.LedgerManager().Add("slot", slot))
+
+	agency := bursary.NewMemberEntry()
+	err = bu.RelationManager().AddMembers([]*bursary.MemberEntry{
+		agency,
+	}, "")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	ticket := bursary.NewTicket()
+	ticket.Channel = "slot"
+	ticket.MemberID = agency.ID
+	ticket.Amount = 100
+
+	// Second ledger joins the transaction instead of waiting for a connection
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = bu.WriteTicketContext(ctx, ticket)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	for _, ledger := range []bursary.Ledger{l, slot} {
+		records, err := ledger.ReadRecordsByMemberID(agency.ID, nil)
+		if assert.Nil(t, err) {
+			assert.Len(t, records, 1)
+		}
+	}
+}
+
+func Test_LedgerSQLite_BalanceLocked(t *testing.T) {
+
+	dsn := filepath.Join(t.TempDir(), "ledger.db") + "?_pragma=busy_timeout(5000)"
+
+	// Ledgers of separate processes sharing a database
+	bls := make([]*bursary.BalanceLedger, 2)
+	for i := range bls {
+
+		db, err := sqlx.Open("sqlite", dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		l := NewLedgerSQLite(
+			WithDb(db),
+		)
+
+		err = l.Init()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			l.Close()
+		})
+
+		bls[i] = bursary.NewBalanceLedger(l)
+	}
+
+	err := bls[0].WriteRecords([]*bursary.LedgerEntry{
+		{
+			ID:        "earnings",
+			Channel:   "default",
+			MemberID:  "a",
+			Gain:      1000,
+			Total:     1000,
+			Currency:  "USD",
+			CreatedAt: time.Now(),
+		},
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	accepted := 0
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(bl *bursary.BalanceLedger) {
+			defer wg.Done()
+
+			_, err := bl.RequestPayout("a", "USD", 30)
+			if err == nil {
+				mutex.Lock()
+				accepted++
+				mutex.Unlock()
+				return
+			}
+
+			assert.ErrorIs(t, err, bursary.ErrInsufficientBalance)
+		}(bls[i%2])
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, 33, accepted)
+
+	// Balance rebuilt from storage holds every accepted payout
+	bl := bursary.NewBalanceLedger(bls[0].Ledger)
+
+	b, err := bl.GetBalance("a", "USD")
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(990), b.Held)
+		assert.Equal(t, int64(10), b.Available())
+	}
+
+	payouts, err := bl.ListPayouts("a")
+	if !assert.Nil(t, err) || !assert.Len(t, payouts, 33) {
+		return
+	}
+
+	// Payout requested by another process can be approved
+	for _, p := range payouts {
+		_, err = bls[0].ApprovePayout(p.ID)
+		assert.Nil(t, err)
+	}
+}
@@ -0,0 +1,75 @@
+package ledger_sqlite
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+)
+
+type Info map[string]interface{}
+
+func (i Info) Value() (driver.Value, error) {
+
+	data, err := json.Marshal(i)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+func (i *Info) Scan(src interface{}) error {
+
+	var source []byte
+	switch v := src.(type) {
+	case []byte:
+		source = v
+	case string:
+		source = []byte(v)
+	case nil:
+		*i = nil
+		return nil
+	default:
+		return errors.New("Type assertion .([]byte) failed.")
+	}
+
+	var info Info
+	err := json.Unmarshal(source, &info)
+	if err != nil {
+		return err
+	}
+
+	*i = info
+
+	return nil
+}
+
+type EntryRecord struct {
+	Seq             int64   `db:"seq"`
+	ID              string  `db:"id"`
+	Channel         string  `db:"channel"`
+	Upstream        string  `db:"upstream"`
+	MemberID        string  `db:"member_id"`
+	Contributor     string  `db:"contributor"`
+	Expense         int64   `db:"expense"`
+	Income          int64   `db:"income"`
+	Amount          int64   `db:"amount"`
+	Fee             int64   `db:"fee"`
+	Share           float64 `db:"share"`
+	ReturnedShare   float64 `db:"returned_share"`
+	CommissionShare float64 `db:"commission_share"`
+	Gain            int64   `db:"gain"`
+	Commissions     int64   `db:"commissions"`
+	Contributions   int64   `db:"contributions"`
+	Fixed           int64   `db:"fixed"`
+	Total           int64   `db:"total"`
+	Currency        string  `db:"currency"`
+	Desc            string  `db:"desc"`
+	Info            Info    `db:"info"`
+	PrimaryID       string  `db:"primary_id"`
+	IsPrimary       bool    `db:"is_primary"`
+	CreatedAt       int64   `db:"created_at"`
+	Sequence        int64   `db:"sequence"`
+	PrevHash        string  `db:"prev_hash"`
+	Hash            string  `db:"hash"`
+}
@@ -1,18 +1,34 @@
 package bursary
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
 
 var (
 	ErrLedgerNotFound = errors.New("bursary: ledger not found")
+	ErrLedgerExists   = errors.New("bursary: ledger exists already")
 )
 
 type LedgerManager interface {
 	Add(name string, l Ledger) error
+	Replace(name string, l Ledger) error
 	Get(name string) (Ledger, error)
 	Delete(name string) error
+	List() ([]string, error)
+
+	// Context-aware variants
+	AddContext(ctx context.Context, name string, l Ledger) error
+	ReplaceContext(ctx context.Context, name string, l Ledger) error
+	GetContext(ctx context.Context, name string) (Ledger, error)
+	DeleteContext(ctx context.Context, name string) error
+	ListContext(ctx context.Context) ([]string, error)
 }
 
 type ledgerManager struct {
+	mutex   sync.RWMutex
 	ledgers map[string]Ledger
 }
 
@@ -23,11 +39,56 @@ func NewLedgerManager() LedgerManager {
 }
 
 func (lm *ledgerManager) Add(name string, l Ledger) error {
+	return lm.AddContext(context.Background(), name, l)
+}
+
+func (lm *ledgerManager) AddContext(ctx context.Context, name string, l Ledger) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+
+	// Use Replace to overwrite existing ledger
+	if _, ok := lm.ledgers[name]; ok {
+		return ErrLedgerExists
+	}
+
+	lm.ledgers[name] = l
+	return nil
+}
+
+func (lm *ledgerManager) Replace(name string, l Ledger) error {
+	return lm.ReplaceContext(context.Background(), name, l)
+}
+
+func (lm *ledgerManager) ReplaceContext(ctx context.Context, name string, l Ledger) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+
 	lm.ledgers[name] = l
 	return nil
 }
 
 func (lm *ledgerManager) Get(name string) (Ledger, error) {
+	return lm.GetContext(context.Background(), name)
+}
+
+func (lm *ledgerManager) GetContext(ctx context.Context, name string) (Ledger, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	lm.mutex.RLock()
+	defer lm.mutex.RUnlock()
 
 	if l, ok := lm.ledgers[name]; ok {
 		return l, nil
@@ -37,6 +98,42 @@ func (lm *ledgerManager) Get(name string) (Ledger, error) {
 }
 
 func (lm *ledgerManager) Delete(name string) error {
+	return lm.DeleteContext(context.Background(), name)
+}
+
+func (lm *ledgerManager) DeleteContext(ctx context.Context, name string) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+
 	delete(lm.ledgers, name)
 	return nil
 }
+
+func (lm *ledgerManager) List() ([]string, error) {
+	return lm.ListContext(context.Background())
+}
+
+// ListContext returns names of all ledgers in alphabetical order.
+func (lm *ledgerManager) ListContext(ctx context.Context) ([]string, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	lm.mutex.RLock()
+	defer lm.mutex.RUnlock()
+
+	names := make([]string, 0, len(lm.ledgers))
+	for name := range lm.ledgers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
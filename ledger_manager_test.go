@@ -0,0 +1,145 @@
+package bursary
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// closableLedger counts how many times it was closed.
+type closableLedger struct {
+	Ledger
+	closed int
+	err    error
+}
+
+func (l *closableLedger) Close() error {
+	l.closed++
+	return l.err
+}
+
+type closeTestError struct {
+	name string
+}
+
+func (e *closeTestError) Error() string {
+	return e.name
+}
+
+func Test_LedgerManager_Add(t *testing.T) {
+
+	lm := NewLedgerManager()
+
+	l := NewLedgerMemory()
+	assert.Nil(t, lm.Add("test", l))
+
+	// Existing ledger is never overwritten by Add
+	assert.Equal(t, ErrLedgerExists, lm.Add("test", NewLedgerMemory()))
+
+	found, err := lm.Get("test")
+	assert.Nil(t, err)
+	assert.Equal(t, l, found)
+
+	replaced := NewLedgerMemory()
+	assert.Nil(t, lm.Replace("test", replaced))
+
+	found, err = lm.Get("test")
+	assert.Nil(t, err)
+	assert.True(t, found == replaced)
+
+	assert.Nil(t, lm.Delete("test"))
+
+	_, err = lm.Get("test")
+	assert.Equal(t, ErrLedgerNotFound, err)
+}
+
+func Test_LedgerManager_List(t *testing.T) {
+
+	lm := NewLedgerManager()
+
+	names, err := lm.List()
+	assert.Nil(t, err)
+	assert.Len(t, names, 0)
+
+	for _, name := range []string{"slot", "general", "poker"} {
+		assert.Nil(t, lm.Add(name, NewLedgerMemory()))
+	}
+
+	names, err = lm.List()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"general", "poker", "slot"}, names)
+}
+
+func Test_LedgerManager_Concurrent(t *testing.T) {
+
+	lm := NewLedgerManager()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			name := fmt.Sprintf("ledger_%d", i)
+			assert.Nil(t, lm.Add(name, NewLedgerMemory()))
+
+			_, err := lm.Get(name)
+			assert.Nil(t, err)
+
+			_, err = lm.List()
+			assert.Nil(t, err)
+		}(i)
+	}
+
+	wg.Wait()
+
+	names, err := lm.List()
+	assert.Nil(t, err)
+	assert.Len(t, names, 10)
+}
+
+func Test_Bursary_Close(t *testing.T) {
+
+	gl := &closableLedger{Ledger: NewLedgerMemory()}
+	ok := &closableLedger{Ledger: NewLedgerMemory()}
+	errA := errors.New("a")
+	errB := &closeTestError{name: "b"}
+
+	bu := NewBursary(
+		WithGeneralLedger(gl),
+	)
+
+	assert.Nil(t, bu.LedgerManager().Add("a", &closableLedger{Ledger: NewLedgerMemory(), err: errA}))
+	assert.Nil(t, bu.LedgerManager().Add("b", &closableLedger{Ledger: NewLedgerMemory(), err: errB}))
+	assert.Nil(t, bu.LedgerManager().Add("ok", ok))
+	assert.Nil(t, bu.LedgerManager().Add("alias", ok))
+	assert.Nil(t, bu.LedgerManager().Add("memory", NewLedgerMemory()))
+
+	// Every ledger is closed once even if some fail
+	err := bu.Close()
+	if assert.NotNil(t, err) {
+
+		var me MultiError
+		if assert.True(t, errors.As(err, &me)) {
+			assert.Equal(t, MultiError{errA, errB}, me)
+		}
+
+		assert.ErrorIs(t, err, errA)
+		assert.ErrorIs(t, err, errB)
+
+		var ce *closeTestError
+		if assert.True(t, errors.As(err, &ce)) {
+			assert.Equal(t, errB, ce)
+		}
+	}
+
+	assert.Equal(t, 1, gl.closed)
+	assert.Equal(t, 1, ok.closed)
+
+	// Nothing to close
+	assert.Nil(t, NewBursary().Close())
+}
@@ -1,21 +1,204 @@
 package bursary
 
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
 type ledgerMemory struct {
+	mutex   sync.RWMutex
 	records []*LedgerEntry
+	wal     *WAL
+}
+
+type LedgerMemoryOpt func(*ledgerMemory)
+
+type ledgerSnapshot struct {
+	snapshotHeader
+	Records []*LedgerEntry `json:"records"`
 }
 
-func NewLedgerMemory() Ledger {
-	return &ledgerMemory{
+func NewLedgerMemory(opts ...LedgerMemoryOpt) Ledger {
+
+	l := &ledgerMemory{
 		records: make([]*LedgerEntry, 0),
 	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// WithLedgerWAL makes every write to be logged to wal before it is applied.
+func WithLedgerWAL(wal *WAL) LedgerMemoryOpt {
+	return func(l *ledgerMemory) {
+		l.wal = wal
+	}
+}
+
+// RecoverLedgerMemory rebuilds a memory ledger from the latest snapshot and
+// the records logged to wal after it. Snapshot can be nil if it was never
+// taken. Further writes keep being logged to wal.
+func RecoverLedgerMemory(snapshot io.Reader, wal *WAL) (Ledger, error) {
+
+	l := &ledgerMemory{
+		records: make([]*LedgerEntry, 0),
+	}
+
+	if snapshot != nil {
+		if err := l.Restore(snapshot); err != nil {
+			return nil, err
+		}
+	}
+
+	err := wal.Replay(func(op string, data json.RawMessage) error {
+
+		if op != "write_records" {
+			return nil
+		}
+
+		entries := make([]*LedgerEntry, 0)
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return err
+		}
+
+		l.records = append(l.records, entries...)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	l.wal = wal
+
+	return l, nil
+}
+
+func (l *ledgerMemory) Snapshot(w io.Writer) error {
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	return l.snapshot(w)
+}
+
+func (l *ledgerMemory) snapshot(w io.Writer) error {
+	return json.NewEncoder(w).Encode(&ledgerSnapshot{
+		snapshotHeader: snapshotHeader{
+			Version: SnapshotVersion,
+			Kind:    "ledger",
+		},
+		Records: l.records,
+	})
+}
+
+func (l *ledgerMemory) Restore(r io.Reader) error {
+
+	var s ledgerSnapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return err
+	}
+
+	if err := s.check("ledger"); err != nil {
+		return err
+	}
+
+	if s.Records == nil {
+		s.Records = make([]*LedgerEntry, 0)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.records = s.Records
+
+	return nil
+}
+
+func (l *ledgerMemory) Checkpoint(w io.Writer) error {
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if err := l.snapshot(w); err != nil {
+		return err
+	}
+
+	if l.wal == nil {
+		return nil
+	}
+
+	return l.wal.Truncate()
 }
 
 func (l *ledgerMemory) WriteRecords(entries []*LedgerEntry) error {
+	return l.WriteRecordsContext(context.Background(), entries)
+}
+
+func (l *ledgerMemory) WriteRecordsContext(ctx context.Context, entries []*LedgerEntry) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.wal != nil {
+		if err := l.wal.Append("write_records", entries); err != nil {
+			return err
+		}
+	}
+
 	l.records = append(l.records, entries...)
 	return nil
 }
 
+type ledgerMemoryStagedWrite struct {
+	l       *ledgerMemory
+	entries []*LedgerEntry
+}
+
+// StageRecords defers writing until Commit. Nothing can fail afterwards
+// unless WAL is used.
+func (l *ledgerMemory) StageRecords(ctx context.Context, entries []*LedgerEntry) (StagedWrite, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return &ledgerMemoryStagedWrite{
+		l:       l,
+		entries: entries,
+	}, nil
+}
+
+func (sw *ledgerMemoryStagedWrite) Commit() error {
+	return sw.l.WriteRecordsContext(context.Background(), sw.entries)
+}
+
+func (sw *ledgerMemoryStagedWrite) Rollback() error {
+	return nil
+}
+
 func (l *ledgerMemory) ReadRecordsByMemberID(memberID string, cond *Condition) ([]*LedgerEntry, error) {
+	return l.ReadRecordsByMemberIDContext(context.Background(), memberID, cond)
+}
+
+func (l *ledgerMemory) ReadRecordsByMemberIDContext(ctx context.Context, memberID string, cond *Condition) ([]*LedgerEntry, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if cond == nil {
+		cond = NewCondition()
+	}
 
 	if cond.Page < 1 {
 		cond.Page = 1
@@ -25,35 +208,97 @@ func (l *ledgerMemory) ReadRecordsByMemberID(memberID string, cond *Condition) (
 		cond.Limit = 1
 	}
 
-	start := (cond.Page - 1) * cond.Limit
+	// Sequence of records is their position in the ledger
+	from := 0
+	skip := (cond.Page - 1) * cond.Limit
+	if len(cond.After) > 0 {
+
+		after, err := ParseCursor(cond.After, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if after.Seq < 0 {
+			return nil, ErrInvalidCursor
+		}
+
+		from = int(after.Seq)
+		skip = 0
+	}
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	cond.NextCursor = ""
 
 	records := make([]*LedgerEntry, 0)
 
-	count := 0
-	for i, t := range l.records {
+	// Pagination applies to records of the member only
+	last := 0
+	for i := from; i < len(l.records); i++ {
+
+		t := l.records[i]
 
-		if i < start {
+		if t.MemberID != memberID {
 			continue
 		}
 
-		if count+1 > cond.Limit {
-			break
+		if !cond.TimeRange.Contains(t.CreatedAt) {
+			continue
 		}
 
-		if t.MemberID != memberID {
+		if skip > 0 {
+			skip--
 			continue
 		}
 
-		if cond.TimeRange != nil {
-			if !t.CreatedAt.Equal(cond.TimeRange.StartTime) || !t.CreatedAt.Equal(cond.TimeRange.EndTime) ||
-				!(t.CreatedAt.After(cond.TimeRange.StartTime) && t.CreatedAt.Before(cond.TimeRange.EndTime)) {
-				continue
-			}
+		// There are more records after the page
+		if len(records) >= cond.Limit {
+			cond.NextCursor = (&Cursor{
+				Seq: int64(last + 1),
+			}).String()
+			break
 		}
 
 		records = append(records, t)
-		count++
+		last = i
 	}
 
 	return records, nil
 }
+
+func (l *ledgerMemory) ScanRecords(filter *RecordFilter, fn func(le *LedgerEntry) error) error {
+	return l.ScanRecordsContext(context.Background(), filter, fn)
+}
+
+func (l *ledgerMemory) ScanRecordsContext(ctx context.Context, filter *RecordFilter, fn func(le *LedgerEntry) error) error {
+
+	// Records are only appended, so entries up to the current length stay
+	// untouched and can be scanned without holding the lock. Records written
+	// during scanning are not visited.
+	l.mutex.RLock()
+	records := l.records
+	l.mutex.RUnlock()
+
+	for _, le := range records {
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if !filter.Match(le) {
+			continue
+		}
+
+		if err := fn(le); err != nil {
+
+			if err == ErrStopScan {
+				return nil
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
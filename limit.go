@@ -0,0 +1,232 @@
+package bursary
+
+import (
+	"context"
+	"time"
+)
+
+type Period string
+
+const (
+	PeriodDay   Period = "day"
+	PeriodWeek  Period = "week"
+	PeriodMonth Period = "month"
+)
+
+// Start returns beginning of the period containing t in UTC. Weeks start on
+// Monday, and unknown periods are months.
+func (p Period) Start(t time.Time) time.Time {
+
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch p {
+	case PeriodDay:
+		return day
+	case PeriodWeek:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	}
+
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// AdjustmentsInfoKey is the key of LedgerEntry.Info listing adjustments made
+// by limits of rules. Every adjustment is a map of "kind" and "amount", which
+// is negative if earnings were capped.
+const AdjustmentsInfoKey = "adjustments"
+
+// UnallocatedInfoKey is the key of LedgerEntry.Info of the top-level member
+// telling its excess over caps, which is left unallocated without house
+// account, as "gain" and "commissions".
+const UnallocatedInfoKey = "unallocated"
+
+// Kinds of adjustments
+const (
+	AdjustmentTicketCap = "ticket_cap"
+	AdjustmentDailyCap  = "daily_cap"
+	AdjustmentPeriodCap = "period_cap"
+	AdjustmentFloor     = "floor"
+	AdjustmentCarried   = "carried" // excess of downstream
+)
+
+// setInfo sets key of Info in a copy, since Info is shared by all entries of
+// ticket.
+func setInfo(le *LedgerEntry, key string, value interface{}) {
+
+	info := make(map[string]interface{}, len(le.Info)+1)
+	for k, v := range le.Info {
+		info[k] = v
+	}
+
+	info[key] = value
+
+	le.Info = info
+}
+
+func addAdjustment(le *LedgerEntry, kind string, amount int64) {
+
+	adjustments := make([]interface{}, 0)
+	if list, ok := le.Info[AdjustmentsInfoKey].([]interface{}); ok {
+		adjustments = append(adjustments, list...)
+	}
+
+	setInfo(le, AdjustmentsInfoKey, append(adjustments, map[string]interface{}{
+		"kind":   kind,
+		"amount": amount,
+	}))
+}
+
+// ruleScope returns the pattern of channels whose entries count toward
+// limits and tiers of a rule resolved for channel from rule set for channel
+// resolved. Rules of parent channels cover all channels below them, and the
+// wildcard rule covers all channels, while default rules only cover channel.
+func ruleScope(channel string, resolved string) string {
+
+	switch resolved {
+	case "":
+		return channel
+	case WildcardChannel:
+		return WildcardChannel
+	}
+
+	return resolved + ChannelSeparator + WildcardChannel
+}
+
+func matchScope(scope string, channel string) bool {
+
+	if scope == WildcardChannel {
+		return channel != PayoutChannel
+	}
+
+	return MatchChannel(scope, channel)
+}
+
+// sumSince sums value of entries of member from channels of scope and
+// currency of ticket in general ledger, from start to time of ticket. Entries
+// of ticket itself don't count, so tickets written already, such as samples
+// of simulations, aren't counted twice.
+func (b *bursary) sumSince(ctx context.Context, t *Ticket, scope string, memberID string, start time.Time, value func(le *LedgerEntry) int64) (int64, error) {
+
+	filter := &RecordFilter{
+		MemberID: memberID,
+		TimeRange: &TimeRange{
+			StartTime: start,
+			EndTime:   t.CreatedAt,
+		},
+	}
+
+	// Ledgers only filter a single channel
+	if _, ok := ChannelBranch(scope); !ok && scope != WildcardChannel {
+		filter.Channel = scope
+	}
+
+	total := int64(0)
+	err := b.gl.ScanRecordsContext(ctx, filter, func(le *LedgerEntry) error {
+
+		if len(t.ID) > 0 && le.PrimaryID == t.ID {
+			return nil
+		}
+
+		if le.Currency == t.Currency && matchScope(scope, le.Channel) {
+			total += value(le)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// limitEarnings applies floor and caps of r to earnings of le, including
+// fixed amounts, and returns the gain and commissions exceeding caps which
+// are taken from le. Period caps count earnings from channels of scope.
+//
+// Period totals are read before the ticket is written, and not under a lock
+// held until WriteTicket finishes, so tickets of the same member written
+// concurrently may each pass a daily or period cap.
+func (b *bursary) limitEarnings(ctx context.Context, t *Ticket, scope string, le *LedgerEntry, r *Rule) (int64, int64, error) {
+
+	if r.TicketFloor > 0 && le.Earnings() < r.TicketFloor {
+		topUp := r.TicketFloor - le.Earnings()
+		le.Gain += topUp
+		addAdjustment(le, AdjustmentFloor, topUp)
+	}
+
+	// Finding the lowest limit
+	limit := int64(-1)
+	kind := ""
+
+	if r.TicketCap > 0 {
+		limit = r.TicketCap
+		kind = AdjustmentTicketCap
+	}
+
+	caps := []struct {
+		cap    int64
+		period Period
+		kind   string
+	}{
+		{r.DailyCap, PeriodDay, AdjustmentDailyCap},
+		{r.PeriodCap, r.Period, AdjustmentPeriodCap},
+	}
+
+	for _, c := range caps {
+
+		if c.cap <= 0 {
+			continue
+		}
+
+		earned, err := b.sumSince(ctx, t, scope, le.MemberID, c.period.Start(t.CreatedAt), (*LedgerEntry).Earnings)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		remaining := c.cap - earned
+		if remaining < 0 {
+			remaining = 0
+		}
+
+		if limit < 0 || remaining < limit {
+			limit = remaining
+			kind = c.kind
+		}
+	}
+
+	if limit < 0 || le.Earnings() <= limit {
+		return 0, 0, nil
+	}
+
+	excess := le.Earnings() - limit
+	addAdjustment(le, kind, -excess)
+
+	// Taking gain first, then commissions, and then fixed amounts which
+	// aren't carried since they don't come from the ticket
+	excessGain := int64(0)
+	if le.Gain > 0 {
+		excessGain = excess
+		if excessGain > le.Gain {
+			excessGain = le.Gain
+		}
+	}
+
+	excess -= excessGain
+
+	excessCommissions := int64(0)
+	if le.Commissions > 0 {
+		excessCommissions = excess
+		if excessCommissions > le.Commissions {
+			excessCommissions = le.Commissions
+		}
+	}
+
+	excess -= excessCommissions
+
+	le.Gain -= excessGain
+	le.Commissions -= excessCommissions
+	le.Fixed -= excess
+
+	return excessGain, excessCommissions, nil
+}
@@ -0,0 +1,331 @@
+package bursary
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newLimitTestRules returns rules of 3 levels from the top.
+func newLimitTestRules() []*Rule {
+
+	return []*Rule{
+		&Rule{Commission: 0.9, Share: 0.8},
+		&Rule{Commission: 0.7, Share: 0.6},
+		&Rule{Commission: 0.5, Share: 0.3},
+	}
+}
+
+func newLimitTestTicket(memberID string) *Ticket {
+
+	ticket := NewTicket()
+	ticket.MemberID = memberID
+	ticket.Amount = 1000
+	ticket.Fee = 100
+	ticket.Total = 1100
+
+	return ticket
+}
+
+func assertAdjustment(t *testing.T, le *LedgerEntry, kind string, amount int64) {
+
+	adjustments, ok := le.Info[AdjustmentsInfoKey].([]interface{})
+	if !assert.True(t, ok) || !assert.Len(t, adjustments, 1) {
+		return
+	}
+
+	a := adjustments[0].(map[string]interface{})
+	assert.Equal(t, kind, a["kind"])
+	assert.Equal(t, amount, a["amount"])
+}
+
+func Test_Period_Start(t *testing.T) {
+
+	// Thursday
+	ts := time.Date(2024, 2, 15, 13, 30, 0, 0, time.UTC)
+
+	assert.Equal(t, time.Date(2024, 2, 15, 0, 0, 0, 0, time.UTC), PeriodDay.Start(ts))
+	assert.Equal(t, time.Date(2024, 2, 12, 0, 0, 0, 0, time.UTC), PeriodWeek.Start(ts))
+	assert.Equal(t, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), PeriodMonth.Start(ts))
+	assert.Equal(t, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), Period("").Start(ts))
+}
+
+func Test_CalculateRewards_TicketCap(t *testing.T) {
+
+	bu := NewBursary()
+	defer bu.Close()
+
+	rules := newLimitTestRules()
+	rules[1].TicketCap = 200
+	ids := addTestChain(t, bu.RelationManager(), "default", rules...)
+
+	ticket := newLimitTestTicket(ids[2])
+	ticket.Info = map[string]interface{}{"round": 1}
+
+	entries, err := bu.CalculateRewards(ticket)
+	if !assert.Nil(t, err) || !assert.Len(t, entries, 3) {
+		return
+	}
+
+	assert.Equal(t, int64(300), entries[0].Gain)
+	assert.Equal(t, int64(50), entries[0].Commissions)
+
+	// 300 + 20 is capped to 200
+	assert.Equal(t, int64(180), entries[1].Gain)
+	assert.Equal(t, int64(20), entries[1].Commissions)
+	assert.Equal(t, int64(200), entries[1].Total)
+	assertAdjustment(t, entries[1], AdjustmentTicketCap, -120)
+	assert.Equal(t, 1, entries[1].Info["round"])
+
+	// Top-level member takes the excess
+	assert.Equal(t, int64(520), entries[2].Gain)
+	assert.Equal(t, int64(30), entries[2].Commissions)
+
+	// Info of ticket is left untouched
+	assert.Len(t, ticket.Info, 1)
+	assert.Nil(t, entries[0].Info[AdjustmentsInfoKey])
+}
+
+func Test_CalculateRewards_CapToHouse(t *testing.T) {
+
+	bu := NewBursary(WithHouseAccount("house"))
+	defer bu.Close()
+
+	rules := newLimitTestRules()
+	rules[0].TicketCap = 300
+	rules[1].TicketCap = 200
+	ids := addTestChain(t, bu.RelationManager(), "default", rules...)
+
+	entries, err := bu.CalculateRewards(newLimitTestTicket(ids[2]))
+	if !assert.Nil(t, err) || !assert.Len(t, entries, 4) {
+		return
+	}
+
+	// 200 + 20 plus 120 carried from downstream is capped to 300
+	assert.Equal(t, int64(280), entries[2].Gain)
+	assert.Equal(t, int64(20), entries[2].Commissions)
+
+	adjustments := entries[2].Info[AdjustmentsInfoKey].([]interface{})
+	assert.Len(t, adjustments, 2)
+
+	house := entries[3]
+	assert.Equal(t, int64(240), house.Gain)
+	assert.Equal(t, int64(10), house.Commissions)
+
+	gains := int64(0)
+	for _, le := range entries {
+		gains += le.Gain
+	}
+
+	assert.Equal(t, int64(1000), gains)
+}
+
+func Test_CalculateRewards_TopLevelCap(t *testing.T) {
+
+	bu := NewBursary()
+	defer bu.Close()
+
+	rules := newLimitTestRules()
+	rules[0].TicketCap = 100
+	ids := addTestChain(t, bu.RelationManager(), "default", rules...)
+
+	entries, err := bu.CalculateRewards(newLimitTestTicket(ids[2]))
+	if !assert.Nil(t, err) || !assert.Len(t, entries, 3) {
+		return
+	}
+
+	// 400 + 30 is capped to 100 without house account
+	top := entries[2]
+	assert.Equal(t, int64(70), top.Gain)
+	assert.Equal(t, int64(30), top.Commissions)
+	assertAdjustment(t, top, AdjustmentTicketCap, -330)
+
+	// Excess is left unallocated
+	assert.Equal(t, map[string]interface{}{
+		"gain":        int64(330),
+		"commissions": int64(0),
+	}, top.Info[UnallocatedInfoKey])
+}
+
+func Test_CalculateRewards_DailyCap(t *testing.T) {
+
+	bu := NewBursary()
+	defer bu.Close()
+
+	rules := newLimitTestRules()
+	rules[2].DailyCap = 500
+	ids := addTestChain(t, bu.RelationManager(), "default", rules...)
+
+	now := time.Now().UTC()
+
+	// Earnings of yesterday don't count
+	yesterday := newLimitTestTicket(ids[2])
+	yesterday.CreatedAt = now.AddDate(0, 0, -1)
+	assert.Nil(t, bu.WriteTicket(yesterday))
+
+	first := newLimitTestTicket(ids[2])
+	first.CreatedAt = now
+	assert.Nil(t, bu.WriteTicket(first))
+
+	second := newLimitTestTicket(ids[2])
+	second.CreatedAt = now
+
+	entries, err := bu.CalculateRewards(second)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// 350 was earned today
+	assert.Equal(t, int64(100), entries[0].Gain)
+	assert.Equal(t, int64(50), entries[0].Commissions)
+	assertAdjustment(t, entries[0], AdjustmentDailyCap, -200)
+
+	// Excess goes up to the next upstream
+	assert.Equal(t, int64(500), entries[1].Gain)
+	assertAdjustment(t, entries[1], AdjustmentCarried, 200)
+
+	assert.Nil(t, bu.WriteTicket(second))
+
+	// Nothing left for today
+	entries, err = bu.CalculateRewards(newLimitTestTicket(ids[2]))
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(0), entries[0].Earnings())
+	}
+}
+
+func Test_WriteTicket_CapRoutes(t *testing.T) {
+
+	bu := NewBursary(
+		WithLedgerRoutes(RouteByChannel()),
+	)
+	defer bu.Close()
+
+	rules := newLimitTestRules()
+	rules[2].DailyCap = 600
+	ids := addTestChain(t, bu.RelationManager(), "default", rules...)
+
+	assert.Nil(t, bu.LedgerManager().Add("default", NewLedgerMemory()))
+
+	// Caps would be switched off without entries in general ledger
+	err := bu.WriteTicket(newLimitTestTicket(ids[2]))
+	assert.ErrorIs(t, err, ErrGeneralLedgerNotRouted)
+	assert.Equal(t, 0, countRecords(t, bu.GeneralLedger()))
+
+	// Routing the member to general ledger as well
+	bu = NewBursary(
+		WithRelationManager(bu.RelationManager()),
+		WithLedgerRoutes(
+			RouteByChannel(),
+			RouteIf(func(le *LedgerEntry) bool {
+				return le.MemberID == ids[2]
+			}, GeneralLedgerName),
+		),
+	)
+	defer bu.Close()
+
+	assert.Nil(t, bu.LedgerManager().Add("default", NewLedgerMemory()))
+
+	now := time.Now().UTC()
+	earnings := make([]int64, 0)
+	for i := 0; i < 2; i++ {
+
+		ticket := newLimitTestTicket(ids[2])
+		ticket.CreatedAt = now
+
+		entries, err := bu.CalculateRewards(ticket)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		earnings = append(earnings, entries[0].Earnings())
+		assert.Nil(t, bu.WriteTicket(ticket))
+	}
+
+	assert.Equal(t, []int64{350, 250}, earnings)
+}
+
+func Test_CalculateRewards_PeriodCap(t *testing.T) {
+
+	bu := NewBursary()
+	defer bu.Close()
+
+	rules := newLimitTestRules()
+	rules[2].PeriodCap = 400
+	rules[2].Period = PeriodMonth
+	ids := addTestChain(t, bu.RelationManager(), "default", rules...)
+
+	first := newLimitTestTicket(ids[2])
+	first.CreatedAt = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	assert.Nil(t, bu.WriteTicket(first))
+
+	// Earnings of the previous month don't count
+	previous := newLimitTestTicket(ids[2])
+	previous.CreatedAt = time.Date(2024, 2, 29, 23, 0, 0, 0, time.UTC)
+	assert.Nil(t, bu.WriteTicket(previous))
+
+	second := newLimitTestTicket(ids[2])
+	second.CreatedAt = time.Date(2024, 3, 20, 0, 0, 0, 0, time.UTC)
+
+	entries, err := bu.CalculateRewards(second)
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(50), entries[0].Earnings())
+		assertAdjustment(t, entries[0], AdjustmentPeriodCap, -300)
+	}
+}
+
+func Test_CalculateRewards_TicketFloor(t *testing.T) {
+
+	bu := NewBursary()
+	defer bu.Close()
+
+	rules := newLimitTestRules()
+	rules[2].TicketFloor = 400
+	ids := addTestChain(t, bu.RelationManager(), "default", rules...)
+
+	entries, err := bu.CalculateRewards(newLimitTestTicket(ids[2]))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, int64(350), entries[0].Gain)
+	assert.Equal(t, int64(650), entries[0].Contributions)
+	assertAdjustment(t, entries[0], AdjustmentFloor, 50)
+
+	// Top-up comes out of residuals
+	assert.Equal(t, int64(350), entries[2].Gain)
+}
+
+func Test_CalculateRewards_ParentChannelCap(t *testing.T) {
+
+	bu := NewBursary()
+	defer bu.Close()
+
+	rules := newLimitTestRules()
+	rules[2].DailyCap = 500
+	ids := addTestChain(t, bu.RelationManager(), "default", rules...)
+
+	now := time.Now().UTC()
+
+	// Cap on the parent channel covers all channels below it
+	first := newLimitTestTicket(ids[2])
+	first.Channel = "default/slot"
+	first.CreatedAt = now
+	assert.Nil(t, bu.WriteTicket(first))
+
+	// Channels without the rule don't count
+	other := newLimitTestTicket(ids[2])
+	other.Channel = "other"
+	other.CreatedAt = now
+	assert.Nil(t, bu.WriteTicket(other))
+
+	second := newLimitTestTicket(ids[2])
+	second.Channel = "default/table"
+	second.CreatedAt = now
+
+	entries, err := bu.CalculateRewards(second)
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(150), entries[0].Earnings())
+		assertAdjustment(t, entries[0], AdjustmentDailyCap, -200)
+	}
+}
@@ -1,18 +1,59 @@
 package bursary
 
+import "time"
+
 type Member struct {
 	ID           string           `json:"id"`
 	ChannelRules map[string]*Rule `json:"channel_rules"`
 	RelationPath []string         `json:"relation_path"`
 	Upstream     string           `json:"upstream"`
+	CreatedAt    time.Time        `json:"created_at"`
+
+	// Depth is relative to the member whose downline was listed
+	Depth int `json:"depth,omitempty"`
 }
 
+// GetChannelRule returns the rule of the most specific channel up the
+// hierarchy of channel, or the wildcard rule if there is none.
 func (m *Member) GetChannelRule(channel string) *Rule {
+	_, r := m.resolveChannelRule(channel)
+	return r
+}
+
+// resolveChannelRule returns the rule of channel and the channel it's set
+// for.
+func (m *Member) resolveChannelRule(channel string) (string, *Rule) {
+
+	// Finding the rule for specific channel and then its parents
+	for _, c := range ChannelAncestors(channel) {
+		if r, ok := m.ChannelRules[c]; ok {
+			return c, r
+		}
+	}
+
+	if r, ok := m.ChannelRules[WildcardChannel]; ok {
+		return WildcardChannel, r
+	}
+
+	return "", nil
+}
+
+func (m *Member) clone() *Member {
+
+	c := &Member{
+		ID:           m.ID,
+		ChannelRules: make(map[string]*Rule),
+		RelationPath: make([]string, len(m.RelationPath)),
+		Upstream:     m.Upstream,
+		CreatedAt:    m.CreatedAt,
+		Depth:        m.Depth,
+	}
+
+	copy(c.RelationPath, m.RelationPath)
 
-	// Finding the rule for specific channel
-	if r, ok := m.ChannelRules[channel]; ok {
-		return r
+	for channel, r := range m.ChannelRules {
+		c.ChannelRules[channel] = r.clone()
 	}
 
-	return nil
+	return c
 }
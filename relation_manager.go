@@ -1,6 +1,7 @@
 package bursary
 
 import (
+	"context"
 	"errors"
 
 	"github.com/google/uuid"
@@ -10,6 +11,7 @@ var (
 	ErrMemberRequired   = errors.New("bursary: require member")
 	ErrMemberNotFound   = errors.New("bursary: member not found")
 	ErrUpstreamNotFound = errors.New("bursary: upstream not found")
+	ErrInvalidUpstream  = errors.New("bursary: invalid upstream")
 )
 
 type MemberEntry struct {
@@ -26,9 +28,42 @@ type RelationManager interface {
 	GetUpstreams(mid string) ([]*Member, error)
 	MoveMembers(mids []string, upstream string) error
 	ListMembers(upstream string, cond *Condition) ([]*Member, error)
+	ListDescendants(mid string, depth int, cond *Condition) ([]*Member, error)
+	CountDescendants(mid string) (int, error)
+
+	// UpdateChannelRule sets rule of channel for member mid, and fails with
+	// ErrMemberNotFound if it doesn't exist.
 	UpdateChannelRule(mid string, channel string, rule *Rule) error
+
+	// UpdateChannelRules sets rules of channel for many members at once,
+	// keyed by member ID. Either all members are updated or none of them, and
+	// it fails with ErrMemberNotFound if any of them doesn't exist.
+	UpdateChannelRules(channel string, rules map[string]*Rule) error
+
+	// RemoveChannelRule removes rule of channel from member mid, and fails
+	// with ErrMemberNotFound if it doesn't exist.
 	RemoveChannelRule(mid string, channel string) error
+
+	// RemoveChannel removes rules of channel from all members. A branch
+	// pattern such as "casino/*" removes "casino" and all channels below it.
 	RemoveChannel(channel string) error
+
+	// Context-aware variants
+	AddMembersContext(ctx context.Context, members []*MemberEntry, upstream string) error
+	ChangePathContext(ctx context.Context, mid string, newPath []string) error
+	DeleteMembersContext(ctx context.Context, mids []string) error
+	GetPathContext(ctx context.Context, mid string) ([]string, error)
+	GetMemberContext(ctx context.Context, mid string) (*Member, error)
+	GetUpstreamsContext(ctx context.Context, mid string) ([]*Member, error)
+	MoveMembersContext(ctx context.Context, mids []string, upstream string) error
+	ListMembersContext(ctx context.Context, upstream string, cond *Condition) ([]*Member, error)
+	ListDescendantsContext(ctx context.Context, mid string, depth int, cond *Condition) ([]*Member, error)
+	CountDescendantsContext(ctx context.Context, mid string) (int, error)
+	UpdateChannelRuleContext(ctx context.Context, mid string, channel string, rule *Rule) error
+	UpdateChannelRulesContext(ctx context.Context, channel string, rules map[string]*Rule) error
+	RemoveChannelRuleContext(ctx context.Context, mid string, channel string) error
+	RemoveChannelContext(ctx context.Context, channel string) error
+
 	Close() error
 }
 
@@ -1,6 +1,6 @@
 package relation_manager_postgres
 
-import "github.com/weedbox/bursary"
+import "github.com/weedbox/bursary/v2"
 
 func (mr *MemberRecord) ToMemberObject() *bursary.Member {
 
@@ -9,12 +9,25 @@ func (mr *MemberRecord) ToMemberObject() *bursary.Member {
 		ChannelRules: make(map[string]*bursary.Rule),
 		RelationPath: mr.RelationPath,
 		Upstream:     mr.Upstream,
+		CreatedAt:    mr.CreatedAt,
+		Depth:        mr.Depth,
 	}
 
 	for channel, rule := range mr.ChannelRules {
 		m.ChannelRules[channel] = &bursary.Rule{
-			Commission: rule.Commission,
-			Share:      rule.Share,
+			Commission:    rule.Commission,
+			Share:         rule.Share,
+			ReturnedShare: rule.ReturnedShare,
+			TicketCap:     rule.TicketCap,
+			DailyCap:      rule.DailyCap,
+			PeriodCap:     rule.PeriodCap,
+			Period:        rule.Period,
+			TicketFloor:   rule.TicketFloor,
+			Tiers:         rule.Tiers,
+			TierVolume:    rule.TierVolume,
+			Fixed:         rule.Fixed,
+			Bonuses:       rule.Bonuses,
+			Ceiling:       rule.Ceiling,
 		}
 	}
 
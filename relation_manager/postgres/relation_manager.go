@@ -1,6 +1,7 @@
 package relation_manager_postgres
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -10,7 +11,7 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/kulado/sqlxmigrate"
 	"github.com/lib/pq"
-	"github.com/weedbox/bursary"
+	"github.com/weedbox/bursary/v2"
 )
 
 const RootNode = "00000000-0000-0000-0000-000000000000"
@@ -19,6 +20,7 @@ type Opt func(*RelationManagerPostgres)
 
 type RelationManagerPostgres struct {
 	db        *sqlx.DB
+	tx        *sqlx.Tx
 	tableName string
 }
 
@@ -48,6 +50,51 @@ func WithTableName(tableName string) Opt {
 	}
 }
 
+// WithTx returns a copy of the manager bound to an existing transaction. Every
+// operation of the copy runs inside tx, and committing or rolling back is left
+// to the caller, so bursary operations can be composed with other DB work.
+func (rm *RelationManagerPostgres) WithTx(tx *sqlx.Tx) *RelationManagerPostgres {
+	c := *rm
+	c.tx = tx
+	return &c
+}
+
+// RunInTx begins a new transaction, runs fn with a manager bound to it and
+// commits if fn succeeds. The transaction is rolled back if fn returns an error.
+func (rm *RelationManagerPostgres) RunInTx(fn func(tx *sqlx.Tx, rm *RelationManagerPostgres) error) error {
+	return rm.RunInTxContext(context.Background(), fn)
+}
+
+func (rm *RelationManagerPostgres) RunInTxContext(ctx context.Context, fn func(tx *sqlx.Tx, rm *RelationManagerPostgres) error) error {
+
+	if rm.tx != nil {
+		// Already running in a transaction owned by caller
+		return fn(rm.tx, rm)
+	}
+
+	tx, err := rm.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx, rm.WithTx(tx))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (rm *RelationManagerPostgres) ext() sqlx.ExtContext {
+
+	if rm.tx != nil {
+		return rm.tx
+	}
+
+	return rm.db
+}
+
 func (rm *RelationManagerPostgres) Init() error {
 
 	// Initializing table
@@ -74,6 +121,34 @@ func (rm *RelationManagerPostgres) Init() error {
 				return err
 			},
 		},
+		{
+			ID: "202610190001",
+			Migrate: func(tx *sql.Tx) error {
+
+				// Speed up looking for descendants by relation path
+				q := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s_relation_path_idx" ON "%s" USING GIN ("relation_path")`, rm.tableName, rm.tableName)
+				_, err := tx.Exec(q)
+				if err != nil {
+					return err
+				}
+
+				q = fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s_upstream_idx" ON "%s" ("upstream")`, rm.tableName, rm.tableName)
+				_, err = tx.Exec(q)
+				return err
+			},
+			Rollback: func(tx *sql.Tx) error {
+
+				q := fmt.Sprintf(`DROP INDEX IF EXISTS "%s_relation_path_idx"`, rm.tableName)
+				_, err := tx.Exec(q)
+				if err != nil {
+					return err
+				}
+
+				q = fmt.Sprintf(`DROP INDEX IF EXISTS "%s_upstream_idx"`, rm.tableName)
+				_, err = tx.Exec(q)
+				return err
+			},
+		},
 	})
 
 	if err := m.Migrate(); err != nil {
@@ -84,18 +159,51 @@ func (rm *RelationManagerPostgres) Init() error {
 }
 
 func (rm *RelationManagerPostgres) Close() error {
+
+	// Connection is owned by whoever created the transaction
+	if rm.tx != nil {
+		return nil
+	}
+
 	return rm.db.Close()
 }
 
+func (rm *RelationManagerPostgres) selectMembers(ctx context.Context, cmd string, args ...interface{}) ([]*bursary.Member, error) {
+
+	members := make([]*bursary.Member, 0)
+
+	rows, err := rm.ext().QueryxContext(ctx, cmd, args...)
+	if err != nil {
+		return members, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record := &MemberRecord{}
+		err := rows.StructScan(record)
+		if err != nil {
+			return members, err
+		}
+
+		members = append(members, record.ToMemberObject())
+	}
+
+	return members, rows.Err()
+}
+
 func (rm *RelationManagerPostgres) GetPath(mid string) ([]string, error) {
+	return rm.GetPathContext(context.Background(), mid)
+}
+
+func (rm *RelationManagerPostgres) GetPathContext(ctx context.Context, mid string) ([]string, error) {
 
 	if len(mid) == 0 {
 		return []string{}, nil
 	}
 
-	cmd := fmt.Sprintf(`SELECT * FROM %s WHERE id = $1`, rm.tableName)
+	cmd := fmt.Sprintf(`SELECT * FROM "%s" WHERE id = $1`, rm.tableName)
 	record := &MemberRecord{}
-	err := rm.db.Get(record, cmd, mid)
+	err := sqlx.GetContext(ctx, rm.ext(), record, cmd, mid)
 	if err != nil {
 		return []string{}, err
 	}
@@ -107,55 +215,78 @@ func (rm *RelationManagerPostgres) GetPath(mid string) ([]string, error) {
 	return p, nil
 }
 
+// ChangePathByUpstream sets relation path of all direct downstreams of upstream
+// to newPath, and rewrites paths of the whole subtree below them accordingly.
 func (rm *RelationManagerPostgres) ChangePathByUpstream(upstream string, newPath []string) error {
+	return rm.ChangePathByUpstreamContext(context.Background(), upstream, newPath)
+}
 
-	if len(upstream) == 0 {
-		upstream = RootNode
+func (rm *RelationManagerPostgres) ChangePathByUpstreamContext(ctx context.Context, upstream string, newPath []string) error {
+
+	if len(upstream) == 0 || upstream == RootNode {
+
+		// Every member is in the subtree of root node
+		cmd := fmt.Sprintf(`UPDATE "%s" SET relation_path = $1::text[] || relation_path`, rm.tableName)
+		_, err := rm.ext().ExecContext(ctx, cmd, pq.StringArray(newPath))
+		return err
 	}
 
-	cmd := fmt.Sprintf(`UPDATE %s SET relation_path = $1 WHERE upstream = $2 RETURNING id`, rm.tableName)
-	rows, err := rm.db.Queryx(cmd, pq.StringArray(newPath), upstream)
+	// Replace everything up to upstream in a single statement
+	cmd := fmt.Sprintf(`UPDATE "%s"
+		SET relation_path = $1::text[] || relation_path[array_position(relation_path, $2::text) + 1:]
+		WHERE relation_path @> ARRAY[$2::text]`, rm.tableName)
+	_, err := rm.ext().ExecContext(ctx, cmd, pq.StringArray(newPath), upstream)
 
-	// Update downstreams
-	record := &MemberRecord{}
-	for rows.Next() {
-		err := rows.StructScan(&record)
-		if err != nil {
-			return err
-		}
+	return err
+}
 
-		curPath := append(newPath, record.ID)
-		err = rm.ChangePathByUpstream(record.ID, curPath)
-		if err != nil {
-			return err
-		}
+// rePath moves the member and its whole subtree by replacing the member's
+// relation path with newPath, keeping the rest of descendants' paths.
+func (rm *RelationManagerPostgres) rePath(ctx context.Context, mid string, upstream string, newPath []string) error {
+
+	cmd := fmt.Sprintf(`UPDATE "%s"
+		SET
+			relation_path = CASE
+				WHEN id = $2 THEN $1::text[]
+				ELSE $1::text[] || relation_path[array_position(relation_path, $2::text):]
+			END,
+			upstream = CASE
+				WHEN id = $2 AND $3::uuid IS NOT NULL THEN $3::uuid
+				ELSE upstream
+			END
+		WHERE id = $2 OR relation_path @> ARRAY[$2::text]`, rm.tableName)
+
+	var us interface{}
+	if len(upstream) > 0 {
+		us = upstream
 	}
 
+	_, err := rm.ext().ExecContext(ctx, cmd, pq.StringArray(newPath), mid, us)
+
 	return err
 }
 
 func (rm *RelationManagerPostgres) ChangePath(mid string, newPath []string) error {
+	return rm.ChangePathContext(context.Background(), mid, newPath)
+}
 
-	cmd := fmt.Sprintf(`UPDATE %s SET relation_path = $1 WHERE id = $2`, rm.tableName)
-	_, err := rm.db.Exec(cmd, pq.StringArray(newPath), mid)
-	if err != nil {
-		return err
-	}
-
-	// Update downstreams
-	curPath := append(newPath, mid)
-	return rm.ChangePathByUpstream(mid, curPath)
+func (rm *RelationManagerPostgres) ChangePathContext(ctx context.Context, mid string, newPath []string) error {
+	return rm.rePath(ctx, mid, "", newPath)
 }
 
 func (rm *RelationManagerPostgres) GetMember(mid string) (*bursary.Member, error) {
+	return rm.GetMemberContext(context.Background(), mid)
+}
+
+func (rm *RelationManagerPostgres) GetMemberContext(ctx context.Context, mid string) (*bursary.Member, error) {
 
 	if len(mid) == 0 {
 		return nil, bursary.ErrMemberNotFound
 	}
 
-	cmd := fmt.Sprintf(`SELECT * FROM %s WHERE id = $1`, rm.tableName)
+	cmd := fmt.Sprintf(`SELECT * FROM "%s" WHERE id = $1`, rm.tableName)
 	records := []MemberRecord{}
-	err := rm.db.Select(&records, cmd, mid)
+	err := sqlx.SelectContext(ctx, rm.ext(), &records, cmd, mid)
 	if err != nil {
 		return nil, err
 	}
@@ -168,13 +299,24 @@ func (rm *RelationManagerPostgres) GetMember(mid string) (*bursary.Member, error
 }
 
 func (rm *RelationManagerPostgres) AddMembers(members []*bursary.MemberEntry, upstream string) error {
+	return rm.AddMembersContext(context.Background(), members, upstream)
+}
+
+func (rm *RelationManagerPostgres) AddMembersContext(ctx context.Context, members []*bursary.MemberEntry, upstream string) error {
 
 	if len(members) == 0 {
 		return bursary.ErrMemberRequired
 	}
 
+	return rm.RunInTxContext(ctx, func(tx *sqlx.Tx, rm *RelationManagerPostgres) error {
+		return rm.addMembers(ctx, members, upstream)
+	})
+}
+
+func (rm *RelationManagerPostgres) addMembers(ctx context.Context, members []*bursary.MemberEntry, upstream string) error {
+
 	// Make sure that upstream exists
-	rp, err := rm.GetPath(upstream)
+	rp, err := rm.GetPathContext(ctx, upstream)
 	if err != nil {
 		return bursary.ErrUpstreamNotFound
 	}
@@ -203,8 +345,19 @@ func (rm *RelationManagerPostgres) AddMembers(members []*bursary.MemberEntry, up
 
 		for channel, cr := range me.ChannelRules {
 			m.ChannelRules[channel] = &Rule{
-				Commission: cr.Commission,
-				Share:      cr.Share,
+				Commission:    cr.Commission,
+				Share:         cr.Share,
+				ReturnedShare: cr.ReturnedShare,
+				TicketCap:     cr.TicketCap,
+				DailyCap:      cr.DailyCap,
+				PeriodCap:     cr.PeriodCap,
+				Period:        cr.Period,
+				TicketFloor:   cr.TicketFloor,
+				Tiers:         cr.Tiers,
+				TierVolume:    cr.TierVolume,
+				Fixed:         cr.Fixed,
+				Bonuses:       cr.Bonuses,
+				Ceiling:       cr.Ceiling,
 			}
 		}
 
@@ -226,14 +379,24 @@ func (rm *RelationManagerPostgres) AddMembers(members []*bursary.MemberEntry, up
 			:created_at
 		)`, rm.tableName)
 
-	_, err = rm.db.NamedExec(cmd, records)
+	_, err = sqlx.NamedExecContext(ctx, rm.ext(), cmd, records)
 
 	return err
 }
 
 func (rm *RelationManagerPostgres) MoveMembers(mids []string, upstream string) error {
+	return rm.MoveMembersContext(context.Background(), mids, upstream)
+}
+
+func (rm *RelationManagerPostgres) MoveMembersContext(ctx context.Context, mids []string, upstream string) error {
+	return rm.RunInTxContext(ctx, func(tx *sqlx.Tx, rm *RelationManagerPostgres) error {
+		return rm.moveMembers(ctx, mids, upstream)
+	})
+}
 
-	rp, err := rm.GetPath(upstream)
+func (rm *RelationManagerPostgres) moveMembers(ctx context.Context, mids []string, upstream string) error {
+
+	rp, err := rm.GetPathContext(ctx, upstream)
 	if err != nil {
 		return bursary.ErrUpstreamNotFound
 	}
@@ -242,115 +405,329 @@ func (rm *RelationManagerPostgres) MoveMembers(mids []string, upstream string) e
 		upstream = RootNode
 	}
 
-	// update members
-	cmd := fmt.Sprintf(`UPDATE %s SET upstream = $1, relation_path = $2 WHERE id = ANY ($3)`, rm.tableName)
-	_, err = rm.db.Exec(cmd, upstream, pq.StringArray(rp), pq.Array(mids))
-	if err != nil {
-		return err
+	// Member cannot be moved into its own subtree
+	for _, mid := range mids {
+		for _, id := range rp {
+			if id == mid {
+				return bursary.ErrInvalidUpstream
+			}
+		}
 	}
 
-	// update downstreams
+	// update members and downstreams
 	for _, mid := range mids {
-		curPath := append(rp, mid)
-		rm.ChangePathByUpstream(mid, curPath)
+		err = rm.rePath(ctx, mid, upstream, rp)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
 func (rm *RelationManagerPostgres) DeleteMembers(mids []string) error {
+	return rm.DeleteMembersContext(context.Background(), mids)
+}
+
+func (rm *RelationManagerPostgres) DeleteMembersContext(ctx context.Context, mids []string) error {
 
-	cmd := fmt.Sprintf(`DELETE FROM %s WHERE id = ANY ($1)`, rm.tableName)
-	_, err := rm.db.Exec(cmd, pq.Array(mids))
+	cmd := fmt.Sprintf(`DELETE FROM "%s" WHERE id = ANY ($1)`, rm.tableName)
+	_, err := rm.ext().ExecContext(ctx, cmd, pq.Array(mids))
 
 	return err
 }
 
 func (rm *RelationManagerPostgres) GetUpstreams(mid string) ([]*bursary.Member, error) {
+	return rm.GetUpstreamsContext(context.Background(), mid)
+}
 
-	members := make([]*bursary.Member, 0)
+func (rm *RelationManagerPostgres) GetUpstreamsContext(ctx context.Context, mid string) ([]*bursary.Member, error) {
+
+	// Keep the order of relation path which is from root to the closest upstream
+	cmd := fmt.Sprintf(`SELECT r.* FROM "%s" AS m
+		CROSS JOIN LATERAL unnest(m.relation_path) WITH ORDINALITY AS p(id, ord)
+		JOIN "%s" AS r ON r.id::text = p.id
+		WHERE m.id = $1
+		ORDER BY p.ord`, rm.tableName, rm.tableName)
+
+	return rm.selectMembers(ctx, cmd, mid)
+}
+
+func (rm *RelationManagerPostgres) ListMembers(upstream string, cond *bursary.Condition) ([]*bursary.Member, error) {
+	return rm.ListMembersContext(context.Background(), upstream, cond)
+}
+
+func (rm *RelationManagerPostgres) ListMembersContext(ctx context.Context, upstream string, cond *bursary.Condition) ([]*bursary.Member, error) {
+
+	if cond == nil {
+		cond = bursary.NewCondition()
+	}
+
+	if cond.Page < 1 {
+		cond.Page = 1
+	}
+
+	if cond.Limit < 1 {
+		cond.Limit = 1
+	}
 
-	cmd := fmt.Sprintf(`SELECT * FROM %s WHERE id::text IN (
-		SELECT unnest(relation_path) FROM %s WHERE id = $1
-	)`, rm.tableName, rm.tableName)
+	if len(upstream) == 0 {
+		upstream = RootNode
+	}
 
-	rows, err := rm.db.Queryx(cmd, mid)
+	fields, err := bursary.MemberSort(cond.Sort, &bursary.SortField{
+		Field:     bursary.SortByCreatedAt,
+		Ascending: true,
+	})
 	if err != nil {
-		return members, err
+		return nil, err
 	}
 
-	record := &MemberRecord{}
-	for rows.Next() {
-		err := rows.StructScan(&record)
-		if err != nil {
-			return members, err
-		}
+	after, err := parseAfter(cond, fields)
+	if err != nil {
+		return nil, err
+	}
 
-		members = append(members, record.ToMemberObject())
+	offset := (cond.Page - 1) * cond.Limit
+	if after != nil {
+		offset = 0
 	}
 
-	return members, nil
+	where, args := timeRangeFilter(cond.TimeRange, []interface{}{upstream})
+	keyset, args := keysetFilter(fields, after, args)
+
+	// One more member tells whether there is next page
+	args = append(args, offset, cond.Limit+1)
+
+	cmd := fmt.Sprintf(`SELECT * FROM "%s" WHERE upstream = $1%s%s ORDER BY %s OFFSET $%d LIMIT $%d`,
+		rm.tableName,
+		where,
+		keyset,
+		orderBy(fields),
+		len(args)-1,
+		len(args),
+	)
+
+	members, err := rm.selectMembers(ctx, cmd, args...)
+	if err != nil {
+		return members, err
+	}
+
+	return nextPage(members, fields, cond), nil
 }
 
-func (rm *RelationManagerPostgres) ListMembers(upstream string, cond *bursary.Condition) ([]*bursary.Member, error) {
+func (rm *RelationManagerPostgres) ListDescendants(mid string, depth int, cond *bursary.Condition) ([]*bursary.Member, error) {
+	return rm.ListDescendantsContext(context.Background(), mid, depth, cond)
+}
+
+func (rm *RelationManagerPostgres) ListDescendantsContext(ctx context.Context, mid string, depth int, cond *bursary.Condition) ([]*bursary.Member, error) {
 
 	if cond == nil {
 		cond = bursary.NewCondition()
 	}
 
-	if len(upstream) == 0 {
-		upstream = RootNode
+	if cond.Page < 1 {
+		cond.Page = 1
 	}
 
-	members := make([]*bursary.Member, 0)
+	if cond.Limit < 1 {
+		cond.Limit = 1
+	}
+
+	_, err := rm.GetMemberContext(ctx, mid)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := bursary.MemberSort(cond.Sort, &bursary.SortField{
+		Field:     bursary.SortByDepth,
+		Ascending: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := parseAfter(cond, fields)
+	if err != nil {
+		return nil, err
+	}
 
 	offset := (cond.Page - 1) * cond.Limit
+	if after != nil {
+		offset = 0
+	}
 
-	cmd := fmt.Sprintf(`SELECT * FROM %s WHERE upstream = $1 OFFSET $2 LIMIT $3`, rm.tableName)
-	rows, err := rm.db.Queryx(cmd, upstream, offset, cond.Limit)
+	where, args := timeRangeFilter(cond.TimeRange, []interface{}{mid, depth})
+	keyset, args := keysetFilter(fields, after, args)
+
+	// One more member tells whether there is next page
+	args = append(args, offset, cond.Limit+1)
+
+	// Depth is the distance between member and mid in relation path
+	cmd := fmt.Sprintf(`SELECT * FROM (
+			SELECT *, cardinality(relation_path) - array_position(relation_path, $1::text) + 1 AS depth
+			FROM "%s"
+			WHERE relation_path @> ARRAY[$1::text]
+		) AS d
+		WHERE ($2 < 1 OR depth <= $2)%s%s
+		ORDER BY %s
+		OFFSET $%d LIMIT $%d`,
+		rm.tableName,
+		where,
+		keyset,
+		orderBy(fields),
+		len(args)-1,
+		len(args),
+	)
+
+	members, err := rm.selectMembers(ctx, cmd, args...)
 	if err != nil {
 		return members, err
 	}
 
-	record := &MemberRecord{}
-	for rows.Next() {
-		err := rows.StructScan(&record)
-		if err != nil {
-			return members, err
-		}
+	return nextPage(members, fields, cond), nil
+}
 
-		members = append(members, record.ToMemberObject())
+func (rm *RelationManagerPostgres) CountDescendants(mid string) (int, error) {
+	return rm.CountDescendantsContext(context.Background(), mid)
+}
+
+func (rm *RelationManagerPostgres) CountDescendantsContext(ctx context.Context, mid string) (int, error) {
+
+	_, err := rm.GetMemberContext(ctx, mid)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	cmd := fmt.Sprintf(`SELECT count(*) FROM "%s" WHERE relation_path @> ARRAY[$1::text]`, rm.tableName)
+	err = sqlx.GetContext(ctx, rm.ext(), &count, cmd, mid)
+	if err != nil {
+		return 0, err
 	}
 
-	return members, nil
+	return count, nil
 }
 
 func (rm *RelationManagerPostgres) UpdateChannelRule(mid string, channel string, rule *bursary.Rule) error {
+	return rm.UpdateChannelRuleContext(context.Background(), mid, channel, rule)
+}
+
+func (rm *RelationManagerPostgres) UpdateChannelRuleContext(ctx context.Context, mid string, channel string, rule *bursary.Rule) error {
 
 	if rule == nil {
 		return nil
 	}
 
-	ruleData, _ := json.Marshal(rule)
+	ruleData, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
 
-	cmd := fmt.Sprintf(`UPDATE %s SET channel_rules = jsonb_set(channel_rules, '{%s}', $1::jsonb) WHERE id = $2`, rm.tableName, channel)
-	_, err := rm.db.Exec(cmd, ruleData, mid)
+	// Channel is bound as a path element rather than formatted into the statement
+	cmd := fmt.Sprintf(`UPDATE "%s" SET channel_rules = jsonb_set(COALESCE(channel_rules, '{}'::jsonb), ARRAY[$1]::text[], $2::jsonb) WHERE id = $3`, rm.tableName)
+	res, err := rm.ext().ExecContext(ctx, cmd, channel, ruleData, mid)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return memberAffected(res)
+}
+
+func (rm *RelationManagerPostgres) UpdateChannelRules(channel string, rules map[string]*bursary.Rule) error {
+	return rm.UpdateChannelRulesContext(context.Background(), channel, rules)
+}
+
+func (rm *RelationManagerPostgres) UpdateChannelRulesContext(ctx context.Context, channel string, rules map[string]*bursary.Rule) error {
+
+	if len(rules) == 0 {
+		return nil
+	}
+
+	cmd := fmt.Sprintf(`UPDATE "%s" SET channel_rules = jsonb_set(COALESCE(channel_rules, '{}'::jsonb), ARRAY[$1]::text[], $2::jsonb) WHERE id = $3`, rm.tableName)
+
+	return rm.RunInTxContext(ctx, func(tx *sqlx.Tx, rm *RelationManagerPostgres) error {
+
+		for mid, rule := range rules {
+
+			if rule == nil {
+				continue
+			}
+
+			ruleData, err := json.Marshal(rule)
+			if err != nil {
+				return err
+			}
+
+			res, err := rm.ext().ExecContext(ctx, cmd, channel, ruleData, mid)
+			if err != nil {
+				return err
+			}
+
+			// Rolls back rules updated so far
+			if err := memberAffected(res); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
 }
 
 func (rm *RelationManagerPostgres) RemoveChannelRule(mid string, channel string) error {
+	return rm.RemoveChannelRuleContext(context.Background(), mid, channel)
+}
 
-	cmd := fmt.Sprintf(`UPDATE %s SET channel_rules = channel_rules - $1 WHERE id = $2`, rm.tableName)
-	_, err := rm.db.Exec(cmd, channel, mid)
+func (rm *RelationManagerPostgres) RemoveChannelRuleContext(ctx context.Context, mid string, channel string) error {
 
-	return err
+	cmd := fmt.Sprintf(`UPDATE "%s" SET channel_rules = channel_rules - $1 WHERE id = $2`, rm.tableName)
+	res, err := rm.ext().ExecContext(ctx, cmd, channel, mid)
+	if err != nil {
+		return err
+	}
+
+	return memberAffected(res)
+}
+
+// memberAffected returns ErrMemberNotFound unless res updated a member.
+func memberAffected(res sql.Result) error {
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return bursary.ErrMemberNotFound
+	}
+
+	return nil
 }
 
 func (rm *RelationManagerPostgres) RemoveChannel(channel string) error {
+	return rm.RemoveChannelContext(context.Background(), channel)
+}
+
+func (rm *RelationManagerPostgres) RemoveChannelContext(ctx context.Context, channel string) error {
 
-	cmd := fmt.Sprintf(`UPDATE %s SET channel_rules = channel_rules - $1`, rm.tableName)
-	_, err := rm.db.Exec(cmd, channel)
+	branch, ok := bursary.ChannelBranch(channel)
+	if !ok {
+		cmd := fmt.Sprintf(`UPDATE "%s" SET channel_rules = channel_rules - $1`, rm.tableName)
+		_, err := rm.ext().ExecContext(ctx, cmd, channel)
+
+		return err
+	}
+
+	// Remove the top of branch and all channels below it
+	cmd := fmt.Sprintf(`UPDATE "%s" SET channel_rules = (
+			SELECT COALESCE(jsonb_object_agg(key, value), '{}'::jsonb) FROM jsonb_each(channel_rules)
+			WHERE key <> $1 AND left(key, char_length($2)) <> $2
+		)
+		WHERE EXISTS (
+			SELECT 1 FROM jsonb_each(channel_rules)
+			WHERE key = $1 OR left(key, char_length($2)) = $2
+		)`, rm.tableName)
+	_, err := rm.ext().ExecContext(ctx, cmd, branch, branch+bursary.ChannelSeparator)
 
 	return err
 }
@@ -3,10 +3,12 @@ package relation_manager_postgres
 import (
 	"fmt"
 	"log"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
-	"github.com/weedbox/bursary"
+	"github.com/weedbox/bursary/v2"
+	"github.com/weedbox/bursary/v2/bursarytest"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
@@ -17,10 +19,17 @@ var testTable = "relationships_test"
 var testRM *RelationManagerPostgres
 var testBu bursary.Bursary
 
-func init() {
+func TestMain(m *testing.M) {
+
+	// Tests require a running PostgreSQL server
+	dsn := os.Getenv("BURSARY_TEST_POSTGRES_DSN")
+	if len(dsn) == 0 {
+		fmt.Println("BURSARY_TEST_POSTGRES_DSN is not set, skipping PostgreSQL tests")
+		os.Exit(0)
+	}
 
 	// Connect to postgres server
-	db, err := sqlx.Connect("postgres", "port=32768 user=postgres password=1qazXSW@ dbname=bursary sslmode=disable")
+	db, err := sqlx.Connect("postgres", dsn)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -43,6 +52,8 @@ func init() {
 	testBu = bursary.NewBursary(
 		bursary.WithRelationManager(testRM),
 	)
+
+	os.Exit(m.Run())
 }
 
 func uninit() {
@@ -529,3 +540,277 @@ func Test_RelationManagerPostgres_RemoveChannel(t *testing.T) {
 		assert.Nil(t, m.ChannelRules["default"])
 	}
 }
+
+func Test_RelationManagerPostgres_UpdateChannelRule_SpecialName(t *testing.T) {
+
+	defer uninit()
+
+	// Preparing members
+	me := bursary.NewMemberEntry()
+
+	// Create a new member
+	err := testBu.RelationManager().AddMembers([]*bursary.MemberEntry{
+		me,
+	}, "")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Channel name which would break statement if it was formatted into SQL
+	channel := `x}', '{}'::jsonb) --`
+
+	err = testBu.RelationManager().UpdateChannelRule(me.ID, channel, &bursary.Rule{
+		Commission: 0.3,
+		Share:      0.2,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Get new member info
+	m, err := testBu.RelationManager().GetMember(me.ID)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	if assert.NotNil(t, m.ChannelRules[channel]) {
+		assert.Equal(t, float64(0.3), m.ChannelRules[channel].Commission)
+		assert.Equal(t, float64(0.2), m.ChannelRules[channel].Share)
+	}
+}
+
+func Test_RelationManagerPostgres_WithTx(t *testing.T) {
+
+	defer uninit()
+
+	me := bursary.NewMemberEntry()
+
+	// Rollback should discard everything done in transaction
+	tx, err := testDb.Beginx()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	err = testRM.WithTx(tx).AddMembers([]*bursary.MemberEntry{
+		me,
+	}, "")
+	if !assert.Nil(t, err) {
+		tx.Rollback()
+		return
+	}
+
+	err = tx.Rollback()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	_, err = testRM.GetMember(me.ID)
+	assert.Equal(t, bursary.ErrMemberNotFound, err)
+
+	// Commit should keep it
+	err = testRM.RunInTx(func(tx *sqlx.Tx, rm *RelationManagerPostgres) error {
+		return rm.AddMembers([]*bursary.MemberEntry{
+			me,
+		}, "")
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	m, err := testRM.GetMember(me.ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, me.ID, m.ID)
+	}
+}
+
+func Test_RelationManagerPostgres_MoveMembers_Rollback(t *testing.T) {
+
+	defer uninit()
+
+	root := bursary.NewMemberEntry()
+	child := bursary.NewMemberEntry()
+
+	err := testRM.AddMembers([]*bursary.MemberEntry{
+		root,
+	}, "")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	err = testRM.AddMembers([]*bursary.MemberEntry{
+		child,
+	}, root.ID)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Moving to an upstream which doesn't exist should change nothing
+	err = testRM.MoveMembers([]string{child.ID}, bursary.NewMemberEntry().ID)
+	assert.Equal(t, bursary.ErrUpstreamNotFound, err)
+
+	m, err := testRM.GetMember(child.ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, root.ID, m.Upstream)
+		assert.Equal(t, []string{root.ID}, m.RelationPath)
+	}
+}
+
+func Test_RelationManagerPostgres_MoveMembers_Deep(t *testing.T) {
+
+	defer uninit()
+
+	// Preparing a chain of members
+	levels := make([]*bursary.MemberEntry, 0)
+	prevLevel := ""
+	for i := 0; i < 6; i++ {
+
+		me := bursary.NewMemberEntry()
+		err := testRM.AddMembers([]*bursary.MemberEntry{
+			me,
+		}, prevLevel)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		levels = append(levels, me)
+		prevLevel = me.ID
+	}
+
+	// Moving member into its own subtree is not allowed
+	err := testRM.MoveMembers([]string{levels[1].ID}, levels[3].ID)
+	assert.Equal(t, bursary.ErrInvalidUpstream, err)
+
+	// Move the third level to root
+	err = testRM.MoveMembers([]string{levels[2].ID}, "")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Check all descendants
+	for i := 2; i < len(levels); i++ {
+
+		m, err := testRM.GetMember(levels[i].ID)
+		if !assert.Nil(t, err) {
+			continue
+		}
+
+		expected := make([]string, 0)
+		for _, l := range levels[2:i] {
+			expected = append(expected, l.ID)
+		}
+
+		assert.Equal(t, expected, m.RelationPath)
+	}
+
+	// Members above should stay untouched
+	m, err := testRM.GetMember(levels[1].ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{levels[0].ID}, m.RelationPath)
+	}
+}
+
+func prepareBenchmarkChain(b *testing.B, depth int) []string {
+
+	ids := make([]string, 0, depth)
+	prevLevel := ""
+	for i := 0; i < depth; i++ {
+
+		me := bursary.NewMemberEntry()
+		err := testRM.AddMembers([]*bursary.MemberEntry{
+			me,
+		}, prevLevel)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		ids = append(ids, me.ID)
+		prevLevel = me.ID
+	}
+
+	return ids
+}
+
+func prepareBenchmarkWide(b *testing.B, width int, breadth int) []string {
+
+	// Two levels below the agency: width agents with breadth members each
+	agency := bursary.NewMemberEntry()
+	err := testRM.AddMembers([]*bursary.MemberEntry{
+		agency,
+	}, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for i := 0; i < width; i++ {
+
+		agent := bursary.NewMemberEntry()
+		err := testRM.AddMembers([]*bursary.MemberEntry{
+			agent,
+		}, agency.ID)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		members := make([]*bursary.MemberEntry, 0, breadth)
+		for j := 0; j < breadth; j++ {
+			members = append(members, bursary.NewMemberEntry())
+		}
+
+		err = testRM.AddMembers(members, agent.ID)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	return []string{agency.ID}
+}
+
+func benchmarkMoveSubtree(b *testing.B, target string) {
+
+	// Another agency to move subtree back and forth
+	other := bursary.NewMemberEntry()
+	err := testRM.AddMembers([]*bursary.MemberEntry{
+		other,
+	}, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+
+		upstream := other.ID
+		if i%2 == 1 {
+			upstream = ""
+		}
+
+		err := testRM.MoveMembers([]string{target}, upstream)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_RelationManagerPostgres_MoveMembers_Deep(b *testing.B) {
+
+	defer uninit()
+
+	ids := prepareBenchmarkChain(b, 500)
+	benchmarkMoveSubtree(b, ids[1])
+}
+
+func Benchmark_RelationManagerPostgres_MoveMembers_Wide(b *testing.B) {
+
+	defer uninit()
+
+	ids := prepareBenchmarkWide(b, 100, 200)
+	benchmarkMoveSubtree(b, ids[0])
+}
+
+func Test_RelationManagerPostgres_Conformance(t *testing.T) {
+	bursarytest.RunRelationManagerSuite(t, func(t *testing.T) bursary.RelationManager {
+		t.Cleanup(uninit)
+		return testRM
+	})
+}
@@ -7,11 +7,27 @@ import (
 	"time"
 
 	"github.com/lib/pq"
+	"github.com/weedbox/bursary/v2"
 )
 
 type Rule struct {
-	Commission float64 `json:"commission"`
-	Share      float64 `json:"share"`
+	Commission    float64 `json:"commission"`
+	Share         float64 `json:"share"`
+	ReturnedShare float64 `json:"returned_share"`
+
+	TicketCap   int64          `json:"ticket_cap,omitempty"`
+	DailyCap    int64          `json:"daily_cap,omitempty"`
+	PeriodCap   int64          `json:"period_cap,omitempty"`
+	Period      bursary.Period `json:"period,omitempty"`
+	TicketFloor int64          `json:"ticket_floor,omitempty"`
+
+	Tiers      []*bursary.Tier    `json:"tiers,omitempty"`
+	TierVolume bursary.TierVolume `json:"tier_volume,omitempty"`
+
+	Fixed   float64            `json:"fixed,omitempty"`
+	Bonuses map[string]float64 `json:"bonuses,omitempty"`
+
+	Ceiling *bursary.RuleCeiling `json:"ceiling,omitempty"`
 }
 
 type ChannelRules map[string]*Rule
@@ -44,4 +60,7 @@ type MemberRecord struct {
 	RelationPath pq.StringArray `db:"relation_path"`
 	Upstream     string         `db:"upstream"`
 	CreatedAt    time.Time      `db:"created_at"`
+
+	// Depth is only selected when listing descendants
+	Depth int `db:"depth"`
 }
@@ -0,0 +1,43 @@
+package relation_manager_sqlite
+
+import (
+	"time"
+
+	"github.com/weedbox/bursary/v2"
+)
+
+func (mr *MemberRecord) ToMemberObject() *bursary.Member {
+
+	m := &bursary.Member{
+		ID:           mr.ID,
+		ChannelRules: make(map[string]*bursary.Rule),
+		RelationPath: mr.RelationPath,
+		Upstream:     mr.Upstream,
+		CreatedAt:    time.Unix(0, mr.CreatedAt),
+		Depth:        mr.Depth,
+	}
+
+	if m.RelationPath == nil {
+		m.RelationPath = make([]string, 0)
+	}
+
+	for channel, rule := range mr.ChannelRules {
+		m.ChannelRules[channel] = &bursary.Rule{
+			Commission:    rule.Commission,
+			Share:         rule.Share,
+			ReturnedShare: rule.ReturnedShare,
+			TicketCap:     rule.TicketCap,
+			DailyCap:      rule.DailyCap,
+			PeriodCap:     rule.PeriodCap,
+			Period:        rule.Period,
+			TicketFloor:   rule.TicketFloor,
+			Tiers:         rule.Tiers,
+			TierVolume:    rule.TierVolume,
+			Fixed:         rule.Fixed,
+			Bonuses:       rule.Bonuses,
+			Ceiling:       rule.Ceiling,
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,128 @@
+package relation_manager_sqlite
+
+import (
+	"strings"
+
+	"github.com/weedbox/bursary/v2"
+)
+
+// timeRangeFilter returns conditions on creation time to be appended to WHERE
+// clause along with their args.
+func timeRangeFilter(tr *bursary.TimeRange, args []interface{}) (string, []interface{}) {
+
+	if tr == nil {
+		return "", args
+	}
+
+	where := ""
+
+	if !tr.StartTime.IsZero() {
+		args = append(args, tr.StartTime.UnixNano())
+		where += ` AND created_at >= ?`
+	}
+
+	if !tr.EndTime.IsZero() {
+		args = append(args, tr.EndTime.UnixNano())
+		where += ` AND created_at <= ?`
+	}
+
+	return where, args
+}
+
+func sortColumn(field string) string {
+
+	switch field {
+	case bursary.SortByCreatedAt:
+		return "created_at"
+	case bursary.SortByDepth:
+		return "json_array_length(relation_path)"
+	}
+
+	return "id"
+}
+
+// orderBy returns ORDER BY clause for fields validated by bursary.MemberSort.
+func orderBy(fields []*bursary.SortField) string {
+
+	keys := make([]string, 0, len(fields))
+	for _, f := range fields {
+
+		if f.Ascending {
+			keys = append(keys, sortColumn(f.Field)+" ASC")
+		} else {
+			keys = append(keys, sortColumn(f.Field)+" DESC")
+		}
+	}
+
+	return strings.Join(keys, ", ")
+}
+
+// keysetFilter returns conditions selecting rows after cursor in order of
+// fields, to be appended to WHERE clause along with their args.
+func keysetFilter(fields []*bursary.SortField, after *bursary.Cursor, args []interface{}) (string, []interface{}) {
+
+	if after == nil {
+		return "", args
+	}
+
+	// (a > x) OR (a = x AND b > y) OR ...
+	terms := make([]string, 0, len(fields))
+	for i, f := range fields {
+
+		conds := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			conds = append(conds, sortColumn(fields[j].Field)+" = ?")
+			args = append(args, cursorValue(fields[j].Field, after))
+		}
+
+		op := " > ?"
+		if !f.Ascending {
+			op = " < ?"
+		}
+
+		conds = append(conds, sortColumn(f.Field)+op)
+		args = append(args, cursorValue(f.Field, after))
+
+		terms = append(terms, "("+strings.Join(conds, " AND ")+")")
+	}
+
+	return " AND (" + strings.Join(terms, " OR ") + ")", args
+}
+
+func cursorValue(field string, c *bursary.Cursor) interface{} {
+
+	switch field {
+	case bursary.SortByCreatedAt:
+		return c.CreatedAt.UnixNano()
+	case bursary.SortByDepth:
+		return c.Depth
+	}
+
+	return c.ID
+}
+
+// parseAfter decodes cursor of cond if there is one.
+func parseAfter(cond *bursary.Condition, fields []*bursary.SortField) (*bursary.Cursor, error) {
+
+	if len(cond.After) == 0 {
+		return nil, nil
+	}
+
+	return bursary.ParseCursor(cond.After, fields)
+}
+
+// nextPage trims the extra member fetched to find out whether there are more
+// members after the page, and sets NextCursor of cond accordingly.
+func nextPage(members []*bursary.Member, fields []*bursary.SortField, cond *bursary.Condition) []*bursary.Member {
+
+	cond.NextCursor = ""
+
+	if len(members) <= cond.Limit {
+		return members
+	}
+
+	members = members[:cond.Limit]
+	cond.NextCursor = bursary.NewMemberCursor(members[len(members)-1], fields).String()
+
+	return members
+}
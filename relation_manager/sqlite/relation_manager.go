@@ -0,0 +1,703 @@
+package relation_manager_sqlite
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/weedbox/bursary/v2"
+)
+
+type Opt func(*RelationManagerSQLite)
+
+type RelationManagerSQLite struct {
+	db        *sqlx.DB
+	tx        *sqlx.Tx
+	tableName string
+}
+
+func NewRelationManagerSQLite(opts ...Opt) *RelationManagerSQLite {
+	rm := &RelationManagerSQLite{}
+
+	for _, opt := range opts {
+		opt(rm)
+	}
+
+	if len(rm.tableName) == 0 {
+		rm.tableName = "relationships"
+	}
+
+	return rm
+}
+
+func WithDb(db *sqlx.DB) Opt {
+	return func(rm *RelationManagerSQLite) {
+		rm.db = db
+	}
+}
+
+func WithTableName(tableName string) Opt {
+	return func(rm *RelationManagerSQLite) {
+		rm.tableName = tableName
+	}
+}
+
+// WithTx returns a copy of the manager bound to an existing transaction. Every
+// operation of the copy runs inside tx, and committing or rolling back is left
+// to the caller.
+func (rm *RelationManagerSQLite) WithTx(tx *sqlx.Tx) *RelationManagerSQLite {
+	c := *rm
+	c.tx = tx
+	return &c
+}
+
+// RunInTx begins a new transaction, runs fn with a manager bound to it and
+// commits if fn succeeds. The transaction is rolled back if fn returns an error.
+func (rm *RelationManagerSQLite) RunInTx(fn func(tx *sqlx.Tx, rm *RelationManagerSQLite) error) error {
+	return rm.RunInTxContext(context.Background(), fn)
+}
+
+func (rm *RelationManagerSQLite) RunInTxContext(ctx context.Context, fn func(tx *sqlx.Tx, rm *RelationManagerSQLite) error) error {
+
+	if rm.tx != nil {
+		// Already running in a transaction owned by caller
+		return fn(rm.tx, rm)
+	}
+
+	tx, err := rm.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx, rm.WithTx(tx))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (rm *RelationManagerSQLite) ext() sqlx.ExtContext {
+
+	if rm.tx != nil {
+		return rm.tx
+	}
+
+	return rm.db
+}
+
+func (rm *RelationManagerSQLite) Init() error {
+
+	// Initializing table
+	stmts := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (
+			"id" TEXT NOT NULL,
+			"channel_rules" TEXT NOT NULL DEFAULT '{}',
+			"relation_path" TEXT NOT NULL DEFAULT '[]',
+			"upstream" TEXT NOT NULL DEFAULT '',
+			"created_at" INTEGER NOT NULL,
+			PRIMARY KEY ("id")
+		)`, rm.tableName),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s_upstream_idx" ON "%s" ("upstream")`, rm.tableName, rm.tableName),
+	}
+
+	tx, err := rm.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	for _, q := range stmts {
+		_, err := tx.Exec(q)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (rm *RelationManagerSQLite) Close() error {
+
+	// Connection is owned by whoever created the transaction
+	if rm.tx != nil {
+		return nil
+	}
+
+	return rm.db.Close()
+}
+
+func (rm *RelationManagerSQLite) getRecord(ctx context.Context, mid string) (*MemberRecord, error) {
+
+	cmd := fmt.Sprintf(`SELECT * FROM "%s" WHERE id = ?`, rm.tableName)
+	records := []MemberRecord{}
+	err := sqlx.SelectContext(ctx, rm.ext(), &records, cmd, mid)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, bursary.ErrMemberNotFound
+	}
+
+	return &records[0], nil
+}
+
+func (rm *RelationManagerSQLite) selectMembers(ctx context.Context, cmd string, args ...interface{}) ([]*bursary.Member, error) {
+
+	members := make([]*bursary.Member, 0)
+
+	rows, err := rm.ext().QueryxContext(ctx, cmd, args...)
+	if err != nil {
+		return members, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record := &MemberRecord{}
+		err := rows.StructScan(record)
+		if err != nil {
+			return members, err
+		}
+
+		members = append(members, record.ToMemberObject())
+	}
+
+	return members, rows.Err()
+}
+
+func (rm *RelationManagerSQLite) GetPath(mid string) ([]string, error) {
+	return rm.GetPathContext(context.Background(), mid)
+}
+
+func (rm *RelationManagerSQLite) GetPathContext(ctx context.Context, mid string) ([]string, error) {
+
+	if len(mid) == 0 {
+		return []string{}, nil
+	}
+
+	record, err := rm.getRecord(ctx, mid)
+	if err != nil {
+		return []string{}, err
+	}
+
+	p := make([]string, 0)
+	p = append(p, record.RelationPath...)
+	p = append(p, mid)
+
+	return p, nil
+}
+
+// rePath moves the member and its whole subtree by replacing the member's
+// relation path with newPath, keeping the rest of descendants' paths.
+func (rm *RelationManagerSQLite) rePath(ctx context.Context, mid string, upstream *string, newPath []string) error {
+
+	// Update member itself
+	var err error
+	if upstream != nil {
+		cmd := fmt.Sprintf(`UPDATE "%s" SET upstream = ?, relation_path = ? WHERE id = ?`, rm.tableName)
+		_, err = rm.ext().ExecContext(ctx, cmd, *upstream, RelationPath(newPath), mid)
+	} else {
+		cmd := fmt.Sprintf(`UPDATE "%s" SET relation_path = ? WHERE id = ?`, rm.tableName)
+		_, err = rm.ext().ExecContext(ctx, cmd, RelationPath(newPath), mid)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Find out all descendants
+	cmd := fmt.Sprintf(`SELECT id, relation_path FROM "%s"
+		WHERE EXISTS (SELECT 1 FROM json_each(relation_path) WHERE value = ?)`, rm.tableName)
+
+	records := []MemberRecord{}
+	err = sqlx.SelectContext(ctx, rm.ext(), &records, cmd, mid)
+	if err != nil {
+		return err
+	}
+
+	cmd = fmt.Sprintf(`UPDATE "%s" SET relation_path = ? WHERE id = ?`, rm.tableName)
+	for _, r := range records {
+		for i, id := range r.RelationPath {
+			if id != mid {
+				continue
+			}
+
+			p := make(RelationPath, 0, len(newPath)+len(r.RelationPath)-i)
+			p = append(p, newPath...)
+			p = append(p, r.RelationPath[i:]...)
+
+			_, err = rm.ext().ExecContext(ctx, cmd, p, r.ID)
+			if err != nil {
+				return err
+			}
+
+			break
+		}
+	}
+
+	return nil
+}
+
+func (rm *RelationManagerSQLite) ChangePath(mid string, newPath []string) error {
+	return rm.ChangePathContext(context.Background(), mid, newPath)
+}
+
+func (rm *RelationManagerSQLite) ChangePathContext(ctx context.Context, mid string, newPath []string) error {
+	return rm.RunInTxContext(ctx, func(tx *sqlx.Tx, rm *RelationManagerSQLite) error {
+		return rm.rePath(ctx, mid, nil, newPath)
+	})
+}
+
+func (rm *RelationManagerSQLite) GetMember(mid string) (*bursary.Member, error) {
+	return rm.GetMemberContext(context.Background(), mid)
+}
+
+func (rm *RelationManagerSQLite) GetMemberContext(ctx context.Context, mid string) (*bursary.Member, error) {
+
+	if len(mid) == 0 {
+		return nil, bursary.ErrMemberNotFound
+	}
+
+	record, err := rm.getRecord(ctx, mid)
+	if err != nil {
+		return nil, err
+	}
+
+	return record.ToMemberObject(), nil
+}
+
+func (rm *RelationManagerSQLite) AddMembers(members []*bursary.MemberEntry, upstream string) error {
+	return rm.AddMembersContext(context.Background(), members, upstream)
+}
+
+func (rm *RelationManagerSQLite) AddMembersContext(ctx context.Context, members []*bursary.MemberEntry, upstream string) error {
+
+	if len(members) == 0 {
+		return bursary.ErrMemberRequired
+	}
+
+	return rm.RunInTxContext(ctx, func(tx *sqlx.Tx, rm *RelationManagerSQLite) error {
+		return rm.addMembers(ctx, members, upstream)
+	})
+}
+
+func (rm *RelationManagerSQLite) addMembers(ctx context.Context, members []*bursary.MemberEntry, upstream string) error {
+
+	// Make sure that upstream exists
+	rp, err := rm.GetPathContext(ctx, upstream)
+	if err != nil {
+		return bursary.ErrUpstreamNotFound
+	}
+
+	// Current timestamp
+	ts := time.Now().UnixNano()
+
+	cmd := fmt.Sprintf(`INSERT INTO "%s" (
+			id,
+			channel_rules,
+			relation_path,
+			upstream,
+			created_at
+		) VALUES (
+			:id,
+			:channel_rules,
+			:relation_path,
+			:upstream,
+			:created_at
+		)`, rm.tableName)
+
+	for _, me := range members {
+
+		m := &MemberRecord{
+			ID:           me.ID,
+			ChannelRules: make(ChannelRules),
+			RelationPath: RelationPath(rp),
+			Upstream:     upstream,
+			CreatedAt:    ts,
+		}
+
+		for channel, cr := range me.ChannelRules {
+			m.ChannelRules[channel] = &Rule{
+				Commission:    cr.Commission,
+				Share:         cr.Share,
+				ReturnedShare: cr.ReturnedShare,
+				TicketCap:     cr.TicketCap,
+				DailyCap:      cr.DailyCap,
+				PeriodCap:     cr.PeriodCap,
+				Period:        cr.Period,
+				TicketFloor:   cr.TicketFloor,
+				Tiers:         cr.Tiers,
+				TierVolume:    cr.TierVolume,
+				Fixed:         cr.Fixed,
+				Bonuses:       cr.Bonuses,
+				Ceiling:       cr.Ceiling,
+			}
+		}
+
+		_, err = sqlx.NamedExecContext(ctx, rm.ext(), cmd, m)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (rm *RelationManagerSQLite) MoveMembers(mids []string, upstream string) error {
+	return rm.MoveMembersContext(context.Background(), mids, upstream)
+}
+
+func (rm *RelationManagerSQLite) MoveMembersContext(ctx context.Context, mids []string, upstream string) error {
+	return rm.RunInTxContext(ctx, func(tx *sqlx.Tx, rm *RelationManagerSQLite) error {
+
+		rp, err := rm.GetPathContext(ctx, upstream)
+		if err != nil {
+			return bursary.ErrUpstreamNotFound
+		}
+
+		// Member cannot be moved into its own subtree
+		for _, mid := range mids {
+			for _, id := range rp {
+				if id == mid {
+					return bursary.ErrInvalidUpstream
+				}
+			}
+		}
+
+		// update members and downstreams
+		for _, mid := range mids {
+			err = rm.rePath(ctx, mid, &upstream, rp)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (rm *RelationManagerSQLite) DeleteMembers(mids []string) error {
+	return rm.DeleteMembersContext(context.Background(), mids)
+}
+
+func (rm *RelationManagerSQLite) DeleteMembersContext(ctx context.Context, mids []string) error {
+
+	if len(mids) == 0 {
+		return nil
+	}
+
+	cmd, args, err := sqlx.In(fmt.Sprintf(`DELETE FROM "%s" WHERE id IN (?)`, rm.tableName), mids)
+	if err != nil {
+		return err
+	}
+
+	_, err = rm.ext().ExecContext(ctx, cmd, args...)
+
+	return err
+}
+
+func (rm *RelationManagerSQLite) GetUpstreams(mid string) ([]*bursary.Member, error) {
+	return rm.GetUpstreamsContext(context.Background(), mid)
+}
+
+func (rm *RelationManagerSQLite) GetUpstreamsContext(ctx context.Context, mid string) ([]*bursary.Member, error) {
+
+	// Keep the order of relation path which is from root to the closest upstream
+	cmd := fmt.Sprintf(`SELECT r.* FROM "%s" AS m
+		JOIN json_each(m.relation_path) AS p
+		JOIN "%s" AS r ON r.id = p.value
+		WHERE m.id = ?
+		ORDER BY p.key`, rm.tableName, rm.tableName)
+
+	return rm.selectMembers(ctx, cmd, mid)
+}
+
+func (rm *RelationManagerSQLite) ListMembers(upstream string, cond *bursary.Condition) ([]*bursary.Member, error) {
+	return rm.ListMembersContext(context.Background(), upstream, cond)
+}
+
+func (rm *RelationManagerSQLite) ListMembersContext(ctx context.Context, upstream string, cond *bursary.Condition) ([]*bursary.Member, error) {
+
+	if cond == nil {
+		cond = bursary.NewCondition()
+	}
+
+	if cond.Page < 1 {
+		cond.Page = 1
+	}
+
+	if cond.Limit < 1 {
+		cond.Limit = 1
+	}
+
+	fields, err := bursary.MemberSort(cond.Sort, &bursary.SortField{
+		Field:     bursary.SortByCreatedAt,
+		Ascending: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := parseAfter(cond, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	offset := (cond.Page - 1) * cond.Limit
+	if after != nil {
+		offset = 0
+	}
+
+	where, args := timeRangeFilter(cond.TimeRange, []interface{}{upstream})
+	keyset, args := keysetFilter(fields, after, args)
+
+	// One more member tells whether there is next page
+	args = append(args, cond.Limit+1, offset)
+
+	cmd := fmt.Sprintf(`SELECT * FROM "%s" WHERE upstream = ?%s%s ORDER BY %s LIMIT ? OFFSET ?`,
+		rm.tableName,
+		where,
+		keyset,
+		orderBy(fields),
+	)
+
+	members, err := rm.selectMembers(ctx, cmd, args...)
+	if err != nil {
+		return members, err
+	}
+
+	return nextPage(members, fields, cond), nil
+}
+
+// ListDescendants lists members below mid up to depth levels down, or its
+// whole downline if depth is 0.
+func (rm *RelationManagerSQLite) ListDescendants(mid string, depth int, cond *bursary.Condition) ([]*bursary.Member, error) {
+	return rm.ListDescendantsContext(context.Background(), mid, depth, cond)
+}
+
+func (rm *RelationManagerSQLite) ListDescendantsContext(ctx context.Context, mid string, depth int, cond *bursary.Condition) ([]*bursary.Member, error) {
+
+	if cond == nil {
+		cond = bursary.NewCondition()
+	}
+
+	if cond.Page < 1 {
+		cond.Page = 1
+	}
+
+	if cond.Limit < 1 {
+		cond.Limit = 1
+	}
+
+	_, err := rm.getRecord(ctx, mid)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := bursary.MemberSort(cond.Sort, &bursary.SortField{
+		Field:     bursary.SortByDepth,
+		Ascending: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := parseAfter(cond, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	offset := (cond.Page - 1) * cond.Limit
+	if after != nil {
+		offset = 0
+	}
+
+	where, args := timeRangeFilter(cond.TimeRange, []interface{}{mid, depth, depth})
+	keyset, args := keysetFilter(fields, after, args)
+
+	// One more member tells whether there is next page
+	args = append(args, cond.Limit+1, offset)
+
+	// Depth is the distance between member and mid in relation path
+	cmd := fmt.Sprintf(`SELECT * FROM (
+			SELECT m.*, json_array_length(m.relation_path) - p.key AS depth
+			FROM "%s" AS m
+			JOIN json_each(m.relation_path) AS p
+			WHERE p.value = ?
+		)
+		WHERE (? < 1 OR depth <= ?)%s%s
+		ORDER BY %s
+		LIMIT ? OFFSET ?`,
+		rm.tableName,
+		where,
+		keyset,
+		orderBy(fields),
+	)
+
+	members, err := rm.selectMembers(ctx, cmd, args...)
+	if err != nil {
+		return members, err
+	}
+
+	return nextPage(members, fields, cond), nil
+}
+
+func (rm *RelationManagerSQLite) CountDescendants(mid string) (int, error) {
+	return rm.CountDescendantsContext(context.Background(), mid)
+}
+
+func (rm *RelationManagerSQLite) CountDescendantsContext(ctx context.Context, mid string) (int, error) {
+
+	_, err := rm.getRecord(ctx, mid)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	cmd := fmt.Sprintf(`SELECT count(*) FROM "%s"
+		WHERE EXISTS (SELECT 1 FROM json_each(relation_path) WHERE value = ?)`, rm.tableName)
+	err = sqlx.GetContext(ctx, rm.ext(), &count, cmd, mid)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// updateRules applies fn to channel rules of the member, and saves the result.
+func (rm *RelationManagerSQLite) updateRules(ctx context.Context, record *MemberRecord, fn func(cr ChannelRules)) error {
+
+	if record.ChannelRules == nil {
+		record.ChannelRules = make(ChannelRules)
+	}
+
+	fn(record.ChannelRules)
+
+	cmd := fmt.Sprintf(`UPDATE "%s" SET channel_rules = ? WHERE id = ?`, rm.tableName)
+	_, err := rm.ext().ExecContext(ctx, cmd, record.ChannelRules, record.ID)
+
+	return err
+}
+
+func (rm *RelationManagerSQLite) UpdateChannelRule(mid string, channel string, rule *bursary.Rule) error {
+	return rm.UpdateChannelRuleContext(context.Background(), mid, channel, rule)
+}
+
+func (rm *RelationManagerSQLite) UpdateChannelRuleContext(ctx context.Context, mid string, channel string, rule *bursary.Rule) error {
+
+	if rule == nil {
+		return nil
+	}
+
+	return rm.RunInTxContext(ctx, func(tx *sqlx.Tx, rm *RelationManagerSQLite) error {
+
+		record, err := rm.getRecord(ctx, mid)
+		if err != nil {
+			return err
+		}
+
+		return rm.updateRules(ctx, record, func(cr ChannelRules) {
+			cr[channel] = &Rule{
+				Commission:    rule.Commission,
+				Share:         rule.Share,
+				ReturnedShare: rule.ReturnedShare,
+				TicketCap:     rule.TicketCap,
+				DailyCap:      rule.DailyCap,
+				PeriodCap:     rule.PeriodCap,
+				Period:        rule.Period,
+				TicketFloor:   rule.TicketFloor,
+				Tiers:         rule.Tiers,
+				TierVolume:    rule.TierVolume,
+				Fixed:         rule.Fixed,
+				Bonuses:       rule.Bonuses,
+				Ceiling:       rule.Ceiling,
+			}
+		})
+	})
+}
+
+func (rm *RelationManagerSQLite) UpdateChannelRules(channel string, rules map[string]*bursary.Rule) error {
+	return rm.UpdateChannelRulesContext(context.Background(), channel, rules)
+}
+
+func (rm *RelationManagerSQLite) UpdateChannelRulesContext(ctx context.Context, channel string, rules map[string]*bursary.Rule) error {
+
+	if len(rules) == 0 {
+		return nil
+	}
+
+	return rm.RunInTxContext(ctx, func(tx *sqlx.Tx, rm *RelationManagerSQLite) error {
+
+		for mid, rule := range rules {
+			err := rm.UpdateChannelRuleContext(ctx, mid, channel, rule)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (rm *RelationManagerSQLite) RemoveChannelRule(mid string, channel string) error {
+	return rm.RemoveChannelRuleContext(context.Background(), mid, channel)
+}
+
+func (rm *RelationManagerSQLite) RemoveChannelRuleContext(ctx context.Context, mid string, channel string) error {
+	return rm.RunInTxContext(ctx, func(tx *sqlx.Tx, rm *RelationManagerSQLite) error {
+
+		record, err := rm.getRecord(ctx, mid)
+		if err != nil {
+			return err
+		}
+
+		return rm.updateRules(ctx, record, func(cr ChannelRules) {
+			delete(cr, channel)
+		})
+	})
+}
+
+func (rm *RelationManagerSQLite) RemoveChannel(channel string) error {
+	return rm.RemoveChannelContext(context.Background(), channel)
+}
+
+func (rm *RelationManagerSQLite) RemoveChannelContext(ctx context.Context, channel string) error {
+	return rm.RunInTxContext(ctx, func(tx *sqlx.Tx, rm *RelationManagerSQLite) error {
+
+		// Find out members which have rule for the channel or the branch
+		cmd := fmt.Sprintf(`SELECT * FROM "%s"
+			WHERE EXISTS (SELECT 1 FROM json_each(channel_rules) WHERE key = ?)`, rm.tableName)
+		args := []interface{}{channel}
+
+		if branch, ok := bursary.ChannelBranch(channel); ok {
+			prefix := branch + bursary.ChannelSeparator
+			cmd = fmt.Sprintf(`SELECT * FROM "%s"
+				WHERE EXISTS (SELECT 1 FROM json_each(channel_rules) WHERE key = ? OR substr(key, 1, length(?)) = ?)`, rm.tableName)
+			args = []interface{}{branch, prefix, prefix}
+		}
+
+		records := []MemberRecord{}
+		err := sqlx.SelectContext(ctx, rm.ext(), &records, cmd, args...)
+		if err != nil {
+			return err
+		}
+
+		for i := range records {
+			err := rm.updateRules(ctx, &records[i], func(cr ChannelRules) {
+				for c := range cr {
+					if bursary.MatchChannel(channel, c) {
+						delete(cr, c)
+					}
+				}
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,175 @@
+package relation_manager_sqlite
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/weedbox/bursary/v2"
+	"github.com/weedbox/bursary/v2/bursarytest"
+	ledger_sqlite "github.com/weedbox/bursary/v2/ledger/sqlite"
+
+	_ "modernc.org/sqlite"
+)
+
+func newTestDb(t *testing.T) *sqlx.DB {
+
+	db, err := sqlx.Open("sqlite", filepath.Join(t.TempDir(), "bursary.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	return db
+}
+
+func newTestRelationManager(t *testing.T, db *sqlx.DB) *RelationManagerSQLite {
+
+	rm := NewRelationManagerSQLite(
+		WithDb(db),
+	)
+
+	err := rm.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rm
+}
+
+func Test_RelationManagerSQLite_Conformance(t *testing.T) {
+	bursarytest.RunRelationManagerSuite(t, func(t *testing.T) bursary.RelationManager {
+		return newTestRelationManager(t, newTestDb(t))
+	})
+}
+
+func Test_RelationManagerSQLite_WithTx(t *testing.T) {
+
+	db := newTestDb(t)
+	rm := newTestRelationManager(t, db)
+
+	me := bursary.NewMemberEntry()
+
+	// Rollback should discard everything done in transaction
+	tx, err := db.Beginx()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	err = rm.WithTx(tx).AddMembers([]*bursary.MemberEntry{
+		me,
+	}, "")
+	if !assert.Nil(t, err) {
+		tx.Rollback()
+		return
+	}
+
+	err = tx.Rollback()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	_, err = rm.GetMember(me.ID)
+	assert.Equal(t, bursary.ErrMemberNotFound, err)
+}
+
+func Test_RelationManagerSQLite_Bursary(t *testing.T) {
+
+	db := newTestDb(t)
+
+	// Relationship and general ledger are in the same database
+	gl := ledger_sqlite.NewLedgerSQLite(
+		ledger_sqlite.WithDb(db),
+	)
+
+	err := gl.Init()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	bu := bursary.NewBursary(
+		bursary.WithRelationManager(newTestRelationManager(t, db)),
+		bursary.WithGeneralLedger(gl),
+	)
+
+	levels := []*bursary.MemberEntry{
+		bursary.NewMemberEntry(),
+		bursary.NewMemberEntry(),
+		bursary.NewMemberEntry(),
+	}
+
+	levels[0].ChannelRules["default"] = &bursary.Rule{
+		Commission: 1.0,
+		Share:      1.0,
+	}
+
+	levels[1].ChannelRules["default"] = &bursary.Rule{
+		Commission: 0.7,
+		Share:      0.9,
+	}
+
+	levels[2].ChannelRules["default"] = &bursary.Rule{
+		Commission: 0.5,
+		Share:      0.3,
+	}
+
+	prevLevel := ""
+	for _, l := range levels {
+		err := bu.RelationManager().AddMembers([]*bursary.MemberEntry{
+			l,
+		}, prevLevel)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		prevLevel = l.ID
+	}
+
+	// Preparing a new ticket
+	ticket := bursary.NewTicket()
+	ticket.Channel = "default"
+	ticket.MemberID = levels[2].ID
+	ticket.Amount = 1000
+	ticket.Fee = 50
+	ticket.Total = 1050
+
+	err = bu.WriteTicket(ticket)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Answer
+	ans := []map[string]int64{
+		// level 1
+		map[string]int64{
+			"commission": 15,
+			"gain":       100,
+		},
+		// level 2
+		map[string]int64{
+			"commission": 10,
+			"gain":       600,
+		},
+		// level 3
+		map[string]int64{
+			"commission": 25,
+			"gain":       300,
+		},
+	}
+
+	for i, l := range levels {
+		records, err := bu.GeneralLedger().ReadRecordsByMemberID(l.ID, bursary.NewCondition())
+		if !assert.Nil(t, err) || !assert.Len(t, records, 1) {
+			continue
+		}
+
+		a := ans[i]
+		assert.Equal(t, a["commission"], records[0].Commissions)
+		assert.Equal(t, a["gain"], records[0].Gain)
+		assert.Equal(t, ticket.ID, records[0].PrimaryID)
+	}
+}
@@ -0,0 +1,118 @@
+package relation_manager_sqlite
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+
+	"github.com/weedbox/bursary/v2"
+)
+
+type Rule struct {
+	Commission    float64 `json:"commission"`
+	Share         float64 `json:"share"`
+	ReturnedShare float64 `json:"returned_share"`
+
+	TicketCap   int64          `json:"ticket_cap,omitempty"`
+	DailyCap    int64          `json:"daily_cap,omitempty"`
+	PeriodCap   int64          `json:"period_cap,omitempty"`
+	Period      bursary.Period `json:"period,omitempty"`
+	TicketFloor int64          `json:"ticket_floor,omitempty"`
+
+	Tiers      []*bursary.Tier    `json:"tiers,omitempty"`
+	TierVolume bursary.TierVolume `json:"tier_volume,omitempty"`
+
+	Fixed   float64            `json:"fixed,omitempty"`
+	Bonuses map[string]float64 `json:"bonuses,omitempty"`
+
+	Ceiling *bursary.RuleCeiling `json:"ceiling,omitempty"`
+}
+
+type ChannelRules map[string]*Rule
+
+func (cr ChannelRules) Value() (driver.Value, error) {
+
+	data, err := json.Marshal(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+func (cr *ChannelRules) Scan(src interface{}) error {
+
+	source, err := jsonSource(src)
+	if err != nil {
+		return err
+	}
+
+	r := make(ChannelRules)
+	err = json.Unmarshal(source, &r)
+	if err != nil {
+		return err
+	}
+
+	*cr = r
+
+	return nil
+}
+
+type RelationPath []string
+
+func (rp RelationPath) Value() (driver.Value, error) {
+
+	if rp == nil {
+		rp = RelationPath{}
+	}
+
+	data, err := json.Marshal(rp)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+func (rp *RelationPath) Scan(src interface{}) error {
+
+	source, err := jsonSource(src)
+	if err != nil {
+		return err
+	}
+
+	p := make(RelationPath, 0)
+	err = json.Unmarshal(source, &p)
+	if err != nil {
+		return err
+	}
+
+	*rp = p
+
+	return nil
+}
+
+func jsonSource(src interface{}) ([]byte, error) {
+
+	switch v := src.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case nil:
+		return []byte("null"), nil
+	}
+
+	return nil, errors.New("Type assertion .([]byte) failed.")
+}
+
+type MemberRecord struct {
+	ID           string       `db:"id"`
+	ChannelRules ChannelRules `db:"channel_rules"`
+	RelationPath RelationPath `db:"relation_path"`
+	Upstream     string       `db:"upstream"`
+	CreatedAt    int64        `db:"created_at"`
+
+	// Depth is only selected when listing descendants
+	Depth int `db:"depth"`
+}
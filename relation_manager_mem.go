@@ -1,26 +1,271 @@
 package bursary
 
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
 type relationManagerMemory struct {
+	mutex   sync.RWMutex
 	members map[string]*Member
+	wal     *WAL
+
+	// children indexes direct downstreams by upstream ID
+	children map[string]map[string]struct{}
+}
+
+type RelationManagerMemoryOpt func(*relationManagerMemory)
+
+// relationOp is the record of a change written to WAL.
+type relationOp struct {
+	Members  []*MemberEntry `json:"members,omitempty"`
+	Mids     []string       `json:"mids,omitempty"`
+	Mid      string         `json:"mid,omitempty"`
+	Upstream string         `json:"upstream,omitempty"`
+	Path     []string       `json:"path,omitempty"`
+	Channel  string         `json:"channel,omitempty"`
+	Rule     *Rule          `json:"rule,omitempty"`
+
+	Rules map[string]*Rule `json:"rules,omitempty"`
+
+	// CreatedAt is kept so that members get the same time when replaying
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+type relationSnapshot struct {
+	snapshotHeader
+	Members []*Member `json:"members"`
+}
+
+func NewRelationManagerMemory(opts ...RelationManagerMemoryOpt) RelationManager {
+
+	rm := &relationManagerMemory{
+		members:  make(map[string]*Member),
+		children: make(map[string]map[string]struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(rm)
+	}
+
+	return rm
+}
+
+// WithRelationManagerWAL makes every change to be logged to wal before it is applied.
+func WithRelationManagerWAL(wal *WAL) RelationManagerMemoryOpt {
+	return func(rm *relationManagerMemory) {
+		rm.wal = wal
+	}
 }
 
-func NewRelationManagerMemory() RelationManager {
-	return &relationManagerMemory{
-		members: make(map[string]*Member),
+// RecoverRelationManagerMemory rebuilds a memory relation manager from the
+// latest snapshot and the changes logged to wal after it. Snapshot can be nil
+// if it was never taken. Further changes keep being logged to wal.
+func RecoverRelationManagerMemory(snapshot io.Reader, wal *WAL) (RelationManager, error) {
+
+	rm := &relationManagerMemory{
+		members:  make(map[string]*Member),
+		children: make(map[string]map[string]struct{}),
+	}
+
+	if snapshot != nil {
+		if err := rm.Restore(snapshot); err != nil {
+			return nil, err
+		}
 	}
+
+	err := wal.Replay(func(op string, data json.RawMessage) error {
+
+		var o relationOp
+		if err := json.Unmarshal(data, &o); err != nil {
+			return err
+		}
+
+		// Operations which failed originally fail the same way again
+		rm.apply(op, &o)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rm.wal = wal
+
+	return rm, nil
 }
 
 func (rm *relationManagerMemory) Close() error {
 	return nil
 }
 
-func (rm *relationManagerMemory) GetPath(mid string) ([]string, error) {
+// change logs operation to WAL and applies it while holding the lock.
+func (rm *relationManagerMemory) change(ctx context.Context, op string, o *relationOp) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	if rm.wal != nil {
+		if err := rm.wal.Append(op, o); err != nil {
+			return err
+		}
+	}
+
+	return rm.apply(op, o)
+}
+
+func (rm *relationManagerMemory) apply(op string, o *relationOp) error {
+
+	switch op {
+	case "add_members":
+		return rm.addMembers(o.Members, o.Upstream, o.CreatedAt)
+	case "change_path":
+		return rm.changePath(o.Mid, o.Path)
+	case "move_members":
+		return rm.moveMembers(o.Mids, o.Upstream)
+	case "delete_members":
+		return rm.deleteMembers(o.Mids)
+	case "update_channel_rule":
+		return rm.updateChannelRule(o.Mid, o.Channel, o.Rule)
+	case "update_channel_rules":
+		return rm.updateChannelRules(o.Channel, o.Rules)
+	case "remove_channel_rule":
+		return rm.removeChannelRule(o.Mid, o.Channel)
+	case "remove_channel":
+		return rm.removeChannel(o.Channel)
+	}
+
+	return nil
+}
+
+func (rm *relationManagerMemory) Snapshot(w io.Writer) error {
+
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	return rm.snapshot(w)
+}
+
+func (rm *relationManagerMemory) snapshot(w io.Writer) error {
+
+	s := &relationSnapshot{
+		snapshotHeader: snapshotHeader{
+			Version: SnapshotVersion,
+			Kind:    "relation_manager",
+		},
+		Members: make([]*Member, 0, len(rm.members)),
+	}
+
+	for _, m := range rm.members {
+		s.Members = append(s.Members, m)
+	}
+
+	sort.Slice(s.Members, func(i, j int) bool {
+		return s.Members[i].ID < s.Members[j].ID
+	})
+
+	return json.NewEncoder(w).Encode(s)
+}
+
+func (rm *relationManagerMemory) Restore(r io.Reader) error {
+
+	var s relationSnapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return err
+	}
+
+	if err := s.check("relation_manager"); err != nil {
+		return err
+	}
+
+	members := make(map[string]*Member)
+	children := make(map[string]map[string]struct{})
+	for _, m := range s.Members {
+
+		if m.ChannelRules == nil {
+			m.ChannelRules = make(map[string]*Rule)
+		}
+
+		if m.RelationPath == nil {
+			m.RelationPath = make([]string, 0)
+		}
+
+		members[m.ID] = m
+
+		if _, ok := children[m.Upstream]; !ok {
+			children[m.Upstream] = make(map[string]struct{})
+		}
+
+		children[m.Upstream][m.ID] = struct{}{}
+	}
+
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	rm.members = members
+	rm.children = children
+
+	return nil
+}
+
+func (rm *relationManagerMemory) Checkpoint(w io.Writer) error {
+
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	if err := rm.snapshot(w); err != nil {
+		return err
+	}
+
+	if rm.wal == nil {
+		return nil
+	}
+
+	return rm.wal.Truncate()
+}
+
+func (rm *relationManagerMemory) getMember(mid string) (*Member, error) {
+
+	if m, ok := rm.members[mid]; ok {
+		return m, nil
+	}
+
+	return nil, ErrMemberNotFound
+}
+
+func (rm *relationManagerMemory) link(mid string, upstream string) {
+
+	if _, ok := rm.children[upstream]; !ok {
+		rm.children[upstream] = make(map[string]struct{})
+	}
+
+	rm.children[upstream][mid] = struct{}{}
+}
+
+func (rm *relationManagerMemory) unlink(mid string, upstream string) {
+
+	delete(rm.children[upstream], mid)
+
+	if len(rm.children[upstream]) == 0 {
+		delete(rm.children, upstream)
+	}
+}
+
+func (rm *relationManagerMemory) getPath(mid string) ([]string, error) {
 
 	p := make([]string, 0)
 	if len(mid) != 0 {
 
 		// find upstream to get relation path
-		usm, err := rm.GetMember(mid)
+		usm, err := rm.getMember(mid)
 		if err != nil {
 			return p, ErrUpstreamNotFound
 		}
@@ -32,30 +277,87 @@ func (rm *relationManagerMemory) GetPath(mid string) ([]string, error) {
 	return p, nil
 }
 
+func (rm *relationManagerMemory) GetPath(mid string) ([]string, error) {
+	return rm.GetPathContext(context.Background(), mid)
+}
+
+func (rm *relationManagerMemory) GetPathContext(ctx context.Context, mid string) ([]string, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	return rm.getPath(mid)
+}
+
 func (rm *relationManagerMemory) ChangePath(mid string, newPath []string) error {
+	return rm.ChangePathContext(context.Background(), mid, newPath)
+}
 
-	m, err := rm.GetMember(mid)
+func (rm *relationManagerMemory) ChangePathContext(ctx context.Context, mid string, newPath []string) error {
+	return rm.change(ctx, "change_path", &relationOp{
+		Mid:  mid,
+		Path: newPath,
+	})
+}
+
+func (rm *relationManagerMemory) changePath(mid string, newPath []string) error {
+
+	m, err := rm.getMember(mid)
 	if err != nil {
 		return ErrMemberNotFound
 	}
 
-	m.RelationPath = newPath
+	m.RelationPath = make([]string, len(newPath))
+	copy(m.RelationPath, newPath)
 
 	return nil
 }
 
 func (rm *relationManagerMemory) GetMember(mid string) (*Member, error) {
+	return rm.GetMemberContext(context.Background(), mid)
+}
 
-	if m, ok := rm.members[mid]; ok {
-		return m, nil
+func (rm *relationManagerMemory) GetMemberContext(ctx context.Context, mid string) (*Member, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	return nil, ErrMemberNotFound
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	m, err := rm.getMember(mid)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.clone(), nil
 }
 
 func (rm *relationManagerMemory) AddMembers(members []*MemberEntry, upstream string) error {
+	return rm.AddMembersContext(context.Background(), members, upstream)
+}
+
+func (rm *relationManagerMemory) AddMembersContext(ctx context.Context, members []*MemberEntry, upstream string) error {
+
+	if len(members) == 0 {
+		return ErrMemberRequired
+	}
+
+	return rm.change(ctx, "add_members", &relationOp{
+		Members:   members,
+		Upstream:  upstream,
+		CreatedAt: time.Now().UTC(),
+	})
+}
 
-	rp, err := rm.GetPath(upstream)
+func (rm *relationManagerMemory) addMembers(members []*MemberEntry, upstream string, createdAt time.Time) error {
+
+	rp, err := rm.getPath(upstream)
 	if err != nil {
 		return ErrUpstreamNotFound
 	}
@@ -64,41 +366,81 @@ func (rm *relationManagerMemory) AddMembers(members []*MemberEntry, upstream str
 
 		m := &Member{
 			ID:           me.ID,
-			ChannelRules: me.ChannelRules,
+			ChannelRules: make(map[string]*Rule),
 			RelationPath: rp,
 			Upstream:     upstream,
+			CreatedAt:    createdAt,
+		}
+
+		// Rules are copied so that changes by caller won't affect stored members
+		for channel, r := range me.ChannelRules {
+			m.ChannelRules[channel] = r.clone()
+		}
+
+		// Member with the same ID is replaced
+		if old, ok := rm.members[m.ID]; ok {
+			rm.unlink(old.ID, old.Upstream)
 		}
 
 		rm.members[m.ID] = m
+		rm.link(m.ID, upstream)
 	}
 
 	return nil
 }
 
 func (rm *relationManagerMemory) MoveMembers(mids []string, upstream string) error {
+	return rm.MoveMembersContext(context.Background(), mids, upstream)
+}
+
+func (rm *relationManagerMemory) MoveMembersContext(ctx context.Context, mids []string, upstream string) error {
+	return rm.change(ctx, "move_members", &relationOp{
+		Mids:     mids,
+		Upstream: upstream,
+	})
+}
+
+func (rm *relationManagerMemory) moveMembers(mids []string, upstream string) error {
 
 	// Getting all members
 	for _, mid := range mids {
 
-		m, err := rm.GetMember(mid)
+		m, err := rm.getMember(mid)
 		if err != nil {
 			return err
 		}
 
-		rp, err := rm.GetPath(upstream)
+		rp, err := rm.getPath(upstream)
 		if err != nil {
 			return ErrUpstreamNotFound
 		}
 
+		// Member cannot be moved into its own subtree
+		for _, id := range rp {
+			if id == mid {
+				return ErrInvalidUpstream
+			}
+		}
+
+		rm.unlink(mid, m.Upstream)
+		rm.link(mid, upstream)
+
 		m.Upstream = upstream
 		m.RelationPath = rp
 
-		curPath := append(rp, mid)
-
-		// find and update downstreams
+		// find and update all descendants
 		for _, ds := range rm.members {
-			if ds.Upstream == m.ID {
-				rm.ChangePath(ds.ID, curPath)
+			for i, id := range ds.RelationPath {
+				if id != mid {
+					continue
+				}
+
+				p := make([]string, 0, len(rp)+len(ds.RelationPath)-i)
+				p = append(p, rp...)
+				p = append(p, ds.RelationPath[i:]...)
+				ds.RelationPath = p
+
+				break
 			}
 		}
 	}
@@ -107,8 +449,25 @@ func (rm *relationManagerMemory) MoveMembers(mids []string, upstream string) err
 }
 
 func (rm *relationManagerMemory) DeleteMembers(mids []string) error {
+	return rm.DeleteMembersContext(context.Background(), mids)
+}
+
+func (rm *relationManagerMemory) DeleteMembersContext(ctx context.Context, mids []string) error {
+	return rm.change(ctx, "delete_members", &relationOp{
+		Mids: mids,
+	})
+}
+
+func (rm *relationManagerMemory) deleteMembers(mids []string) error {
 
 	for _, mid := range mids {
+
+		m, ok := rm.members[mid]
+		if !ok {
+			continue
+		}
+
+		rm.unlink(mid, m.Upstream)
 		delete(rm.members, mid)
 	}
 
@@ -116,10 +475,21 @@ func (rm *relationManagerMemory) DeleteMembers(mids []string) error {
 }
 
 func (rm *relationManagerMemory) GetUpstreams(mid string) ([]*Member, error) {
+	return rm.GetUpstreamsContext(context.Background(), mid)
+}
+
+func (rm *relationManagerMemory) GetUpstreamsContext(ctx context.Context, mid string) ([]*Member, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
 
 	members := make([]*Member, 0)
 
-	m, err := rm.GetMember(mid)
+	m, err := rm.getMember(mid)
 	if err != nil {
 		return members, err
 	}
@@ -127,18 +497,30 @@ func (rm *relationManagerMemory) GetUpstreams(mid string) ([]*Member, error) {
 	// Getting all members according to relation path
 	for _, usID := range m.RelationPath {
 
-		usm, err := rm.GetMember(usID)
+		usm, err := rm.getMember(usID)
 		if err != nil {
 			return nil, err
 		}
 
-		members = append(members, usm)
+		members = append(members, usm.clone())
 	}
 
 	return members, nil
 }
 
-func (rm *relationManagerMemory) ListMembers(upstream string, cond *Condition) ([]*Member, error) {
+func (rm *relationManagerMemory) ListDescendants(mid string, depth int, cond *Condition) ([]*Member, error) {
+	return rm.ListDescendantsContext(context.Background(), mid, depth, cond)
+}
+
+func (rm *relationManagerMemory) ListDescendantsContext(ctx context.Context, mid string, depth int, cond *Condition) ([]*Member, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if cond == nil {
+		cond = NewCondition()
+	}
 
 	if cond.Page < 1 {
 		cond.Page = 1
@@ -148,50 +530,217 @@ func (rm *relationManagerMemory) ListMembers(upstream string, cond *Condition) (
 		cond.Limit = 1
 	}
 
-	start := (cond.Page - 1) * cond.Limit
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	if _, err := rm.getMember(mid); err != nil {
+		return nil, err
+	}
+
+	fields, err := MemberSort(cond.Sort, &SortField{
+		Field:     SortByDepth,
+		Ascending: true,
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	members := make([]*Member, 0)
+	rm.walkDescendants(mid, depth, func(m *Member, d int) {
+
+		if !cond.TimeRange.Contains(m.CreatedAt) {
+			return
+		}
+
+		c := m.clone()
+		c.Depth = d
+		members = append(members, c)
+	})
+
+	return pageMembers(members, fields, cond)
+}
+
+func (rm *relationManagerMemory) CountDescendants(mid string) (int, error) {
+	return rm.CountDescendantsContext(context.Background(), mid)
+}
+
+func (rm *relationManagerMemory) CountDescendantsContext(ctx context.Context, mid string) (int, error) {
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	if _, err := rm.getMember(mid); err != nil {
+		return 0, err
+	}
 
 	count := 0
-	cur := 0
-	for _, m := range rm.members {
+	rm.walkDescendants(mid, 0, func(m *Member, d int) {
+		count++
+	})
 
-		if m.Upstream != upstream {
-			continue
-		}
+	return count, nil
+}
 
-		if cur < start {
-			cur++
-			continue
+// walkDescendants visits downline of mid level by level, and members of the
+// same level in order of ID. Depth less than 1 means no limit.
+func (rm *relationManagerMemory) walkDescendants(mid string, depth int, fn func(m *Member, d int)) {
+
+	level := []string{mid}
+	for d := 1; len(level) > 0 && (depth < 1 || d <= depth); d++ {
+
+		next := make([]string, 0)
+		for _, id := range level {
+			for cid := range rm.children[id] {
+				next = append(next, cid)
+			}
 		}
 
-		if count+1 > cond.Limit {
-			break
+		sort.Strings(next)
+
+		for _, id := range next {
+			fn(rm.members[id], d)
 		}
 
-		members = append(members, m)
+		level = next
+	}
+}
 
-		count++
+func (rm *relationManagerMemory) ListMembers(upstream string, cond *Condition) ([]*Member, error) {
+	return rm.ListMembersContext(context.Background(), upstream, cond)
+}
+
+func (rm *relationManagerMemory) ListMembersContext(ctx context.Context, upstream string, cond *Condition) ([]*Member, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	return members, nil
+	if cond == nil {
+		cond = NewCondition()
+	}
+
+	if cond.Page < 1 {
+		cond.Page = 1
+	}
+
+	if cond.Limit < 1 {
+		cond.Limit = 1
+	}
+
+	fields, err := MemberSort(cond.Sort, &SortField{
+		Field:     SortByCreatedAt,
+		Ascending: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	members := make([]*Member, 0)
+	for mid := range rm.children[upstream] {
+
+		m := rm.members[mid]
+		if !cond.TimeRange.Contains(m.CreatedAt) {
+			continue
+		}
+
+		members = append(members, m.clone())
+	}
+
+	return pageMembers(members, fields, cond)
 }
 
 func (rm *relationManagerMemory) UpdateChannelRule(mid string, channel string, rule *Rule) error {
+	return rm.UpdateChannelRuleContext(context.Background(), mid, channel, rule)
+}
+
+func (rm *relationManagerMemory) UpdateChannelRuleContext(ctx context.Context, mid string, channel string, rule *Rule) error {
 
-	m, err := rm.GetMember(mid)
+	if rule == nil {
+		return nil
+	}
+
+	return rm.change(ctx, "update_channel_rule", &relationOp{
+		Mid:     mid,
+		Channel: channel,
+		Rule:    rule,
+	})
+}
+
+func (rm *relationManagerMemory) updateChannelRule(mid string, channel string, rule *Rule) error {
+
+	m, err := rm.getMember(mid)
 	if err != nil {
 		return err
 	}
 
-	m.ChannelRules[channel] = rule
+	if m.ChannelRules == nil {
+		m.ChannelRules = make(map[string]*Rule)
+	}
+
+	m.ChannelRules[channel] = rule.clone()
+
+	return nil
+}
+
+func (rm *relationManagerMemory) UpdateChannelRules(channel string, rules map[string]*Rule) error {
+	return rm.UpdateChannelRulesContext(context.Background(), channel, rules)
+}
+
+func (rm *relationManagerMemory) UpdateChannelRulesContext(ctx context.Context, channel string, rules map[string]*Rule) error {
+
+	if len(rules) == 0 {
+		return nil
+	}
+
+	return rm.change(ctx, "update_channel_rules", &relationOp{
+		Channel: channel,
+		Rules:   rules,
+	})
+}
+
+func (rm *relationManagerMemory) updateChannelRules(channel string, rules map[string]*Rule) error {
+
+	// Nothing is changed unless all members exist
+	for mid := range rules {
+		if _, err := rm.getMember(mid); err != nil {
+			return err
+		}
+	}
+
+	for mid, rule := range rules {
+		if rule == nil {
+			continue
+		}
+
+		if err := rm.updateChannelRule(mid, channel, rule); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
 func (rm *relationManagerMemory) RemoveChannelRule(mid string, channel string) error {
+	return rm.RemoveChannelRuleContext(context.Background(), mid, channel)
+}
 
-	m, err := rm.GetMember(mid)
+func (rm *relationManagerMemory) RemoveChannelRuleContext(ctx context.Context, mid string, channel string) error {
+	return rm.change(ctx, "remove_channel_rule", &relationOp{
+		Mid:     mid,
+		Channel: channel,
+	})
+}
+
+func (rm *relationManagerMemory) removeChannelRule(mid string, channel string) error {
+
+	m, err := rm.getMember(mid)
 	if err != nil {
 		return err
 	}
@@ -202,11 +751,80 @@ func (rm *relationManagerMemory) RemoveChannelRule(mid string, channel string) e
 }
 
 func (rm *relationManagerMemory) RemoveChannel(channel string) error {
+	return rm.RemoveChannelContext(context.Background(), channel)
+}
 
-	// Remove specific channel rule from all members
+func (rm *relationManagerMemory) RemoveChannelContext(ctx context.Context, channel string) error {
+	return rm.change(ctx, "remove_channel", &relationOp{
+		Channel: channel,
+	})
+}
+
+func (rm *relationManagerMemory) removeChannel(channel string) error {
+
+	// Remove rules of channel or branch from all members
 	for _, m := range rm.members {
-		delete(m.ChannelRules, channel)
+		for c := range m.ChannelRules {
+			if MatchChannel(channel, c) {
+				delete(m.ChannelRules, c)
+			}
+		}
 	}
 
 	return nil
 }
+
+// pageMembers sorts members by fields and returns the page requested by
+// cond. NextCursor of cond is set if there are more members after the page.
+func pageMembers(members []*Member, fields []*SortField, cond *Condition) ([]*Member, error) {
+
+	type item struct {
+		m *Member
+		c *Cursor
+	}
+
+	items := make([]item, 0, len(members))
+	for _, m := range members {
+		items = append(items, item{
+			m: m,
+			c: NewMemberCursor(m, fields),
+		})
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].c.Compare(items[j].c, fields) < 0
+	})
+
+	start := (cond.Page - 1) * cond.Limit
+	if len(cond.After) > 0 {
+
+		after, err := ParseCursor(cond.After, fields)
+		if err != nil {
+			return nil, err
+		}
+
+		start = sort.Search(len(items), func(i int) bool {
+			return items[i].c.Compare(after, fields) > 0
+		})
+	}
+
+	cond.NextCursor = ""
+
+	page := make([]*Member, 0)
+	if start >= len(items) {
+		return page, nil
+	}
+
+	end := start + cond.Limit
+	if end < len(items) {
+		cond.NextCursor = items[end-1].c.String()
+	} else {
+		end = len(items)
+	}
+
+	for _, it := range items[start:end] {
+		page = append(page, it.m)
+	}
+
+	return page, nil
+}
@@ -0,0 +1,644 @@
+package bursary
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// relationManagerOverlay applies changes on top of a base relation manager
+// without writing them to it. Members are copied from base when they're
+// changed for the first time, and reads of other members go to base.
+type relationManagerOverlay struct {
+	base  RelationManager
+	mutex sync.RWMutex
+
+	// members are copies of changed members, which replace those of base
+	members map[string]*Member
+	deleted map[string]struct{}
+
+	// removedChannels are patterns of channels removed from all members
+	removedChannels []string
+}
+
+// NewRelationManagerOverlay returns a relation manager which reads from base,
+// while keeping all changes to itself. Base is never written to, and closing
+// the overlay leaves it open.
+func NewRelationManagerOverlay(base RelationManager) RelationManager {
+	return &relationManagerOverlay{
+		base:    base,
+		members: make(map[string]*Member),
+		deleted: make(map[string]struct{}),
+	}
+}
+
+func (rm *relationManagerOverlay) Close() error {
+	return nil
+}
+
+// strip removes rules of removed channels from member of base.
+func (rm *relationManagerOverlay) strip(m *Member) *Member {
+
+	for _, pattern := range rm.removedChannels {
+		for c := range m.ChannelRules {
+			if MatchChannel(pattern, c) {
+				delete(m.ChannelRules, c)
+			}
+		}
+	}
+
+	return m
+}
+
+// get returns member from the overlay, or from base if it's unchanged.
+func (rm *relationManagerOverlay) get(ctx context.Context, mid string) (*Member, error) {
+
+	if _, ok := rm.deleted[mid]; ok {
+		return nil, ErrMemberNotFound
+	}
+
+	if m, ok := rm.members[mid]; ok {
+		return m, nil
+	}
+
+	m, err := rm.base.GetMemberContext(ctx, mid)
+	if err != nil {
+		return nil, err
+	}
+
+	return rm.strip(m), nil
+}
+
+// own returns the copy of member in the overlay, copying it from base first
+// if needed.
+func (rm *relationManagerOverlay) own(ctx context.Context, mid string) (*Member, error) {
+
+	m, err := rm.get(ctx, mid)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.ChannelRules == nil {
+		m.ChannelRules = make(map[string]*Rule)
+	}
+
+	rm.members[mid] = m
+
+	return m, nil
+}
+
+func (rm *relationManagerOverlay) getPath(ctx context.Context, mid string) ([]string, error) {
+
+	p := make([]string, 0)
+	if len(mid) != 0 {
+
+		m, err := rm.get(ctx, mid)
+		if err != nil {
+			return p, ErrUpstreamNotFound
+		}
+
+		p = append(p, m.RelationPath...)
+		p = append(p, mid)
+	}
+
+	return p, nil
+}
+
+// merge replaces members of base with their copies in the overlay, and adds
+// copies which aren't in base. Only members for which keep returns true are
+// returned.
+func (rm *relationManagerOverlay) merge(members []*Member, keep func(m *Member) bool) []*Member {
+
+	merged := make([]*Member, 0, len(members))
+	seen := make(map[string]struct{}, len(members))
+
+	for _, m := range members {
+
+		seen[m.ID] = struct{}{}
+
+		if _, ok := rm.deleted[m.ID]; ok {
+			continue
+		}
+
+		if om, ok := rm.members[m.ID]; ok {
+			m = om.clone()
+		} else {
+			m = rm.strip(m)
+		}
+
+		if keep(m) {
+			merged = append(merged, m)
+		}
+	}
+
+	for id, om := range rm.members {
+
+		if _, ok := seen[id]; ok {
+			continue
+		}
+
+		if m := om.clone(); keep(m) {
+			merged = append(merged, m)
+		}
+	}
+
+	return merged
+}
+
+// collectMembers returns all pages of list.
+func collectMembers(list func(cond *Condition) ([]*Member, error)) ([]*Member, error) {
+
+	cond := &Condition{
+		Page:  1,
+		Limit: 500,
+	}
+
+	members := make([]*Member, 0)
+	for {
+		page, err := list(cond)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, page...)
+
+		if len(cond.NextCursor) == 0 {
+			return members, nil
+		}
+
+		cond.After = cond.NextCursor
+		cond.NextCursor = ""
+	}
+}
+
+// descendants returns downline of mid with depth relative to mid. Depth less
+// than 1 means no limit.
+func (rm *relationManagerOverlay) descendants(ctx context.Context, mid string, depth int, tr *TimeRange) ([]*Member, error) {
+
+	if _, err := rm.get(ctx, mid); err != nil {
+		return nil, err
+	}
+
+	members, err := collectMembers(func(cond *Condition) ([]*Member, error) {
+		return rm.base.ListDescendantsContext(ctx, mid, 0, cond)
+	})
+	if err == ErrMemberNotFound {
+		// Member was only added to the overlay
+		members = make([]*Member, 0)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return rm.merge(members, func(m *Member) bool {
+
+		for i, id := range m.RelationPath {
+
+			if id != mid {
+				continue
+			}
+
+			// Members below deleted ones are out of downline as well
+			for _, uid := range m.RelationPath[i+1:] {
+				if _, ok := rm.deleted[uid]; ok {
+					return false
+				}
+			}
+
+			m.Depth = len(m.RelationPath) - i
+
+			return (depth < 1 || m.Depth <= depth) && tr.Contains(m.CreatedAt)
+		}
+
+		return false
+	}), nil
+}
+
+func (rm *relationManagerOverlay) GetPath(mid string) ([]string, error) {
+	return rm.GetPathContext(context.Background(), mid)
+}
+
+func (rm *relationManagerOverlay) GetPathContext(ctx context.Context, mid string) ([]string, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	return rm.getPath(ctx, mid)
+}
+
+func (rm *relationManagerOverlay) ChangePath(mid string, newPath []string) error {
+	return rm.ChangePathContext(context.Background(), mid, newPath)
+}
+
+func (rm *relationManagerOverlay) ChangePathContext(ctx context.Context, mid string, newPath []string) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	m, err := rm.own(ctx, mid)
+	if err != nil {
+		return ErrMemberNotFound
+	}
+
+	m.RelationPath = make([]string, len(newPath))
+	copy(m.RelationPath, newPath)
+
+	return nil
+}
+
+func (rm *relationManagerOverlay) GetMember(mid string) (*Member, error) {
+	return rm.GetMemberContext(context.Background(), mid)
+}
+
+func (rm *relationManagerOverlay) GetMemberContext(ctx context.Context, mid string) (*Member, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	m, err := rm.get(ctx, mid)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.clone(), nil
+}
+
+func (rm *relationManagerOverlay) AddMembers(members []*MemberEntry, upstream string) error {
+	return rm.AddMembersContext(context.Background(), members, upstream)
+}
+
+func (rm *relationManagerOverlay) AddMembersContext(ctx context.Context, members []*MemberEntry, upstream string) error {
+
+	if len(members) == 0 {
+		return ErrMemberRequired
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	rp, err := rm.getPath(ctx, upstream)
+	if err != nil {
+		return ErrUpstreamNotFound
+	}
+
+	createdAt := time.Now().UTC()
+	for _, me := range members {
+
+		m := &Member{
+			ID:           me.ID,
+			ChannelRules: make(map[string]*Rule),
+			RelationPath: rp,
+			Upstream:     upstream,
+			CreatedAt:    createdAt,
+		}
+
+		for channel, r := range me.ChannelRules {
+			m.ChannelRules[channel] = r.clone()
+		}
+
+		delete(rm.deleted, m.ID)
+		rm.members[m.ID] = m
+	}
+
+	return nil
+}
+
+func (rm *relationManagerOverlay) MoveMembers(mids []string, upstream string) error {
+	return rm.MoveMembersContext(context.Background(), mids, upstream)
+}
+
+func (rm *relationManagerOverlay) MoveMembersContext(ctx context.Context, mids []string, upstream string) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	for _, mid := range mids {
+
+		m, err := rm.own(ctx, mid)
+		if err != nil {
+			return err
+		}
+
+		rp, err := rm.getPath(ctx, upstream)
+		if err != nil {
+			return ErrUpstreamNotFound
+		}
+
+		// Member cannot be moved into its own subtree
+		for _, id := range rp {
+			if id == mid {
+				return ErrInvalidUpstream
+			}
+		}
+
+		// Paths of the whole downline change with member
+		descendants, err := rm.descendants(ctx, mid, 0, nil)
+		if err != nil {
+			return err
+		}
+
+		m.Upstream = upstream
+		m.RelationPath = rp
+
+		for _, d := range descendants {
+
+			ds, err := rm.own(ctx, d.ID)
+			if err != nil {
+				return err
+			}
+
+			i := len(ds.RelationPath) - d.Depth
+
+			p := make([]string, 0, len(rp)+d.Depth)
+			p = append(p, rp...)
+			p = append(p, ds.RelationPath[i:]...)
+			ds.RelationPath = p
+		}
+	}
+
+	return nil
+}
+
+func (rm *relationManagerOverlay) DeleteMembers(mids []string) error {
+	return rm.DeleteMembersContext(context.Background(), mids)
+}
+
+func (rm *relationManagerOverlay) DeleteMembersContext(ctx context.Context, mids []string) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	for _, mid := range mids {
+		delete(rm.members, mid)
+		rm.deleted[mid] = struct{}{}
+	}
+
+	return nil
+}
+
+func (rm *relationManagerOverlay) GetUpstreams(mid string) ([]*Member, error) {
+	return rm.GetUpstreamsContext(context.Background(), mid)
+}
+
+func (rm *relationManagerOverlay) GetUpstreamsContext(ctx context.Context, mid string) ([]*Member, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	members := make([]*Member, 0)
+
+	m, err := rm.get(ctx, mid)
+	if err != nil {
+		return members, err
+	}
+
+	for _, usID := range m.RelationPath {
+
+		usm, err := rm.get(ctx, usID)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, usm.clone())
+	}
+
+	return members, nil
+}
+
+func (rm *relationManagerOverlay) ListMembers(upstream string, cond *Condition) ([]*Member, error) {
+	return rm.ListMembersContext(context.Background(), upstream, cond)
+}
+
+func (rm *relationManagerOverlay) ListMembersContext(ctx context.Context, upstream string, cond *Condition) ([]*Member, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if cond == nil {
+		cond = NewCondition()
+	}
+
+	if cond.Page < 1 {
+		cond.Page = 1
+	}
+
+	if cond.Limit < 1 {
+		cond.Limit = 1
+	}
+
+	fields, err := MemberSort(cond.Sort, &SortField{
+		Field:     SortByCreatedAt,
+		Ascending: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	members, err := collectMembers(func(c *Condition) ([]*Member, error) {
+		return rm.base.ListMembersContext(ctx, upstream, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	members = rm.merge(members, func(m *Member) bool {
+		return m.Upstream == upstream && cond.TimeRange.Contains(m.CreatedAt)
+	})
+
+	return pageMembers(members, fields, cond)
+}
+
+func (rm *relationManagerOverlay) ListDescendants(mid string, depth int, cond *Condition) ([]*Member, error) {
+	return rm.ListDescendantsContext(context.Background(), mid, depth, cond)
+}
+
+func (rm *relationManagerOverlay) ListDescendantsContext(ctx context.Context, mid string, depth int, cond *Condition) ([]*Member, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if cond == nil {
+		cond = NewCondition()
+	}
+
+	if cond.Page < 1 {
+		cond.Page = 1
+	}
+
+	if cond.Limit < 1 {
+		cond.Limit = 1
+	}
+
+	fields, err := MemberSort(cond.Sort, &SortField{
+		Field:     SortByDepth,
+		Ascending: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	members, err := rm.descendants(ctx, mid, depth, cond.TimeRange)
+	if err != nil {
+		return nil, err
+	}
+
+	return pageMembers(members, fields, cond)
+}
+
+func (rm *relationManagerOverlay) CountDescendants(mid string) (int, error) {
+	return rm.CountDescendantsContext(context.Background(), mid)
+}
+
+func (rm *relationManagerOverlay) CountDescendantsContext(ctx context.Context, mid string) (int, error) {
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	members, err := rm.descendants(ctx, mid, 0, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(members), nil
+}
+
+func (rm *relationManagerOverlay) UpdateChannelRule(mid string, channel string, rule *Rule) error {
+	return rm.UpdateChannelRuleContext(context.Background(), mid, channel, rule)
+}
+
+func (rm *relationManagerOverlay) UpdateChannelRuleContext(ctx context.Context, mid string, channel string, rule *Rule) error {
+
+	if rule == nil {
+		return nil
+	}
+
+	return rm.UpdateChannelRulesContext(ctx, channel, map[string]*Rule{
+		mid: rule,
+	})
+}
+
+func (rm *relationManagerOverlay) UpdateChannelRules(channel string, rules map[string]*Rule) error {
+	return rm.UpdateChannelRulesContext(context.Background(), channel, rules)
+}
+
+func (rm *relationManagerOverlay) UpdateChannelRulesContext(ctx context.Context, channel string, rules map[string]*Rule) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	// Nothing is changed unless all members exist
+	for mid := range rules {
+		if _, err := rm.get(ctx, mid); err != nil {
+			return err
+		}
+	}
+
+	for mid, rule := range rules {
+
+		if rule == nil {
+			continue
+		}
+
+		m, err := rm.own(ctx, mid)
+		if err != nil {
+			return err
+		}
+
+		m.ChannelRules[channel] = rule.clone()
+	}
+
+	return nil
+}
+
+func (rm *relationManagerOverlay) RemoveChannelRule(mid string, channel string) error {
+	return rm.RemoveChannelRuleContext(context.Background(), mid, channel)
+}
+
+func (rm *relationManagerOverlay) RemoveChannelRuleContext(ctx context.Context, mid string, channel string) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	m, err := rm.own(ctx, mid)
+	if err != nil {
+		return err
+	}
+
+	delete(m.ChannelRules, channel)
+
+	return nil
+}
+
+func (rm *relationManagerOverlay) RemoveChannel(channel string) error {
+	return rm.RemoveChannelContext(context.Background(), channel)
+}
+
+func (rm *relationManagerOverlay) RemoveChannelContext(ctx context.Context, channel string) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	// Members of base are stripped when they're read
+	rm.removedChannels = append(rm.removedChannels, channel)
+
+	for _, m := range rm.members {
+		for c := range m.ChannelRules {
+			if MatchChannel(channel, c) {
+				delete(m.ChannelRules, c)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package bursary
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RelationManagerOverlay_Isolation(t *testing.T) {
+
+	base := NewRelationManagerMemory()
+
+	// a - b - c, and d below a
+	ids := []string{genTestID(), genTestID(), genTestID(), genTestID()}
+	for i, upstream := range []string{"", ids[0], ids[1], ids[0]} {
+		err := base.AddMembers([]*MemberEntry{
+			&MemberEntry{
+				ID: ids[i],
+				ChannelRules: map[string]*Rule{
+					"default": &Rule{Commission: 0.5},
+					"casino":  &Rule{Commission: 0.4},
+				},
+			},
+		}, upstream)
+		assert.Nil(t, err)
+	}
+
+	rm := NewRelationManagerOverlay(base)
+
+	assert.Nil(t, rm.MoveMembers([]string{ids[1]}, ids[3]))
+	assert.Nil(t, rm.UpdateChannelRule(ids[2], "default", &Rule{Commission: 0.1}))
+	assert.Nil(t, rm.RemoveChannel("casino"))
+
+	// Overlay sees changes
+	path, err := rm.GetPath(ids[2])
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{ids[0], ids[3], ids[1], ids[2]}, path)
+	}
+
+	upstreams, err := rm.GetUpstreams(ids[2])
+	if assert.Nil(t, err) && assert.Len(t, upstreams, 3) {
+		assert.Equal(t, ids[3], upstreams[1].ID)
+		assert.Nil(t, upstreams[0].ChannelRules["casino"])
+	}
+
+	members, err := rm.ListMembers(ids[3], nil)
+	if assert.Nil(t, err) && assert.Len(t, members, 1) {
+		assert.Equal(t, ids[1], members[0].ID)
+	}
+
+	members, err = rm.ListMembers(ids[0], nil)
+	if assert.Nil(t, err) && assert.Len(t, members, 1) {
+		assert.Equal(t, ids[3], members[0].ID)
+	}
+
+	members, err = rm.ListDescendants(ids[3], 0, nil)
+	if assert.Nil(t, err) && assert.Len(t, members, 2) {
+		assert.Equal(t, ids[1], members[0].ID)
+		assert.Equal(t, 1, members[0].Depth)
+		assert.Equal(t, ids[2], members[1].ID)
+		assert.Equal(t, 2, members[1].Depth)
+	}
+
+	count, err := rm.CountDescendants(ids[0])
+	if assert.Nil(t, err) {
+		assert.Equal(t, 3, count)
+	}
+
+	m, err := rm.GetMember(ids[2])
+	if assert.Nil(t, err) {
+		assert.Equal(t, 0.1, m.ChannelRules["default"].Commission)
+		assert.Nil(t, m.ChannelRules["casino"])
+	}
+
+	// Base is untouched
+	path, err = base.GetPath(ids[2])
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{ids[0], ids[1], ids[2]}, path)
+	}
+
+	m, err = base.GetMember(ids[2])
+	if assert.Nil(t, err) {
+		assert.Equal(t, 0.5, m.ChannelRules["default"].Commission)
+		assert.NotNil(t, m.ChannelRules["casino"])
+	}
+
+	count, err = base.CountDescendants(ids[3])
+	if assert.Nil(t, err) {
+		assert.Equal(t, 0, count)
+	}
+
+	// Deleting hides members of base
+	assert.Nil(t, rm.DeleteMembers([]string{ids[1]}))
+
+	_, err = rm.GetMember(ids[1])
+	assert.ErrorIs(t, err, ErrMemberNotFound)
+
+	count, err = rm.CountDescendants(ids[0])
+	if assert.Nil(t, err) {
+		assert.Equal(t, 1, count)
+	}
+
+	_, err = base.GetMember(ids[1])
+	assert.Nil(t, err)
+}
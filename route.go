@@ -0,0 +1,268 @@
+package bursary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrGeneralLedgerNotRouted = errors.New("bursary: entry read by caps or tiers isn't routed to general ledger")
+)
+
+// GeneralLedgerName is the name of general ledger in LedgerManager.
+const GeneralLedgerName = "general"
+
+// Route returns names of ledgers which should receive an entry. Agency is
+// the top-level agency of ticket owner, or the owner itself if it has no
+// upstream.
+type Route func(le *LedgerEntry, agency string) []string
+
+// RouteToGeneral sends every entry to general ledger.
+func RouteToGeneral() Route {
+	return RouteTo(GeneralLedgerName)
+}
+
+// RouteTo sends every entry to the given ledgers.
+func RouteTo(names ...string) Route {
+	return func(le *LedgerEntry, agency string) []string {
+		return names
+	}
+}
+
+// RouteByChannel sends entries to the ledger named after their channel.
+func RouteByChannel() Route {
+	return func(le *LedgerEntry, agency string) []string {
+		return []string{le.Channel}
+	}
+}
+
+// RouteByAgency sends entries to the ledger named after top-level agency
+// with prefix, such as "agency:" followed by ID of the agency.
+func RouteByAgency(prefix string) Route {
+	return func(le *LedgerEntry, agency string) []string {
+		return []string{prefix + agency}
+	}
+}
+
+// RouteIf sends entries matched by fn to the given ledgers.
+func RouteIf(fn func(le *LedgerEntry) bool, names ...string) Route {
+	return func(le *LedgerEntry, agency string) []string {
+
+		if fn(le) {
+			return names
+		}
+
+		return nil
+	}
+}
+
+// StagedLedger is implemented by ledgers which can write entries in two
+// phases, so that a write fanning out to several ledgers can be rolled back
+// until it is committed to all of them.
+type StagedLedger interface {
+	StageRecords(ctx context.Context, entries []*LedgerEntry) (StagedWrite, error)
+}
+
+// StagedWrite holds entries staged by StagedLedger. Exactly one of Commit and
+// Rollback should be called.
+type StagedWrite interface {
+	Commit() error
+	Rollback() error
+}
+
+// StageScope is shared by ledgers staged for the same write. Ledgers stored
+// in the same database can use it to join a single transaction instead of
+// blocking each other.
+type StageScope struct {
+	mutex  sync.Mutex
+	values map[interface{}]interface{}
+}
+
+type stageScopeKey struct{}
+
+// StageScopeFromContext returns the scope of the write being staged, or nil
+// if ctx doesn't belong to one.
+func StageScopeFromContext(ctx context.Context) *StageScope {
+	scope, _ := ctx.Value(stageScopeKey{}).(*StageScope)
+	return scope
+}
+
+// Load returns the value stored for key.
+func (s *StageScope) Load(key interface{}) (value interface{}, ok bool) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	value, ok = s.values[key]
+
+	return value, ok
+}
+
+// LoadOrStore returns the value stored for key if there is one. Otherwise
+// it stores and returns value.
+func (s *StageScope) LoadOrStore(key interface{}, value interface{}) (actual interface{}, loaded bool) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if v, ok := s.values[key]; ok {
+		return v, true
+	}
+
+	s.values[key] = value
+
+	return value, false
+}
+
+type ledgerWrite struct {
+	name    string
+	ledger  Ledger
+	entries []*LedgerEntry
+}
+
+// routeEntries groups entries by ledgers according to routes and extra ones.
+// Ledgers are resolved before anything is written, and entries keep their
+// order in each ledger.
+func (b *bursary) routeEntries(ctx context.Context, entries []*LedgerEntry, extra ...Route) ([]*ledgerWrite, error) {
+
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	routes := append(b.routes[:len(b.routes):len(b.routes)], extra...)
+
+	// The last entry belongs to top-level agency, or house account above it
+	agency := entries[len(entries)-1].MemberID
+	if len(b.house) > 0 && agency == b.house && len(entries) > 1 {
+		agency = entries[len(entries)-2].MemberID
+	}
+
+	writes := make([]*ledgerWrite, 0)
+	indexes := make(map[string]int)
+	for _, le := range entries {
+
+		routed := make(map[string]bool)
+		for _, route := range routes {
+			for _, name := range route(le, agency) {
+
+				// Entry is written to the same ledger once
+				if routed[name] {
+					continue
+				}
+
+				routed[name] = true
+
+				idx, ok := indexes[name]
+				if !ok {
+
+					l, err := b.lm.GetContext(ctx, name)
+					if err != nil {
+						return nil, err
+					}
+
+					idx = len(writes)
+					indexes[name] = idx
+					writes = append(writes, &ledgerWrite{
+						name:   name,
+						ledger: l,
+					})
+				}
+
+				writes[idx].entries = append(writes[idx].entries, le)
+			}
+		}
+	}
+
+	return writes, nil
+}
+
+// checkGeneralRoute makes sure that entries summed marks, which are of rules
+// with period caps or tiers, are written to general ledger those are read
+// from. Otherwise routes would silently switch them off.
+func checkGeneralRoute(entries []*LedgerEntry, writes []*ledgerWrite, summed map[string]bool) error {
+
+	routed := make(map[string]bool)
+	for _, w := range writes {
+		if w.name == GeneralLedgerName {
+			for _, le := range w.entries {
+				routed[le.ID] = true
+			}
+		}
+	}
+
+	for _, le := range entries {
+		if summed[le.ID] && !routed[le.ID] {
+			return fmt.Errorf("%w: member %s", ErrGeneralLedgerNotRouted, le.MemberID)
+		}
+	}
+
+	return nil
+}
+
+// fanOut writes to all ledgers. Writes to ledgers which implement
+// StagedLedger are staged first and committed only if every other write,
+// including extra ones, succeeded. It isn't atomic across databases though:
+// writes to other ledgers, including decorators such as HashChainLedger
+// wrapping staged ones, and extra writes aren't undone if a later step
+// fails, and staged writes committed before a failing commit stay committed.
+func fanOut(ctx context.Context, writes []*ledgerWrite, extra ...func(ctx context.Context) error) error {
+
+	ctx = context.WithValue(ctx, stageScopeKey{}, &StageScope{
+		values: make(map[interface{}]interface{}),
+	})
+
+	staged := make([]StagedWrite, 0)
+	rollback := func() {
+		for _, sw := range staged {
+			sw.Rollback()
+		}
+	}
+
+	direct := make([]*ledgerWrite, 0)
+	for _, w := range writes {
+
+		sl, ok := w.ledger.(StagedLedger)
+		if !ok {
+			direct = append(direct, w)
+			continue
+		}
+
+		sw, err := sl.StageRecords(ctx, w.entries)
+		if err != nil {
+			rollback()
+			return err
+		}
+
+		staged = append(staged, sw)
+	}
+
+	for _, w := range direct {
+		if err := w.ledger.WriteRecordsContext(ctx, w.entries); err != nil {
+			rollback()
+			return err
+		}
+	}
+
+	for _, fn := range extra {
+		if err := fn(ctx); err != nil {
+			rollback()
+			return err
+		}
+	}
+
+	for i, sw := range staged {
+		if err := sw.Commit(); err != nil {
+
+			// Staged writes which aren't committed yet are rolled back
+			for _, rest := range staged[i+1:] {
+				rest.Rollback()
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
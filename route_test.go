@@ -0,0 +1,189 @@
+package bursary
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errTestLedger = errors.New("test ledger")
+
+// failingLedger rejects every write.
+type failingLedger struct {
+	Ledger
+}
+
+func (l *failingLedger) WriteRecordsContext(ctx context.Context, entries []*LedgerEntry) error {
+	return errTestLedger
+}
+
+func countRecords(t *testing.T, l Ledger) int {
+
+	count := 0
+	err := l.ScanRecords(nil, func(le *LedgerEntry) error {
+		count++
+		return nil
+	})
+	assert.Nil(t, err)
+
+	return count
+}
+
+func Test_WriteTicket_Routes(t *testing.T) {
+
+	bu := NewBursary(
+		WithLedgerRoutes(
+			RouteToGeneral(),
+			RouteByChannel(),
+			RouteByAgency("agency:"),
+			RouteIf(func(le *LedgerEntry) bool {
+				return le.IsPrimary
+			}, "primary", GeneralLedgerName),
+		),
+	)
+	defer bu.Close()
+
+	r := &Rule{Commission: 0.5, Share: 0.5}
+	ids := addTestChain(t, bu.RelationManager(), "slot", r, r, r)
+
+	ledgers := map[string]Ledger{
+		"slot":             NewLedgerMemory(),
+		"agency:" + ids[0]: NewLedgerMemory(),
+		"primary":          NewLedgerMemory(),
+	}
+
+	for name, l := range ledgers {
+		assert.Nil(t, bu.LedgerManager().Add(name, l))
+	}
+
+	ticket := NewTicket()
+	ticket.Channel = "slot"
+	ticket.MemberID = ids[2]
+	ticket.Amount = 1000
+	ticket.Fee = 100
+
+	err := bu.WriteTicket(ticket)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Entries are written to general ledger once even if routed twice
+	assert.Equal(t, 3, countRecords(t, bu.GeneralLedger()))
+	assert.Equal(t, 3, countRecords(t, ledgers["slot"]))
+	assert.Equal(t, 3, countRecords(t, ledgers["agency:"+ids[0]]))
+	assert.Equal(t, 1, countRecords(t, ledgers["primary"]))
+
+	records, err := ledgers["primary"].ReadRecordsByMemberID(ids[2], nil)
+	if assert.Nil(t, err) && assert.Len(t, records, 1) {
+		assert.True(t, records[0].IsPrimary)
+	}
+}
+
+func Test_WriteTicket_RouteNotFound(t *testing.T) {
+
+	bu := NewBursary(
+		WithLedgerRoutes(
+			RouteToGeneral(),
+			RouteByChannel(),
+		),
+	)
+	defer bu.Close()
+
+	r := &Rule{Commission: 0.5, Share: 0.5}
+	ids := addTestChain(t, bu.RelationManager(), "slot", r, r, r)
+
+	ticket := NewTicket()
+	ticket.Channel = "slot"
+	ticket.MemberID = ids[2]
+
+	// Nothing is written if any ledger is missing
+	err := bu.WriteTicket(ticket)
+	assert.Equal(t, ErrLedgerNotFound, err)
+	assert.Equal(t, 0, countRecords(t, bu.GeneralLedger()))
+}
+
+func Test_WriteTicket_RouteRollback(t *testing.T) {
+
+	bu := NewBursary(
+		WithLedgerRoutes(
+			RouteToGeneral(),
+			RouteTo("failing"),
+		),
+	)
+	defer bu.Close()
+
+	assert.Nil(t, bu.LedgerManager().Add("failing", &failingLedger{}))
+
+	r := &Rule{Commission: 0.5, Share: 0.5}
+	ids := addTestChain(t, bu.RelationManager(), "slot", r, r, r)
+
+	ticket := NewTicket()
+	ticket.Channel = "slot"
+	ticket.MemberID = ids[2]
+
+	// Staged write to general ledger is rolled back
+	err := bu.WriteTicket(ticket)
+	assert.Equal(t, errTestLedger, err)
+	assert.Equal(t, 0, countRecords(t, bu.GeneralLedger()))
+}
+
+var errTestCommit = errors.New("test commit")
+
+// stagedTestLedger stages writes in memory, and fails to commit them if
+// commitErr is set.
+type stagedTestLedger struct {
+	Ledger
+	commitErr  error
+	rolledBack bool
+}
+
+type stagedTestWrite struct {
+	l       *stagedTestLedger
+	entries []*LedgerEntry
+}
+
+func (sw *stagedTestWrite) Commit() error {
+
+	if sw.l.commitErr != nil {
+		return sw.l.commitErr
+	}
+
+	return sw.l.Ledger.WriteRecords(sw.entries)
+}
+
+func (sw *stagedTestWrite) Rollback() error {
+	sw.l.rolledBack = true
+	return nil
+}
+
+func (l *stagedTestLedger) StageRecords(ctx context.Context, entries []*LedgerEntry) (StagedWrite, error) {
+	return &stagedTestWrite{l: l, entries: entries}, nil
+}
+
+func Test_WriteTicket_CommitFailure(t *testing.T) {
+
+	failing := &stagedTestLedger{Ledger: NewLedgerMemory(), commitErr: errTestCommit}
+	rest := &stagedTestLedger{Ledger: NewLedgerMemory()}
+
+	bu := NewBursary(WithLedgerRoutes(RouteTo("failing", "rest")))
+	defer bu.Close()
+
+	assert.Nil(t, bu.LedgerManager().Add("failing", failing))
+	assert.Nil(t, bu.LedgerManager().Add("rest", rest))
+
+	r := &Rule{Commission: 0.5, Share: 0.5}
+	ids := addTestChain(t, bu.RelationManager(), "slot", r, r, r)
+
+	ticket := NewTicket()
+	ticket.Channel = "slot"
+	ticket.MemberID = ids[2]
+	ticket.Amount = 1000
+
+	assert.ErrorIs(t, bu.WriteTicket(ticket), errTestCommit)
+
+	// Writes staged after the failing one are rolled back
+	assert.True(t, rest.rolledBack)
+	assert.Equal(t, 0, countRecords(t, rest))
+}
@@ -4,6 +4,24 @@ type Rule struct {
 	Commission    float64 `json:"commission"`
 	Share         float64 `json:"share"`
 	ReturnedShare float64 `json:"returned_share"`
+
+	// Limits of earnings, which are gain plus commissions. Zero means no limit.
+	TicketCap   int64  `json:"ticket_cap,omitempty"`   // per ticket
+	DailyCap    int64  `json:"daily_cap,omitempty"`    // per day in UTC
+	PeriodCap   int64  `json:"period_cap,omitempty"`   // per Period
+	Period      Period `json:"period,omitempty"`       // month by default
+	TicketFloor int64  `json:"ticket_floor,omitempty"` // guaranteed minimum per ticket
+
+	// Tiers replace shares above once volume of Period reaches their thresholds
+	Tiers      []*Tier    `json:"tiers,omitempty"`
+	TierVolume TierVolume `json:"tier_volume,omitempty"` // amount by default
+
+	// Fixed amounts in major units of currency, paid on top of shares
+	Fixed   float64            `json:"fixed,omitempty"`   // per ticket
+	Bonuses map[string]float64 `json:"bonuses,omitempty"` // per event of ticket
+
+	// Ceiling limits rules of all members below in the channel
+	Ceiling *RuleCeiling `json:"ceiling,omitempty"`
 }
 
 var DefaultRule = Rule{
@@ -11,3 +29,29 @@ var DefaultRule = Rule{
 	Share:         0.0, // used to give share to current member ()
 	ReturnedShare: 0.0, // used to return share for upstream (upstream's share >= share + returned share)
 }
+
+// sumsLedger reports whether r reads earlier entries of member from general
+// ledger, for period caps or tiers.
+func (r *Rule) sumsLedger() bool {
+	return r.DailyCap > 0 || r.PeriodCap > 0 || len(r.Tiers) > 0
+}
+
+func (r *Rule) clone() *Rule {
+
+	c := *r
+	c.Tiers = cloneTiers(r.Tiers)
+
+	if r.Bonuses != nil {
+		c.Bonuses = make(map[string]float64, len(r.Bonuses))
+		for event, amount := range r.Bonuses {
+			c.Bonuses[event] = amount
+		}
+	}
+
+	if r.Ceiling != nil {
+		ceiling := *r.Ceiling
+		c.Ceiling = &ceiling
+	}
+
+	return &c
+}
@@ -0,0 +1,159 @@
+package bursary
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+var ErrReadOnlyLedger = errors.New("bursary: ledger is read-only")
+
+// readOnlyLedger lets ledger be read while rejecting writes.
+type readOnlyLedger struct {
+	Ledger
+}
+
+func (l readOnlyLedger) WriteRecords(entries []*LedgerEntry) error {
+	return ErrReadOnlyLedger
+}
+
+func (l readOnlyLedger) WriteRecordsContext(ctx context.Context, entries []*LedgerEntry) error {
+	return ErrReadOnlyLedger
+}
+
+// Simulation is a hypothetical change of relations and rules, evaluated with
+// sample tickets. Moves are applied before rules.
+type Simulation struct {
+	Moves   []*SimulatedMove `json:"moves,omitempty"`
+	Rules   []*SimulatedRule `json:"rules,omitempty"`
+	Tickets []*Ticket        `json:"tickets"`
+}
+
+type SimulatedMove struct {
+	MemberIDs []string `json:"member_ids"`
+	Upstream  string   `json:"upstream"`
+}
+
+type SimulatedRule struct {
+	MemberID string `json:"member_id"`
+	Channel  string `json:"channel"`
+	Rule     *Rule  `json:"rule,omitempty"` // nil removes rule of channel
+}
+
+// SimulatedTotal is what member earns from tickets of a simulation in
+// currency, before and after the change.
+type SimulatedTotal struct {
+	MemberID string `json:"member_id"`
+	Currency string `json:"currency,omitempty"`
+	Before   int64  `json:"before"`
+	After    int64  `json:"after"`
+}
+
+func (st *SimulatedTotal) Delta() int64 {
+	return st.After - st.Before
+}
+
+// simulator returns a copy of bursary calculating with rm, which can't write
+// to the general ledger.
+func (b *bursary) simulator(rm RelationManager) *bursary {
+
+	sb := *b
+	sb.rm = rm
+	sb.gl = readOnlyLedger{b.gl}
+
+	return &sb
+}
+
+func (b *bursary) Simulate(sim *Simulation) ([]*SimulatedTotal, error) {
+	return b.SimulateContext(context.Background(), sim)
+}
+
+// SimulateContext calculates rewards of tickets of sim with the current
+// relations and rules, and again with changes of sim applied to an overlay
+// of the relation manager. Nothing is written to the relation manager or
+// ledgers. Totals are sorted by member and currency.
+func (b *bursary) SimulateContext(ctx context.Context, sim *Simulation) ([]*SimulatedTotal, error) {
+
+	overlay := NewRelationManagerOverlay(b.rm)
+
+	for _, mv := range sim.Moves {
+		err := overlay.MoveMembersContext(ctx, mv.MemberIDs, mv.Upstream)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, sr := range sim.Rules {
+
+		var err error
+		if sr.Rule == nil {
+			err = overlay.RemoveChannelRuleContext(ctx, sr.MemberID, sr.Channel)
+		} else {
+			err = overlay.UpdateChannelRuleContext(ctx, sr.MemberID, sr.Channel, sr.Rule)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	totals := make(map[balanceKey]*SimulatedTotal)
+	total := func(le *LedgerEntry) *SimulatedTotal {
+
+		key := balanceKey{le.MemberID, le.Currency}
+
+		st, ok := totals[key]
+		if !ok {
+			st = &SimulatedTotal{
+				MemberID: le.MemberID,
+				Currency: le.Currency,
+			}
+			totals[key] = st
+		}
+
+		return st
+	}
+
+	before := b.simulator(b.rm)
+	after := b.simulator(overlay)
+
+	for _, t := range sim.Tickets {
+
+		// Calculating may fill in fields of ticket
+		bt := *t
+		entries, err := before.CalculateRewardsContext(ctx, &bt)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, le := range entries {
+			total(le).Before += le.Earnings()
+		}
+
+		at := *t
+		entries, err = after.CalculateRewardsContext(ctx, &at)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, le := range entries {
+			total(le).After += le.Earnings()
+		}
+	}
+
+	results := make([]*SimulatedTotal, 0, len(totals))
+	for _, st := range totals {
+		results = append(results, st)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+
+		if results[i].MemberID != results[j].MemberID {
+			return results[i].MemberID < results[j].MemberID
+		}
+
+		return results[i].Currency < results[j].Currency
+	})
+
+	return results, nil
+}
@@ -0,0 +1,192 @@
+package bursary
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func earningsOf(t *testing.T, bu Bursary, ticket *Ticket) map[string]int64 {
+
+	c := *ticket
+	entries, err := bu.CalculateRewards(&c)
+	assert.Nil(t, err)
+
+	earnings := make(map[string]int64)
+	for _, le := range entries {
+		earnings[le.MemberID] += le.Earnings()
+	}
+
+	return earnings
+}
+
+func assertSimulatedTotals(t *testing.T, totals []*SimulatedTotal, before map[string]int64, after map[string]int64) {
+
+	for _, st := range totals {
+		assert.Equal(t, before[st.MemberID], st.Before, st.MemberID)
+		assert.Equal(t, after[st.MemberID], st.After, st.MemberID)
+		assert.Equal(t, st.After-st.Before, st.Delta())
+	}
+}
+
+func Test_Simulate_Rules(t *testing.T) {
+
+	gl := NewLedgerMemory()
+	bu := NewBursary(WithGeneralLedger(gl))
+	ids := addTestChain(t, bu.RelationManager(), "default", newLimitTestRules()...)
+
+	ticket := newLimitTestTicket(ids[2])
+	before := earningsOf(t, bu, ticket)
+
+	rule := &Rule{Commission: 0.5, Share: 0.4}
+	totals, err := bu.Simulate(&Simulation{
+		Rules: []*SimulatedRule{
+			{MemberID: ids[2], Channel: "default", Rule: rule},
+		},
+		Tickets: []*Ticket{ticket, ticket},
+	})
+	if !assert.Nil(t, err) || !assert.Len(t, totals, 3) {
+		return
+	}
+
+	// Nothing is written
+	assert.Equal(t, 0, countRecords(t, gl))
+	assert.Equal(t, 0.3, getTestRule(t, bu, ids[2], "default").Share)
+
+	assert.Nil(t, bu.RelationManager().UpdateChannelRule(ids[2], "default", rule))
+	after := earningsOf(t, bu, ticket)
+
+	for id := range before {
+		before[id] *= 2
+		after[id] *= 2
+	}
+
+	assertSimulatedTotals(t, totals, before, after)
+
+	// Owner gets 0.4 of amount and 0.5 of fee for each ticket
+	for _, st := range totals {
+		if st.MemberID == ids[2] {
+			assert.Equal(t, int64(900), st.After)
+		}
+	}
+}
+
+func Test_Simulate_Moves(t *testing.T) {
+
+	gl := NewLedgerMemory()
+	bu := NewBursary(WithGeneralLedger(gl))
+	ids := addTestChain(t, bu.RelationManager(), "default", newLimitTestRules()...)
+
+	// Another agent below the top level
+	agent := genTestID()
+	err := bu.RelationManager().AddMembers([]*MemberEntry{
+		&MemberEntry{
+			ID: agent,
+			ChannelRules: map[string]*Rule{
+				"default": &Rule{Commission: 0.8, Share: 0.7},
+			},
+		},
+	}, ids[0])
+	assert.Nil(t, err)
+
+	ticket := newLimitTestTicket(ids[2])
+	before := earningsOf(t, bu, ticket)
+
+	totals, err := bu.Simulate(&Simulation{
+		Moves: []*SimulatedMove{
+			{MemberIDs: []string{ids[2]}, Upstream: agent},
+		},
+		Tickets: []*Ticket{ticket},
+	})
+	if !assert.Nil(t, err) || !assert.Len(t, totals, 4) {
+		return
+	}
+
+	assert.Equal(t, 0, countRecords(t, gl))
+
+	m, err := bu.RelationManager().GetMember(ids[2])
+	if assert.Nil(t, err) {
+		assert.Equal(t, ids[1], m.Upstream)
+	}
+
+	assert.Nil(t, bu.RelationManager().MoveMembers([]string{ids[2]}, agent))
+	after := earningsOf(t, bu, ticket)
+
+	assertSimulatedTotals(t, totals, before, after)
+
+	// The former upstream earns nothing after the move
+	for _, st := range totals {
+		if st.MemberID == ids[1] {
+			assert.Equal(t, int64(0), st.After)
+			assert.NotEqual(t, int64(0), st.Before)
+		}
+	}
+}
+
+func Test_Simulate_WrittenTickets(t *testing.T) {
+
+	gl := NewLedgerMemory()
+	bu := NewBursary(WithGeneralLedger(gl))
+	rules := newLimitTestRules()
+	rules[2].DailyCap = 500
+	ids := addTestChain(t, bu.RelationManager(), "default", rules...)
+
+	// Sample ticket was written already, earning 350 of the cap
+	ticket := newLimitTestTicket(ids[2])
+	ticket.CreatedAt = time.Now().UTC()
+	assert.Nil(t, bu.WriteTicket(ticket))
+
+	totals, err := bu.Simulate(&Simulation{
+		Rules: []*SimulatedRule{
+			{MemberID: ids[2], Channel: "default", Rule: &Rule{Commission: 0.5, Share: 0.4, DailyCap: 500}},
+		},
+		Tickets: []*Ticket{ticket},
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Its own entries don't count toward the cap
+	for _, st := range totals {
+		if st.MemberID == ids[2] {
+			assert.Equal(t, int64(350), st.Before)
+			assert.Equal(t, int64(450), st.After)
+		}
+	}
+}
+
+func Test_Simulate_Errors(t *testing.T) {
+
+	bu := NewBursary()
+	ids := addTestChain(t, bu.RelationManager(), "default", newLimitTestRules()...)
+
+	_, err := bu.Simulate(&Simulation{
+		Moves: []*SimulatedMove{
+			{MemberIDs: []string{ids[0]}, Upstream: ids[2]},
+		},
+	})
+	assert.ErrorIs(t, err, ErrInvalidUpstream)
+
+	_, err = bu.Simulate(&Simulation{
+		Rules: []*SimulatedRule{
+			{MemberID: genTestID(), Channel: "default", Rule: &Rule{}},
+		},
+	})
+	assert.ErrorIs(t, err, ErrMemberNotFound)
+
+	// Removing rules
+	totals, err := bu.Simulate(&Simulation{
+		Rules: []*SimulatedRule{
+			{MemberID: ids[2], Channel: "default"},
+		},
+		Tickets: []*Ticket{newLimitTestTicket(ids[2])},
+	})
+	if assert.Nil(t, err) {
+		for _, st := range totals {
+			if st.MemberID == ids[2] {
+				assert.Equal(t, int64(0), st.After)
+			}
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package bursary
+
+import (
+	"errors"
+	"io"
+)
+
+// SnapshotVersion is the version of snapshot format written by memory backends.
+const SnapshotVersion = 1
+
+var (
+	ErrUnsupportedSnapshot = errors.New("bursary: unsupported snapshot")
+)
+
+// Snapshotter is implemented by backends which can dump their whole state and
+// load it back.
+type Snapshotter interface {
+	Snapshot(w io.Writer) error
+	Restore(r io.Reader) error
+}
+
+// Checkpointer is implemented by backends writing a WAL. Checkpoint takes a
+// snapshot and truncates the log while holding off every change, so that
+// snapshot and log never overlap or leave a gap.
+type Checkpointer interface {
+	Checkpoint(w io.Writer) error
+}
+
+type snapshotHeader struct {
+	Version int    `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+func (h *snapshotHeader) check(kind string) error {
+
+	if h.Version != SnapshotVersion || h.Kind != kind {
+		return ErrUnsupportedSnapshot
+	}
+
+	return nil
+}
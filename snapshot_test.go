@@ -0,0 +1,229 @@
+package bursary
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// addTestChain adds a member with each of rules for channel, each one below
+// the previous one, and returns their IDs from the top.
+func addTestChain(t *testing.T, rm RelationManager, channel string, rules ...*Rule) []string {
+
+	ids := make([]string, 0, len(rules))
+
+	upstream := ""
+	for _, r := range rules {
+		id := genTestID()
+		err := rm.AddMembers([]*MemberEntry{
+			&MemberEntry{
+				ID: id,
+				ChannelRules: map[string]*Rule{
+					channel: r,
+				},
+			},
+		}, upstream)
+		assert.Nil(t, err)
+
+		ids = append(ids, id)
+		upstream = id
+	}
+
+	return ids
+}
+
+func Test_RelationManagerMemory_Snapshot(t *testing.T) {
+
+	rm := NewRelationManagerMemory()
+	r := &Rule{Commission: 0.5, Share: 0.2}
+	ids := addTestChain(t, rm, "default", r, r, r)
+
+	var buf bytes.Buffer
+	err := rm.(Snapshotter).Snapshot(&buf)
+	assert.Nil(t, err)
+
+	restored := NewRelationManagerMemory()
+	err = restored.(Snapshotter).Restore(&buf)
+	assert.Nil(t, err)
+
+	for _, id := range ids {
+		expected, err := rm.GetMember(id)
+		assert.Nil(t, err)
+
+		m, err := restored.GetMember(id)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, m)
+	}
+
+	// Downline is indexed again
+	count, err := restored.CountDescendants(ids[0])
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func Test_RelationManagerMemory_Restore_Unsupported(t *testing.T) {
+
+	rm := NewRelationManagerMemory()
+
+	err := rm.(Snapshotter).Restore(strings.NewReader(`{"version":99,"kind":"relation_manager"}`))
+	assert.Equal(t, ErrUnsupportedSnapshot, err)
+
+	// Ledger snapshot cannot be loaded into relation manager
+	var buf bytes.Buffer
+	err = NewLedgerMemory().(Snapshotter).Snapshot(&buf)
+	assert.Nil(t, err)
+
+	err = rm.(Snapshotter).Restore(&buf)
+	assert.Equal(t, ErrUnsupportedSnapshot, err)
+}
+
+func Test_RelationManagerMemory_WAL(t *testing.T) {
+
+	walPath := filepath.Join(t.TempDir(), "relation.wal")
+
+	wal, err := OpenWAL(walPath)
+	assert.Nil(t, err)
+
+	rm := NewRelationManagerMemory(WithRelationManagerWAL(wal))
+	r := &Rule{Commission: 0.5, Share: 0.2}
+	ids := addTestChain(t, rm, "default", r, r, r)
+
+	// Changes after checkpoint are kept by the log only
+	var snapshot bytes.Buffer
+	err = rm.(Checkpointer).Checkpoint(&snapshot)
+	assert.Nil(t, err)
+
+	more := addTestChain(t, rm, "default", r, r)
+	err = rm.MoveMembers([]string{more[0]}, ids[2])
+	assert.Nil(t, err)
+	err = rm.DeleteMembers([]string{ids[0]})
+	assert.Nil(t, err)
+	err = rm.UpdateChannelRule(ids[1], "slot", &Rule{
+		Commission: 0.3,
+	})
+	assert.Nil(t, err)
+
+	assert.Nil(t, wal.Close())
+
+	// Restart
+	wal, err = OpenWAL(walPath)
+	assert.Nil(t, err)
+	defer wal.Close()
+
+	recovered, err := RecoverRelationManagerMemory(&snapshot, wal)
+	assert.Nil(t, err)
+
+	_, err = recovered.GetMember(ids[0])
+	assert.Equal(t, ErrMemberNotFound, err)
+
+	for _, id := range append(ids[1:], more...) {
+		expected, err := rm.GetMember(id)
+		assert.Nil(t, err)
+
+		m, err := recovered.GetMember(id)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, m)
+	}
+}
+
+func Test_LedgerMemory_Snapshot(t *testing.T) {
+
+	l := NewLedgerMemory()
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	err := l.WriteRecords([]*LedgerEntry{
+		&LedgerEntry{
+			ID:        genTestID(),
+			MemberID:  "m1",
+			Channel:   "default",
+			Amount:    100,
+			Info:      map[string]interface{}{"game": "slot"},
+			CreatedAt: now,
+		},
+		&LedgerEntry{
+			ID:        genTestID(),
+			MemberID:  "m1",
+			Channel:   "default",
+			Amount:    200,
+			CreatedAt: now,
+		},
+	})
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	err = l.(Snapshotter).Snapshot(&buf)
+	assert.Nil(t, err)
+
+	restored := NewLedgerMemory()
+	err = restored.(Snapshotter).Restore(&buf)
+	assert.Nil(t, err)
+
+	expected, err := l.ReadRecordsByMemberID("m1", nil)
+	assert.Nil(t, err)
+
+	records, err := restored.ReadRecordsByMemberID("m1", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, records)
+}
+
+func Test_LedgerMemory_WAL_TornTail(t *testing.T) {
+
+	walPath := filepath.Join(t.TempDir(), "ledger.wal")
+
+	wal, err := OpenWAL(walPath)
+	assert.Nil(t, err)
+
+	l := NewLedgerMemory(WithLedgerWAL(wal))
+	for i := 0; i < 3; i++ {
+		err := l.WriteRecords([]*LedgerEntry{
+			&LedgerEntry{
+				ID:       genTestID(),
+				MemberID: "m1",
+				Amount:   int64(i),
+			},
+		})
+		assert.Nil(t, err)
+	}
+
+	assert.Nil(t, wal.Close())
+
+	// Crash in the middle of writing a record
+	f, err := os.OpenFile(walPath, os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteString(`{"op":"write_records","data":[{"id":`)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	wal, err = OpenWAL(walPath)
+	assert.Nil(t, err)
+	defer wal.Close()
+
+	recovered, err := RecoverLedgerMemory(nil, wal)
+	assert.Nil(t, err)
+
+	records, err := recovered.ReadRecordsByMemberID("m1", nil)
+	assert.Nil(t, err)
+	assert.Len(t, records, 3)
+
+	// Torn record was discarded so new records can be appended cleanly
+	err = recovered.WriteRecords([]*LedgerEntry{
+		&LedgerEntry{
+			ID:       genTestID(),
+			MemberID: "m1",
+			Amount:   3,
+		},
+	})
+	assert.Nil(t, err)
+
+	replayed, err := RecoverLedgerMemory(nil, wal)
+	assert.Nil(t, err)
+
+	records, err = replayed.ReadRecordsByMemberID("m1", nil)
+	assert.Nil(t, err)
+	assert.Len(t, records, 4)
+}
@@ -0,0 +1,218 @@
+package bursary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	ErrInvalidTransform     = errors.New("bursary: invalid rule transform")
+	ErrRuleViolatesUpstream = errors.New("bursary: rule violates rule of upstream")
+)
+
+type TransformMode string
+
+const (
+	TransformSet   TransformMode = "set"   // set shares to values
+	TransformScale TransformMode = "scale" // set shares to values times those of upstream
+	TransformCap   TransformMode = "cap"   // lower shares exceeding those of upstream
+)
+
+// RuleTransform tells how ApplyRuleToSubtree changes rules. Commission and
+// Share are values for TransformSet and factors for TransformScale, while
+// ReturnedShare is only set by TransformSet. Other fields of rules are kept.
+type RuleTransform struct {
+	Mode          TransformMode `json:"mode"`
+	Commission    float64       `json:"commission"`
+	Share         float64       `json:"share"`
+	ReturnedShare float64       `json:"returned_share"`
+
+	// DryRun only returns changes without applying them
+	DryRun bool `json:"dry_run"`
+}
+
+// RuleChange is the rule of a member before and after a transform.
+type RuleChange struct {
+	MemberID string `json:"member_id"`
+	Before   *Rule  `json:"before,omitempty"` // nil if member had no rule for channel
+	After    *Rule  `json:"after"`
+}
+
+// roundShare drops errors of float arithmetic from shares.
+func roundShare(v float64) float64 {
+	return math.Round(v*1e9) / 1e9
+}
+
+// apply returns rule r transformed below rule of upstream. Members without
+// rule of upstream keep their rules in TransformScale and TransformCap, and
+// nil is returned for members without rule, which keep inheriting rules.
+func (tr *RuleTransform) apply(r *Rule, upstream *Rule) *Rule {
+
+	if r == nil && tr.Mode != TransformSet {
+		return nil
+	}
+
+	nr := &Rule{}
+	if r != nil {
+		nr = r.clone()
+	}
+
+	switch tr.Mode {
+	case TransformSet:
+		nr.Commission = tr.Commission
+		nr.Share = tr.Share
+		nr.ReturnedShare = tr.ReturnedShare
+	case TransformScale:
+		if upstream != nil {
+			nr.Commission = roundShare(upstream.Commission * tr.Commission)
+			nr.Share = roundShare(upstream.Share * tr.Share)
+		}
+	case TransformCap:
+		if upstream != nil {
+			nr.Commission = minFloat(nr.Commission, upstream.Commission)
+
+			// Leave room for the share returned to upstream
+			nr.Share = minFloat(nr.Share, roundShare(upstream.Share-nr.ReturnedShare))
+		}
+	}
+
+	return nr
+}
+
+func sameShares(r *Rule, o *Rule) bool {
+	return r != nil && r.Commission == o.Commission && r.Share == o.Share && r.ReturnedShare == o.ReturnedShare
+}
+
+// checkUpstreamRule reports whether r keeps invariants of rules below
+// upstream: commission and share plus returned share can't exceed those of
+// upstream, nor ceilings set above.
+func checkUpstreamRule(r *Rule, upstream *Rule, ceiling *RuleCeiling) bool {
+
+	if r.Commission < 0 || r.Share < 0 || r.ReturnedShare < 0 {
+		return false
+	}
+
+	if r.Commission > 1 || roundShare(r.Share+r.ReturnedShare) > 1 {
+		return false
+	}
+
+	if upstream != nil {
+
+		if r.Commission > upstream.Commission {
+			return false
+		}
+
+		if roundShare(r.Share+r.ReturnedShare) > upstream.Share {
+			return false
+		}
+	}
+
+	return !ceiling.exceededBy(r)
+}
+
+func (b *bursary) ApplyRuleToSubtree(rootMid string, channel string, transform *RuleTransform) ([]*RuleChange, error) {
+	return b.ApplyRuleToSubtreeContext(context.Background(), rootMid, channel, transform)
+}
+
+// ApplyRuleToSubtreeContext transforms rules of channel for member rootMid
+// and its whole downline, from the top down so that every member is
+// transformed below the new rule of its upstream. It fails with
+// ErrRuleViolatesUpstream if any new rule breaks invariants, and otherwise
+// updates all rules atomically, unless it's a dry run.
+func (b *bursary) ApplyRuleToSubtreeContext(ctx context.Context, rootMid string, channel string, transform *RuleTransform) ([]*RuleChange, error) {
+
+	if transform == nil {
+		return nil, ErrInvalidTransform
+	}
+
+	switch transform.Mode {
+	case TransformSet, TransformScale, TransformCap:
+	default:
+		return nil, ErrInvalidTransform
+	}
+
+	root, err := b.rm.GetMemberContext(ctx, rootMid)
+	if err != nil {
+		return nil, err
+	}
+
+	// New rules and ceilings of members, which rules of their downstreams
+	// are checked against
+	rules := make(map[string]*Rule)
+	ceilings := make(map[string]*RuleCeiling)
+
+	if len(root.Upstream) > 0 {
+
+		upstream, err := b.rm.GetMemberContext(ctx, root.Upstream)
+		if err != nil {
+			return nil, err
+		}
+
+		rules[upstream.ID] = upstream.GetChannelRule(channel)
+
+		ceilings[upstream.ID], err = b.ceilingAbove(ctx, rootMid, channel)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	changes := make([]*RuleChange, 0)
+	updates := make(map[string]*Rule)
+
+	visit := func(m *Member) error {
+
+		before := m.GetChannelRule(channel)
+		after := transform.apply(before, rules[m.Upstream])
+		if after == nil {
+			ceilings[m.ID] = ceilings[m.Upstream]
+			return nil
+		}
+
+		if !checkUpstreamRule(after, rules[m.Upstream], ceilings[m.Upstream]) {
+			return fmt.Errorf("%w: member %s", ErrRuleViolatesUpstream, m.ID)
+		}
+
+		rules[m.ID] = after
+		ceilings[m.ID] = ceilings[m.Upstream].lower(after.Ceiling)
+
+		// Unchanged rules may be inherited, so they're left as they are
+		if !sameShares(before, after) {
+			updates[m.ID] = after
+		}
+
+		c := &RuleChange{
+			MemberID: m.ID,
+			After:    after,
+		}
+
+		if before != nil {
+			c.Before = before.clone()
+		}
+
+		changes = append(changes, c)
+
+		return nil
+	}
+
+	if err := visit(root); err != nil {
+		return nil, err
+	}
+
+	err = walkDownline(ctx, b.rm, rootMid, visit)
+	if err != nil {
+		return nil, err
+	}
+
+	if transform.DryRun {
+		return changes, nil
+	}
+
+	err = b.rm.UpdateChannelRulesContext(ctx, channel, updates)
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
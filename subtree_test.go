@@ -0,0 +1,185 @@
+package bursary
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func assertTestShares(t *testing.T, bu Bursary, mid string, commission float64, share float64) {
+
+	r := getTestRule(t, bu, mid, "default")
+	if assert.NotNil(t, r) {
+		assert.Equal(t, commission, r.Commission)
+		assert.Equal(t, share, r.Share)
+	}
+}
+
+func Test_ApplyRuleToSubtree_Set(t *testing.T) {
+
+	bu := NewBursary()
+	rules := newLimitTestRules()
+	rules[2].TicketCap = 100
+	ids := addTestChain(t, bu.RelationManager(), "default", rules...)
+
+	changes, err := bu.ApplyRuleToSubtree(ids[1], "default", &RuleTransform{
+		Mode:       TransformSet,
+		Commission: 0.6,
+		Share:      0.5,
+	})
+	if assert.Nil(t, err) && assert.Len(t, changes, 2) {
+		assert.Equal(t, ids[1], changes[0].MemberID)
+		assert.Equal(t, 0.7, changes[0].Before.Commission)
+		assert.Equal(t, 0.6, changes[0].After.Commission)
+		assert.Equal(t, ids[2], changes[1].MemberID)
+	}
+
+	assertTestShares(t, bu, ids[0], 0.9, 0.8)
+	assertTestShares(t, bu, ids[1], 0.6, 0.5)
+	assertTestShares(t, bu, ids[2], 0.6, 0.5)
+
+	// Other fields are kept
+	assert.Equal(t, int64(100), getTestRule(t, bu, ids[2], "default").TicketCap)
+}
+
+func Test_ApplyRuleToSubtree_Scale(t *testing.T) {
+
+	bu := NewBursary()
+	ids := addTestChain(t, bu.RelationManager(), "default", newLimitTestRules()...)
+
+	changes, err := bu.ApplyRuleToSubtree(ids[0], "default", &RuleTransform{
+		Mode:       TransformScale,
+		Commission: 0.5,
+		Share:      0.5,
+	})
+	if assert.Nil(t, err) {
+		assert.Len(t, changes, 3)
+	}
+
+	// The top level has no upstream to scale from
+	assertTestShares(t, bu, ids[0], 0.9, 0.8)
+	assertTestShares(t, bu, ids[1], 0.45, 0.4)
+	assertTestShares(t, bu, ids[2], 0.225, 0.2)
+}
+
+func Test_ApplyRuleToSubtree_Cap(t *testing.T) {
+
+	bu := NewBursary()
+	rules := newLimitTestRules()
+	rules[2].Commission = 0.8
+	rules[2].Share = 0.7
+	rules[2].ReturnedShare = 0.1
+	ids := addTestChain(t, bu.RelationManager(), "default", rules...)
+
+	_, err := bu.ApplyRuleToSubtree(ids[1], "default", &RuleTransform{
+		Mode: TransformCap,
+	})
+	assert.Nil(t, err)
+
+	assertTestShares(t, bu, ids[1], 0.7, 0.6)
+
+	// Share returned to upstream is left room for
+	assertTestShares(t, bu, ids[2], 0.7, 0.5)
+}
+
+func Test_ApplyRuleToSubtree_Violation(t *testing.T) {
+
+	bu := NewBursary()
+	rules := newLimitTestRules()
+	rules[0].Ceiling = &RuleCeiling{Commission: 0.7, Share: 0.6}
+	ids := addTestChain(t, bu.RelationManager(), "default", rules...)
+
+	_, err := bu.ApplyRuleToSubtree(ids[1], "default", &RuleTransform{
+		Mode:       TransformSet,
+		Commission: 0.95,
+		Share:      0.5,
+	})
+	assert.ErrorIs(t, err, ErrRuleViolatesUpstream)
+
+	// Ceilings set above are kept
+	_, err = bu.ApplyRuleToSubtree(ids[1], "default", &RuleTransform{
+		Mode:       TransformSet,
+		Commission: 0.8,
+		Share:      0.5,
+	})
+	assert.ErrorIs(t, err, ErrRuleViolatesUpstream)
+
+	// Nothing is changed
+	assertTestShares(t, bu, ids[1], 0.7, 0.6)
+	assertTestShares(t, bu, ids[2], 0.5, 0.3)
+
+	_, err = bu.ApplyRuleToSubtree(ids[1], "default", &RuleTransform{Mode: "unknown"})
+	assert.ErrorIs(t, err, ErrInvalidTransform)
+
+	_, err = bu.ApplyRuleToSubtree(genTestID(), "default", &RuleTransform{Mode: TransformCap})
+	assert.ErrorIs(t, err, ErrMemberNotFound)
+}
+
+func Test_ApplyRuleToSubtree_DryRun(t *testing.T) {
+
+	bu := NewBursary()
+	ids := addTestChain(t, bu.RelationManager(), "default", newLimitTestRules()...)
+
+	changes, err := bu.ApplyRuleToSubtree(ids[0], "default", &RuleTransform{
+		Mode:       TransformSet,
+		Commission: 0.4,
+		Share:      0.3,
+		DryRun:     true,
+	})
+	if assert.Nil(t, err) && assert.Len(t, changes, 3) {
+		assert.Equal(t, 0.3, changes[2].Before.Share)
+		assert.Equal(t, 0.3, changes[2].After.Share)
+		assert.Equal(t, 0.8, changes[0].Before.Share)
+		assert.Equal(t, 0.3, changes[0].After.Share)
+	}
+
+	assertTestShares(t, bu, ids[0], 0.9, 0.8)
+	assertTestShares(t, bu, ids[1], 0.7, 0.6)
+}
+
+func Test_ApplyRuleToSubtree_Inherited(t *testing.T) {
+
+	bu := NewBursary()
+	ids := addTestChain(t, bu.RelationManager(), "default", newLimitTestRules()...)
+
+	rm := bu.RelationManager()
+	assert.Nil(t, rm.RemoveChannelRule(ids[2], "default"))
+	assert.Nil(t, rm.UpdateChannelRule(ids[2], WildcardChannel, &Rule{Commission: 0.5, Share: 0.3}))
+
+	// Member without any rule
+	player := genTestID()
+	err := rm.AddMembers([]*MemberEntry{
+		&MemberEntry{ID: player},
+	}, ids[2])
+	assert.Nil(t, err)
+
+	changes, err := bu.ApplyRuleToSubtree(ids[1], "default", &RuleTransform{
+		Mode: TransformCap,
+	})
+	if assert.Nil(t, err) {
+		assert.Len(t, changes, 2)
+	}
+
+	// Nothing shadows the wildcard rule
+	m, err := rm.GetMember(ids[2])
+	if assert.Nil(t, err) {
+		assert.Nil(t, m.ChannelRules["default"])
+		assert.Equal(t, 0.5, m.GetChannelRule("default").Commission)
+	}
+
+	m, err = rm.GetMember(player)
+	if assert.Nil(t, err) {
+		assert.Nil(t, m.GetChannelRule("default"))
+	}
+
+	// Inherited rules exceeding upstream are lowered for the channel only
+	assert.Nil(t, rm.UpdateChannelRule(ids[1], "default", &Rule{Commission: 0.3, Share: 0.2}))
+
+	_, err = bu.ApplyRuleToSubtree(ids[1], "default", &RuleTransform{
+		Mode: TransformCap,
+	})
+	assert.Nil(t, err)
+
+	assertTestShares(t, bu, ids[2], 0.3, 0.2)
+	assert.Equal(t, 0.5, getTestRule(t, bu, ids[2], WildcardChannel).Commission)
+}
@@ -15,6 +15,8 @@ type Ticket struct {
 	Amount    int64                  `json:"amount"` // Amount = Income - Expense
 	Fee       int64                  `json:"fee"`
 	Total     int64                  `json:"total"` // Amount + Fee
+	Currency  string                 `json:"currency,omitempty"`
+	Event     string                 `json:"event,omitempty"` // such as "first_deposit" for bonuses
 	Desc      string                 `json:"desc"`
 	Info      map[string]interface{} `json:"info"`
 	CreatedAt time.Time              `json:"created_at"`
@@ -0,0 +1,100 @@
+package bursary
+
+import "context"
+
+// TierVolume tells which volume of tickets selects tiers.
+type TierVolume string
+
+const (
+	TierVolumeAmount TierVolume = "amount"
+	TierVolumeFee    TierVolume = "fee"
+)
+
+// TierInfoKey is the key of LedgerEntry.Info telling the tier applied to
+// entry, as a map of "index", "threshold" and "volume".
+const TierInfoKey = "tier"
+
+// Tier replaces shares of a rule once volume of the period reaches Threshold.
+type Tier struct {
+	Threshold     int64   `json:"threshold"`
+	Commission    float64 `json:"commission"`
+	Share         float64 `json:"share"`
+	ReturnedShare float64 `json:"returned_share"`
+}
+
+func (tv TierVolume) value(le *LedgerEntry) int64 {
+
+	if tv == TierVolumeFee {
+		return le.Fee
+	}
+
+	return le.Amount
+}
+
+// selectTier returns index of the tier with the highest threshold reached by
+// volume, or -1 if no tier is reached.
+func (r *Rule) selectTier(volume int64) int {
+
+	idx := -1
+	for i, tier := range r.Tiers {
+		if volume >= tier.Threshold && (idx < 0 || tier.Threshold > r.Tiers[idx].Threshold) {
+			idx = i
+		}
+	}
+
+	return idx
+}
+
+// applyTier returns rule with shares of the tier reached by period-to-date
+// volume of member, and records the tier to le. Volume is the sum of ticket
+// amounts or fees of the member and its downline in channels of scope and
+// currency of ticket.
+func (b *bursary) applyTier(ctx context.Context, t *Ticket, scope string, le *LedgerEntry, r *Rule) (*Rule, error) {
+
+	if len(r.Tiers) == 0 {
+		return r, nil
+	}
+
+	volume, err := b.sumSince(ctx, t, scope, le.MemberID, r.Period.Start(t.CreatedAt), r.TierVolume.value)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := r.selectTier(volume)
+	if idx < 0 {
+		return r, nil
+	}
+
+	tier := r.Tiers[idx]
+
+	tr := *r
+	tr.Commission = tier.Commission
+	tr.Share = tier.Share
+	tr.ReturnedShare = tier.ReturnedShare
+
+	le.Share = tr.Share
+	le.CommissionShare = tr.Commission
+
+	setInfo(le, TierInfoKey, map[string]interface{}{
+		"index":     idx,
+		"threshold": tier.Threshold,
+		"volume":    volume,
+	})
+
+	return &tr, nil
+}
+
+func cloneTiers(tiers []*Tier) []*Tier {
+
+	if tiers == nil {
+		return nil
+	}
+
+	c := make([]*Tier, 0, len(tiers))
+	for _, tier := range tiers {
+		ct := *tier
+		c = append(c, &ct)
+	}
+
+	return c
+}
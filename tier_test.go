@@ -0,0 +1,127 @@
+package bursary
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Rule_SelectTier(t *testing.T) {
+
+	r := &Rule{
+		Tiers: []*Tier{
+			{Threshold: 5000},
+			{Threshold: 1000},
+			{Threshold: 10000},
+		},
+	}
+
+	assert.Equal(t, -1, r.selectTier(999))
+	assert.Equal(t, 1, r.selectTier(1000))
+	assert.Equal(t, 0, r.selectTier(9999))
+	assert.Equal(t, 2, r.selectTier(10000))
+}
+
+func Test_CalculateRewards_Tiers(t *testing.T) {
+
+	bu := NewBursary()
+	defer bu.Close()
+
+	rules := newLimitTestRules()
+	rules[1].Tiers = []*Tier{
+		{Threshold: 2000, Commission: 0.7, Share: 0.7},
+		{Threshold: 5000, Commission: 0.8, Share: 0.75},
+	}
+	ids := addTestChain(t, bu.RelationManager(), "default", rules...)
+
+	// Base shares below the lowest threshold
+	for i := 0; i < 2; i++ {
+
+		entries, err := bu.CalculateRewards(newLimitTestTicket(ids[2]))
+		if assert.Nil(t, err) {
+			assert.Equal(t, int64(300), entries[1].Gain)
+			assert.Nil(t, entries[1].Info[TierInfoKey])
+		}
+
+		assert.Nil(t, bu.WriteEntries(GeneralLedgerName, entries))
+	}
+
+	entries, err := bu.CalculateRewards(newLimitTestTicket(ids[2]))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	mid := entries[1]
+	assert.Equal(t, 0.7, mid.Share)
+	assert.Equal(t, int64(400), mid.Gain)
+	assert.Equal(t, int64(20), mid.Commissions)
+	assert.Equal(t, map[string]interface{}{
+		"index":     0,
+		"threshold": int64(2000),
+		"volume":    int64(2000),
+	}, mid.Info[TierInfoKey])
+
+	// Top-level member gets less
+	assert.Equal(t, int64(300), entries[2].Gain)
+
+	// Volume of other members isn't affected
+	assert.Nil(t, entries[0].Info[TierInfoKey])
+}
+
+func Test_CalculateRewards_TierVolumeFee(t *testing.T) {
+
+	bu := NewBursary()
+	defer bu.Close()
+
+	rules := newLimitTestRules()
+	rules[2].TierVolume = TierVolumeFee
+	rules[2].Tiers = []*Tier{
+		{Threshold: 100, Commission: 0.6, Share: 0.4},
+	}
+	ids := addTestChain(t, bu.RelationManager(), "default", rules...)
+
+	assert.Nil(t, bu.WriteTicket(newLimitTestTicket(ids[2])))
+
+	entries, err := bu.CalculateRewards(newLimitTestTicket(ids[2]))
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(400), entries[0].Gain)
+		assert.Equal(t, int64(60), entries[0].Commissions)
+		assert.Equal(t, 0.6, entries[0].CommissionShare)
+	}
+}
+
+func Test_CalculateRewards_TierVolumeFeeUpstream(t *testing.T) {
+
+	bu := NewBursary()
+	defer bu.Close()
+
+	rules := newLimitTestRules()
+	rules[1].TierVolume = TierVolumeFee
+	rules[1].Tiers = []*Tier{
+		{Threshold: 200, Commission: 0.8, Share: 0.7},
+	}
+	ids := addTestChain(t, bu.RelationManager(), "default", rules...)
+
+	// Fee of the downline counts for the upstream agent
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, bu.WriteTicket(newLimitTestTicket(ids[2])))
+	}
+
+	entries, err := bu.CalculateRewards(newLimitTestTicket(ids[2]))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	mid := entries[1]
+	assert.Equal(t, int64(100), mid.Fee)
+	assert.Equal(t, 0.8, mid.CommissionShare)
+	assert.Equal(t, int64(30), mid.Commissions)
+	assert.Equal(t, int64(400), mid.Gain)
+	assert.Equal(t, map[string]interface{}{
+		"index":     0,
+		"threshold": int64(200),
+		"volume":    int64(200),
+	}, mid.Info[TierInfoKey])
+
+	assert.Equal(t, int64(100), entries[2].Fee)
+}
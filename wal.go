@@ -0,0 +1,122 @@
+package bursary
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// WAL is an append-only write-ahead log used by memory backends to recover
+// changes made after the latest snapshot. Every record is a line of JSON.
+type WAL struct {
+	mutex sync.Mutex
+	f     *os.File
+}
+
+type walRecord struct {
+	Op   string          `json:"op"`
+	Data json.RawMessage `json:"data"`
+}
+
+// OpenWAL opens or creates the log file at path.
+func OpenWAL(path string) (*WAL, error) {
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WAL{
+		f: f,
+	}, nil
+}
+
+// Append writes a record and flushes it to disk before returning.
+func (w *WAL) Append(op string, data interface{}) error {
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(&walRecord{
+		Op:   op,
+		Data: raw,
+	})
+	if err != nil {
+		return err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	_, err = w.f.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+
+	return w.f.Sync()
+}
+
+// Replay calls fn for every record in the order of writing. A torn record at
+// the end of log, left by a crash in the middle of writing, is discarded.
+func (w *WAL) Replay(fn func(op string, data json.RawMessage) error) error {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	_, err := w.f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	offset := int64(0)
+	r := bufio.NewReader(w.f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+
+			if len(line) == 0 {
+				return nil
+			}
+
+			// Incomplete record without line ending
+			return w.f.Truncate(offset)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		var rec walRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+
+		if err := fn(rec.Op, rec.Data); err != nil {
+			return err
+		}
+
+		offset += int64(len(line))
+	}
+}
+
+// Truncate discards all records, usually right after a snapshot was taken.
+func (w *WAL) Truncate() error {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	err := w.f.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	return w.f.Sync()
+}
+
+func (w *WAL) Close() error {
+	return w.f.Close()
+}